@@ -0,0 +1,366 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// keyResponse is the JSON representation of an API key returned by the admin API. The plain key
+// is only included right after it was generated.
+type keyResponse struct {
+	ID         string     `json:"id"`
+	Key        string     `json:"key,omitempty"`
+	Mode       string     `json:"mode"`
+	Hint       string     `json:"hint"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// merchantResponse is the JSON representation of a merchant returned by the admin API
+type merchantResponse struct {
+	ID              string        `json:"id"`
+	Name            string        `json:"name"`
+	WebhookSecret   string        `json:"webhook_secret,omitempty"`
+	WebhookInsecure bool          `json:"webhook_insecure"`
+	Keys            []keyResponse `json:"keys"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// newKeyResponse converts an API key into its JSON representation
+func newKeyResponse(apiKey merchants.APIKey, now time.Time) keyResponse {
+	return keyResponse{
+		ID:         apiKey.ID.Hex(),
+		Mode:       string(apiKey.Mode),
+		Hint:       apiKey.Hint,
+		Status:     string(apiKey.Status(now)),
+		CreatedAt:  apiKey.CreatedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		RevokedAt:  apiKey.RevokedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+	}
+}
+
+// newMerchantResponse converts a merchant into its JSON representation
+func newMerchantResponse(merchant merchants.Merchant) merchantResponse {
+	now := time.Now()
+	keys := make([]keyResponse, 0, len(merchant.Keys))
+	for _, apiKey := range merchant.Keys {
+		keys = append(keys, newKeyResponse(apiKey, now))
+	}
+	return merchantResponse{
+		ID:              merchant.ID.Hex(),
+		Name:            merchant.Name,
+		WebhookSecret:   merchant.WebhookSecret,
+		WebhookInsecure: merchant.WebhookInsecure,
+		Keys:            keys,
+		CreatedAt:       merchant.CreatedAt,
+		UpdatedAt:       merchant.UpdatedAt,
+	}
+}
+
+// authenticateAdmin checks the bearer token against ADMIN_KEY in constant time. It answers the
+// request and returns false when the admin API is disabled or the token does not match.
+func authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	// Without ADMIN_KEY nobody can use the admin API
+	if os.Getenv("ADMIN_KEY") == "" {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "The admin API is disabled, set ADMIN_KEY to enable it")
+		return false
+	}
+
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !adminKeyMatches(key) {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return false
+	}
+	return true
+}
+
+// adminKeyMatches reports whether the given key is ADMIN_KEY, no key matches while it is empty. The
+// hashes are compared so neither the contents nor the length of the key leak through timing.
+func adminKeyMatches(key string) bool {
+	adminKey := os.Getenv("ADMIN_KEY")
+	if adminKey == "" {
+		return false
+	}
+	given, expected := sha256.Sum256([]byte(key)), sha256.Sum256([]byte(adminKey))
+	return subtle.ConstantTimeCompare(given[:], expected[:]) == 1
+}
+
+// parseObjectID parses the route variable with the given name as an object ID. It answers the
+// request with 404 and returns false when the variable is not a valid ID.
+func parseObjectID(w http.ResponseWriter, r *http.Request, name, message string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)[name])
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, message)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// writeKeyError responds to a request whose change to a merchant or API key failed
+func writeKeyError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	var fieldErrors validation.Errors
+	switch {
+	case errors.Is(err, merchants.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Merchant not found")
+	case errors.Is(err, merchants.ErrKeyNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "API key not found")
+	case errors.Is(err, merchants.ErrKeyInactive):
+		writeError(w, r, http.StatusConflict, codeKeyInactive, fmt.Sprintf("Failed to %s API key: %v", action, err))
+	case errors.As(err, &fieldErrors):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid API key input", fieldErrors...)
+	default:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Failed to %s API key", action))
+	}
+}
+
+// ListMerchants returns every merchant with its API keys
+func ListMerchants(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+
+	found, err := merchants.List(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to list merchants")
+		return
+	}
+
+	response := make([]merchantResponse, 0, len(found))
+	for _, merchant := range found {
+		response = append(response, newMerchantResponse(merchant))
+	}
+	logStatus(r, http.StatusOK, fmt.Sprintf("Listed %d merchants", len(response)))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// CreateMerchant creates a merchant with a first API key, which is only returned this once
+func CreateMerchant(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+
+	// Parse and validate the merchant
+	var merchantInput merchants.MerchantInput
+	if err := decodeJSON(w, r, &merchantInput); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	var fieldErrors validation.Errors
+	if err := merchantInput.Validate(time.Now()); errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid merchant input", fieldErrors...)
+		return
+	}
+
+	// Create the merchant together with its key and webhook secret
+	merchant := merchants.Create(merchantInput.Name)
+	merchant.WebhookInsecure = merchantInput.WebhookInsecure
+	key, apiKey, err := merchant.NewKey(merchantInput.KeyMode(), merchant.CreatedAt)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to generate API key")
+		return
+	}
+	if err := merchant.NewWebhookSecret(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to generate webhook secret")
+		return
+	}
+	apiKey.ExpiresAt = merchantInput.ExpiresAt
+	if _, err := merchants.Insert(r.Context(), &merchant); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to insert merchant")
+		return
+	}
+
+	// Return the merchant with the plain key
+	response := newMerchantResponse(merchant)
+	response.Keys[0].Key = key
+	logStatus(r, http.StatusCreated, fmt.Sprintf("Merchant %s created", merchant.ID.Hex()))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// UpdateMerchant changes the name of a merchant or whether the certificates of its webhooks are verified
+func UpdateMerchant(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+	merchantID, ok := parseObjectID(w, r, "merchant_id", "Merchant not found")
+	if !ok {
+		return
+	}
+
+	// Parse and validate the settings
+	var settingsInput merchants.SettingsInput
+	if err := decodeJSON(w, r, &settingsInput); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	var fieldErrors validation.Errors
+	if err := settingsInput.Validate(); errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid merchant input", fieldErrors...)
+		return
+	}
+
+	// Apply the settings
+	merchant, err := merchants.Modify(r.Context(), merchantID, func(merchant *merchants.Merchant) error {
+		settingsInput.Apply(merchant)
+		return nil
+	})
+	if errors.Is(err, merchants.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Merchant not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update merchant")
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Merchant %s updated", merchant.ID.Hex()))
+	writeJSON(w, http.StatusOK, newMerchantResponse(*merchant))
+}
+
+// CreateKey adds an API key to a merchant, the plain key is only returned this once
+func CreateKey(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+	merchantID, ok := parseObjectID(w, r, "merchant_id", "Merchant not found")
+	if !ok {
+		return
+	}
+
+	// Parse and validate the key, an empty body creates a test key that does not expire
+	var keyInput merchants.KeyInput
+	if err := decodeJSON(w, r, &keyInput); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := keyInput.Validate(time.Now()); err != nil {
+		writeKeyError(w, r, err, "create")
+		return
+	}
+
+	// Generate and store the key
+	key, apiKey, err := merchants.AddKey(r.Context(), merchantID, keyInput.KeyMode(), keyInput.ExpiresAt)
+	if err != nil {
+		writeKeyError(w, r, err, "create")
+		return
+	}
+
+	response := newKeyResponse(*apiKey, time.Now())
+	response.Key = key
+	logStatus(r, http.StatusCreated, fmt.Sprintf("API key %s created", apiKey.ID.Hex()))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// parseKeyIDs parses the merchant and key IDs of an API key route
+func parseKeyIDs(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, bool) {
+	merchantID, ok := parseObjectID(w, r, "merchant_id", "Merchant not found")
+	if !ok {
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	keyID, ok := parseObjectID(w, r, "key_id", "API key not found")
+	if !ok {
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return merchantID, keyID, true
+}
+
+// ExpireKey sets when an active API key stops working, a time in the past expires it right away
+func ExpireKey(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+	merchantID, keyID, ok := parseKeyIDs(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse and validate the expiry
+	var expireInput merchants.ExpireInput
+	if err := decodeJSON(w, r, &expireInput); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := expireInput.Validate(); err != nil {
+		writeKeyError(w, r, err, "expire")
+		return
+	}
+
+	apiKey, err := merchants.ExpireKey(r.Context(), merchantID, keyID, *expireInput.ExpiresAt)
+	if err != nil {
+		writeKeyError(w, r, err, "expire")
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("API key %s expires at %s", keyID.Hex(), apiKey.ExpiresAt.Format(time.RFC3339)))
+	writeJSON(w, http.StatusOK, newKeyResponse(*apiKey, time.Now()))
+}
+
+// RotateKey replaces an active API key by a new one. The old key keeps working during the
+// overlap, the plain new key is only returned this once.
+func RotateKey(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+	merchantID, keyID, ok := parseKeyIDs(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse and validate the overlap, an empty body uses the default
+	var rotateInput merchants.RotateInput
+	if err := decodeJSON(w, r, &rotateInput); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := rotateInput.Validate(); err != nil {
+		writeKeyError(w, r, err, "rotate")
+		return
+	}
+
+	key, apiKey, err := merchants.RotateKey(r.Context(), merchantID, keyID, rotateInput.OverlapDuration())
+	if err != nil {
+		writeKeyError(w, r, err, "rotate")
+		return
+	}
+
+	response := newKeyResponse(*apiKey, time.Now())
+	response.Key = key
+	logStatus(r, http.StatusCreated, fmt.Sprintf("API key %s rotated to %s", keyID.Hex(), apiKey.ID.Hex()))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// RevokeKey makes an API key stop working right away
+func RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if !authenticateAdmin(w, r) {
+		return
+	}
+	merchantID, keyID, ok := parseKeyIDs(w, r)
+	if !ok {
+		return
+	}
+
+	apiKey, err := merchants.RevokeKey(r.Context(), merchantID, keyID)
+	if err != nil {
+		writeKeyError(w, r, err, "revoke")
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("API key %s revoked", keyID.Hex()))
+	writeJSON(w, http.StatusOK, newKeyResponse(*apiKey, time.Now()))
+}
@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// statusChangeResponse represents a transition in the JSON representation of a transaction
+type statusChangeResponse struct {
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Forced bool      `json:"forced,omitempty"`
+}
+
+// deliveryResponse represents the state of a webhook delivery in the JSON representation of a transaction
+type deliveryResponse struct {
+	ID             string     `json:"id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// transactionResponse is the JSON representation of a transaction returned by the API
+type transactionResponse struct {
+	ID             string                 `json:"id"`
+	Amount         money.Money            `json:"amount"`
+	AmountCaptured *money.Money           `json:"amount_captured,omitempty"`
+	AmountRefunded money.Money            `json:"amount_refunded"`
+	CaptureMethod  string                 `json:"capture_method"`
+	Status         string                 `json:"status"`
+	Reference      string                 `json:"reference,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+	ExpiresAt      *time.Time             `json:"expires_at,omitempty"`
+	History        []statusChangeResponse `json:"history"`
+	Refunds        []refundResponse       `json:"refunds"`
+	CheckoutURL    string                 `json:"checkout_url"`
+	RedirectURL    string                 `json:"redirect_url"`
+	WebhookURL     string                 `json:"webhook_url"`
+	Webhooks       []deliveryResponse     `json:"webhooks"`
+}
+
+// listResponse is the JSON representation of a page of transactions
+type listResponse struct {
+	Data       []transactionResponse `json:"data"`
+	HasMore    bool                  `json:"has_more"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+const (
+	// defaultPageSize is the number of transactions listed when no limit is given
+	defaultPageSize = 20
+
+	// maxPageSize is the maximum number of transactions listed at once
+	maxPageSize = 100
+)
+
+// writeJSON responds to the request with the JSON encoding of data
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// newDeliveryResponse converts a webhook delivery into its JSON representation
+func newDeliveryResponse(delivery deliveries.Delivery) deliveryResponse {
+	response := deliveryResponse{
+		ID:       delivery.ID.Hex(),
+		Event:    delivery.Event,
+		Status:   string(delivery.Status),
+		Attempts: len(delivery.Attempts),
+	}
+	if attempt := delivery.LastAttempt(); attempt != nil {
+		response.LastAttemptAt = &attempt.At
+		response.LastStatusCode = attempt.StatusCode
+		response.LastError = attempt.Error
+	}
+	if delivery.Status == deliveries.StatusQueued {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	return response
+}
+
+// newTransactionResponse converts a transaction and its webhook deliveries into their JSON representation
+func newTransactionResponse(r *http.Request, transaction *transactions.Transaction, webhooks []deliveries.Delivery) transactionResponse {
+	response := transactionResponse{
+		ID:             transaction.ID.Hex(),
+		Amount:         transaction.Amount,
+		AmountRefunded: transaction.RefundedAmount(),
+		CaptureMethod:  string(transaction.CaptureMethod),
+		Status:         string(transaction.Status),
+		Reference:      transaction.Reference,
+		CreatedAt:      transaction.Timestamp,
+		UpdatedAt:      transaction.UpdatedAt,
+		History:        []statusChangeResponse{},
+		Refunds:        []refundResponse{},
+		CheckoutURL:    checkoutURL(r, transaction.ID),
+		RedirectURL:    transaction.RedirectURL,
+		WebhookURL:     transaction.WebhookURL,
+		Webhooks:       []deliveryResponse{},
+	}
+	if response.CaptureMethod == "" {
+		response.CaptureMethod = string(transactions.CaptureAutomatic)
+	}
+	if transaction.Status == transactions.StatusPaid {
+		captured := transaction.PaidAmount()
+		response.AmountCaptured = &captured
+	}
+	if !transaction.ExpiresAt.IsZero() {
+		response.ExpiresAt = &transaction.ExpiresAt
+	}
+	for _, change := range transaction.History {
+		response.History = append(response.History, statusChangeResponse{From: string(change.From), To: string(change.To), At: change.At, Forced: change.Forced})
+	}
+	for _, refund := range transaction.Refunds {
+		response.Refunds = append(response.Refunds, newRefundResponse(transaction.ID, refund))
+	}
+	for _, delivery := range webhooks {
+		response.Webhooks = append(response.Webhooks, newDeliveryResponse(delivery))
+	}
+	return response
+}
+
+// lookupTransaction checks the API key and the transaction_id of a request and returns the
+// transaction, expiring it first when its expiry time passed. Transactions of other merchants are
+// reported as not found. It answers the request itself and returns nil when the transaction can
+// not be used.
+func lookupTransaction(w http.ResponseWriter, r *http.Request) *transactions.Transaction {
+	// Find the merchant making the request
+	merchant, _ := authenticate(w, r)
+	if merchant == nil {
+		return nil
+	}
+
+	// Parse the transaction_id to an objectID
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["transaction_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Incorrect URI")
+		return nil
+	}
+
+	// Get the transaction
+	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) || (err == nil && transaction.MerchantID != merchant.ID) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return nil
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
+		return nil
+	}
+
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to expire transaction")
+		return nil
+	}
+	return transaction
+}
+
+// GetTransaction returns the current state of a transaction and its webhook deliveries
+func GetTransaction(w http.ResponseWriter, r *http.Request) {
+	// Get the transaction of the calling merchant
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Get the webhook deliveries of the transaction
+	webhooks, err := deliveries.ListByTransaction(r.Context(), transaction.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook deliveries")
+		return
+	}
+
+	// Return the transaction
+	logStatus(r, http.StatusOK, "Served transaction")
+	writeJSON(w, http.StatusOK, newTransactionResponse(r, transaction, webhooks))
+}
+
+// parseListQuery parses the filters, cursor and limit of a listing request. Invalid parameters are
+// returned as validation.Errors.
+func parseListQuery(query url.Values) (transactions.Filter, primitive.ObjectID, int, error) {
+	var filter transactions.Filter
+	var cursor primitive.ObjectID
+	var errs validation.Errors
+	limit := defaultPageSize
+
+	// Parse the statuses, which may be given comma separated or as repeated parameters
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if !transactions.Status(status).Valid() {
+				errs.Add("status", validation.CodeInvalid, "unknown status %q", status)
+				continue
+			}
+			filter.Statuses = append(filter.Statuses, transactions.Status(status))
+		}
+	}
+
+	// Parse the currency and the amount range, which is written in that currency
+	filter.Currency = query.Get("currency")
+	currency := filter.Currency
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	if _, ok := money.Exponent(currency); !ok {
+		errs.Add("currency", validation.CodeInvalid, "unknown currency %q", currency)
+	} else {
+		for key, bound := range map[string]**int64{"amount_min": &filter.MinAmount, "amount_max": &filter.MaxAmount} {
+			if value := query.Get(key); value != "" {
+				amount, err := money.Parse(value, currency)
+				if err != nil {
+					errs.Add(key, validation.CodeInvalid, "%v", err)
+					continue
+				}
+				*bound = &amount.Value
+			}
+		}
+	}
+
+	// Parse the creation time range
+	for key, bound := range map[string]**time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if value := query.Get(key); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs.Add(key, validation.CodeInvalid, "%s must be an RFC 3339 timestamp", key)
+				continue
+			}
+			*bound = &at
+		}
+	}
+
+	filter.Reference = query.Get("reference")
+
+	// Parse the pagination
+	if value := query.Get("cursor"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			errs.Add("cursor", validation.CodeInvalid, "invalid cursor")
+		}
+		cursor = id
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			errs.Add("limit", validation.CodeOutOfRange, "limit must be between 1 and %d", maxPageSize)
+		}
+		limit = parsed
+	}
+
+	return filter, cursor, limit, errs.Err()
+}
+
+// ListTransactions returns a page of transactions matching the filters in the query string
+func ListTransactions(w http.ResponseWriter, r *http.Request) {
+	// Find the merchant making the request
+	merchant, _ := authenticate(w, r)
+	if merchant == nil {
+		return
+	}
+
+	// Parse the query string, only listing the transactions of the merchant
+	filter, cursor, limit, err := parseListQuery(r.URL.Query())
+	filter.MerchantID = merchant.ID
+	var fieldErrors validation.Errors
+	if errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid query", fieldErrors...)
+		return
+	}
+
+	// Get one transaction more than requested to find out if there is another page
+	found, err := transactions.List(r.Context(), filter, cursor, limit+1)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to list transactions")
+		return
+	}
+	response := listResponse{Data: []transactionResponse{}}
+	if len(found) > limit {
+		found = found[:limit]
+		response.HasMore = true
+		response.NextCursor = found[limit-1].ID.Hex()
+	}
+
+	// Add the webhook deliveries of every transaction, looked up for the whole page at once
+	ids := make([]primitive.ObjectID, len(found))
+	for i := range found {
+		ids[i] = found[i].ID
+	}
+	webhooks, err := deliveries.ListByTransactions(r.Context(), ids)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook deliveries")
+		return
+	}
+	for i := range found {
+		response.Data = append(response.Data, newTransactionResponse(r, &found[i], webhooks[found[i].ID]))
+	}
+
+	// Return the page
+	logStatus(r, http.StatusOK, fmt.Sprintf("Listed %d transactions", len(response.Data)))
+	writeJSON(w, http.StatusOK, response)
+}
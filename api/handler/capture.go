@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// respondTransaction notifies the webhook about the new status of the transaction and returns it
+func respondTransaction(w http.ResponseWriter, r *http.Request, transaction *transactions.Transaction) {
+	// Queue a notification for the webhook, it is delivered and retried in the background
+	if _, err := webhook.Enqueue(r.Context(), transaction); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
+
+	// Get the webhook deliveries of the transaction
+	webhooks, err := deliveries.ListByTransaction(r.Context(), transaction.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook deliveries")
+		return
+	}
+
+	// Return the transaction
+	logStatus(r, http.StatusOK, fmt.Sprintf("Transaction %s", transaction.Status))
+	writeJSON(w, http.StatusOK, newTransactionResponse(r, transaction, webhooks))
+}
+
+// CaptureTransaction pays an authorized transaction with manual capture, for at most the authorized amount
+func CaptureTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Parse and validate the capture, an empty body captures the full amount
+	var captureInput transactions.CaptureInput
+	if err := decodeJSON(w, r, &captureInput); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+	var fieldErrors validation.Errors
+	if err := captureInput.Validate(); errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid capture input", fieldErrors...)
+		return
+	}
+
+	// Capture the transaction
+	transaction, err := transactions.Capture(r.Context(), transaction.ID, captureInput.Amount)
+	switch {
+	case errors.Is(err, transactions.ErrIllegalTransition):
+		writeError(w, r, http.StatusConflict, codeIllegalTransition, "Only authorized transactions can be captured")
+		return
+	case errors.Is(err, transactions.ErrCurrencyMismatch):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid capture input",
+			validation.FieldError{Field: "amount.currency", Code: validation.CodeInvalid, Message: err.Error()})
+		return
+	case errors.Is(err, transactions.ErrCaptureExceeded):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid capture input",
+			validation.FieldError{Field: "amount.value", Code: validation.CodeOutOfRange, Message: err.Error()})
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to capture transaction")
+		return
+	}
+
+	respondTransaction(w, r, transaction)
+}
+
+// VoidTransaction releases an authorized transaction with manual capture without paying it
+func VoidTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Void the transaction
+	transaction, err := transactions.Void(r.Context(), transaction.ID)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeError(w, r, http.StatusConflict, codeIllegalTransition, "Only authorized transactions can be voided")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to void transaction")
+		return
+	}
+
+	respondTransaction(w, r, transaction)
+}
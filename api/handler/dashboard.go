@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/validation"
+	"dev-payment-gate/web/templates"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// csrfCookie is the cookie holding the token that dashboard forms have to repeat
+const csrfCookie = "admin_csrf"
+
+// timelineEntry is a single event on the timeline of a transaction in the dashboard
+type timelineEntry struct {
+	At          time.Time
+	Kind        string
+	Description string
+}
+
+// attemptView is a webhook attempt in the dashboard
+type attemptView struct {
+	Number int
+	deliveries.Attempt
+}
+
+// webhookView is a webhook delivery with its request body and attempts in the dashboard
+type webhookView struct {
+	deliveries.Delivery
+	Request  string
+	Attempts []attemptView
+}
+
+// authenticateDashboard checks the password of the HTTP Basic credentials against ADMIN_KEY. It
+// answers the request and returns false when the dashboard is disabled or the password is wrong.
+func authenticateDashboard(w http.ResponseWriter, r *http.Request) bool {
+	// Without ADMIN_KEY nobody can use the dashboard
+	if os.Getenv("ADMIN_KEY") == "" {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "The admin dashboard is disabled, set ADMIN_KEY to enable it")
+		return false
+	}
+
+	// Ask the browser for credentials until the password matches, any user name is accepted
+	_, password, ok := r.BasicAuth()
+	if !ok || !adminKeyMatches(password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dev-payment-gate admin", charset="UTF-8"`)
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return false
+	}
+
+	// Never cache admin pages
+	w.Header().Set("Cache-Control", "no-store")
+	return true
+}
+
+// csrfToken returns the CSRF token of the browser, setting a new one when it has none yet
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value, nil
+	}
+
+	// Generate a random token and store it in a cookie only the dashboard can read
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    hex.EncodeToString(token),
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	return hex.EncodeToString(token), nil
+}
+
+// checkCSRF reports whether a dashboard form repeats the CSRF token of the browser
+func checkCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf_token"))) == 1
+}
+
+// authenticateDashboardForm checks the credentials and the CSRF token of a dashboard form. It
+// answers the request and returns false when either is wrong.
+func authenticateDashboardForm(w http.ResponseWriter, r *http.Request) bool {
+	if !authenticateDashboard(w, r) {
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if !checkCSRF(r) {
+		writeError(w, r, http.StatusForbidden, codeInvalidRequest, "Invalid CSRF token, reload the page and try again")
+		return false
+	}
+	return true
+}
+
+// dashboardTransaction gets the transaction of a dashboard route. It answers the request and
+// returns nil when the transaction can not be found.
+func dashboardTransaction(w http.ResponseWriter, r *http.Request) *transactions.Transaction {
+	id, ok := parseObjectID(w, r, "transaction_id", "Transaction not found")
+	if !ok {
+		return nil
+	}
+
+	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return nil
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
+		return nil
+	}
+	return transaction
+}
+
+// prettyJSON indents a JSON document, other content is returned as is
+func prettyJSON(data []byte) string {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return string(data)
+	}
+	return indented.String()
+}
+
+// newTimeline merges the status changes, refunds and webhook attempts of a transaction in time order
+func newTimeline(transaction *transactions.Transaction, webhooks []deliveries.Delivery) []timelineEntry {
+	timeline := []timelineEntry{{At: transaction.Timestamp, Kind: "created", Description: fmt.Sprintf("Transaction of %s created", transaction.Amount.Format())}}
+
+	// Add the status changes
+	for _, change := range transaction.History {
+		description := fmt.Sprintf("Status changed from %s to %s", change.From, change.To)
+		if change.Forced {
+			description += " by an admin"
+		}
+		timeline = append(timeline, timelineEntry{At: change.At, Kind: "status", Description: description})
+	}
+
+	// Add the refunds and their outcome
+	for _, refund := range transaction.Refunds {
+		timeline = append(timeline, timelineEntry{At: refund.CreatedAt, Kind: "refund", Description: fmt.Sprintf("Refund of %s requested", refund.Amount.Format())})
+		if refund.Status != transactions.RefundPending {
+			timeline = append(timeline, timelineEntry{At: refund.UpdatedAt, Kind: "refund", Description: fmt.Sprintf("Refund of %s %s", refund.Amount.Format(), refund.Status)})
+		}
+	}
+
+	// Add the webhook deliveries and their attempts
+	for _, delivery := range webhooks {
+		timeline = append(timeline, timelineEntry{At: delivery.CreatedAt, Kind: "webhook", Description: fmt.Sprintf("Webhook %s queued", delivery.Event)})
+		for i, attempt := range delivery.Attempts {
+			result := attempt.Error
+			if result == "" {
+				result = fmt.Sprintf("delivered with status %d", attempt.StatusCode)
+			}
+			timeline = append(timeline, timelineEntry{At: attempt.At, Kind: "webhook", Description: fmt.Sprintf("Webhook %s attempt %d: %s", delivery.Event, i+1, result)})
+		}
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].At.Before(timeline[j].At)
+	})
+	return timeline
+}
+
+// DashboardRedirect sends visitors of /admin to the list of transactions
+func DashboardRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/admin/transactions", http.StatusSeeOther)
+}
+
+// DashboardTransactions renders the list of transactions of every merchant in the admin dashboard
+func DashboardTransactions(w http.ResponseWriter, r *http.Request) {
+	if !authenticateDashboard(w, r) {
+		return
+	}
+
+	// Parse the same filters as the JSON API
+	filter, cursor, limit, err := parseListQuery(r.URL.Query())
+	var fieldErrors validation.Errors
+	if errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid query", fieldErrors...)
+		return
+	}
+
+	// Get one transaction more than shown to find out if there is another page
+	found, err := transactions.List(r.Context(), filter, cursor, limit+1)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to list transactions")
+		return
+	}
+	nextCursor := ""
+	if len(found) > limit {
+		found = found[:limit]
+		nextCursor = found[limit-1].ID.Hex()
+	}
+
+	// Setup the dashboard page variables
+	data := struct {
+		Transactions []transactions.Transaction
+		Statuses     []transactions.Status
+		Selected     string
+		NextCursor   string
+	}{
+		Transactions: found,
+		Statuses:     transactions.Statuses,
+		Selected:     r.URL.Query().Get("status"),
+		NextCursor:   nextCursor,
+	}
+
+	// Render the dashboard page
+	w.Header().Set("Content-Type", "text/html")
+	if err := templates.RenderHTML(w, "admin_transactions.html", data); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to render HTML template")
+		return
+	}
+	logStatus(r, http.StatusOK, "Served admin dashboard")
+}
+
+// DashboardTransaction renders a transaction with its timeline and webhook attempts in the admin dashboard
+func DashboardTransaction(w http.ResponseWriter, r *http.Request) {
+	if !authenticateDashboard(w, r) {
+		return
+	}
+	transaction := dashboardTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Get the webhook deliveries of the transaction
+	webhooks, err := deliveries.ListByTransaction(r.Context(), transaction.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook deliveries")
+		return
+	}
+	views := make([]webhookView, 0, len(webhooks))
+	for _, delivery := range webhooks {
+		view := webhookView{Delivery: delivery, Request: prettyJSON(delivery.Payload)}
+		for i, attempt := range delivery.Attempts {
+			view.Attempts = append(view.Attempts, attemptView{Number: i + 1, Attempt: attempt})
+		}
+		views = append(views, view)
+	}
+
+	// Offer every status the transaction is not in yet
+	var statuses []transactions.Status
+	for _, status := range transactions.Statuses {
+		if status != transaction.Status {
+			statuses = append(statuses, status)
+		}
+	}
+
+	token, err := csrfToken(w, r)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to generate CSRF token")
+		return
+	}
+
+	// Setup the dashboard page variables
+	data := struct {
+		Transaction *transactions.Transaction
+		Timeline    []timelineEntry
+		Webhooks    []webhookView
+		Statuses    []transactions.Status
+		CSRFToken   string
+	}{
+		Transaction: transaction,
+		Timeline:    newTimeline(transaction, webhooks),
+		Webhooks:    views,
+		Statuses:    statuses,
+		CSRFToken:   token,
+	}
+
+	// Render the dashboard page
+	w.Header().Set("Content-Type", "text/html")
+	if err := templates.RenderHTML(w, "admin_transaction.html", data); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to render HTML template")
+		return
+	}
+	logStatus(r, http.StatusOK, "Served admin transaction")
+}
+
+// DashboardForceStatus moves a transaction to the status chosen in the dashboard, bypassing the
+// lifecycle, and notifies its webhook
+func DashboardForceStatus(w http.ResponseWriter, r *http.Request) {
+	if !authenticateDashboardForm(w, r) {
+		return
+	}
+	transaction := dashboardTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Force the chosen status
+	status := transactions.Status(r.PostFormValue("status"))
+	transaction, err := transactions.ForceStatus(r.Context(), transaction.ID, status)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Can not force status %q", status),
+			validation.FieldError{Field: "status", Code: validation.CodeInvalid, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update transaction")
+		return
+	}
+
+	// Queue a notification for the webhook, it is delivered and retried in the background
+	if _, err := webhook.Enqueue(r.Context(), transaction); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
+
+	logStatus(r, http.StatusSeeOther, fmt.Sprintf("Transaction %s forced by an admin", transaction.Status))
+	http.Redirect(w, r, fmt.Sprintf("/admin/transactions/%s", transaction.ID.Hex()), http.StatusSeeOther)
+}
+
+// DashboardResendWebhook queues a webhook delivery of a transaction for another attempt
+func DashboardResendWebhook(w http.ResponseWriter, r *http.Request) {
+	if !authenticateDashboardForm(w, r) {
+		return
+	}
+	transaction := dashboardTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+	deliveryID, ok := parseObjectID(w, r, "delivery_id", "Webhook delivery not found")
+	if !ok {
+		return
+	}
+
+	// Only resend deliveries of this transaction
+	delivery, err := deliveries.GetByID(r.Context(), deliveryID)
+	if errors.Is(err, deliveries.ErrNotFound) || (err == nil && delivery.TransactionID != transaction.ID) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Webhook delivery not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook delivery")
+		return
+	}
+	if _, err := webhook.Resend(r.Context(), delivery.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to resend webhook")
+		return
+	}
+
+	logStatus(r, http.StatusSeeOther, fmt.Sprintf("Webhook %s queued again by an admin", delivery.Event))
+	http.Redirect(w, r, fmt.Sprintf("/admin/transactions/%s", transaction.ID.Hex()), http.StatusSeeOther)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes returned in the error envelope
+const (
+	codeUnauthorized       = "unauthorized"
+	codeNotFound           = "not_found"
+	codeInvalidRequest     = "invalid_request"
+	codeInvalidJSON        = "invalid_json"
+	codeBodyTooLarge       = "body_too_large"
+	codeValidationFailed   = "validation_failed"
+	codeIllegalTransition  = "illegal_transition"
+	codeNotRefundable      = "not_refundable"
+	codeKeyInactive        = "key_inactive"
+	codeIdempotencyReused  = "idempotency_key_reused"
+	codeIdempotencyPending = "idempotency_key_in_use"
+	codeInternal           = "internal_error"
+)
+
+// maxBodySize limits the size of JSON request bodies
+const maxBodySize = 64 << 10
+
+// errorBody is the content of the error envelope
+type errorBody struct {
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Details []validation.FieldError `json:"details,omitempty"`
+}
+
+// errorResponse is the JSON envelope every handler answers errors with
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// writeError logs an error and responds to the request with the error envelope
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...validation.FieldError) {
+	logStatus(r, status, message)
+	writeJSON(w, status, errorResponse{Error: errorBody{Code: code, Message: message, Details: details}})
+}
+
+// NativeErrors writes errors in the error envelope of the native API
+func NativeErrors(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeError(w, r, status, code, message)
+}
+
+// writeDecodeError responds to a request whose body could not be decoded by decodeJSON
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var fieldErrors validation.Errors
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Request body may not be larger than %d bytes", maxBytesError.Limit))
+	case errors.As(err, &fieldErrors):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid request body", fieldErrors...)
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, fmt.Sprintf("Invalid JSON input: %v", err))
+	}
+}
+
+// decodeJSON decodes a size limited request body into v, rejecting unknown fields and trailing
+// data. Errors that can be attributed to a field are returned as validation.Errors.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return fieldError(err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// fieldError converts a decoding error into validation.Errors when it concerns a single field
+func fieldError(err error) error {
+	var errs validation.Errors
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &typeError) && typeError.Field != "":
+		errs.Add(typeError.Field, validation.CodeInvalidType, "%s must be of type %s", typeError.Field, typeError.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		errs.Add(field, validation.CodeUnknownField, "%s is not a known field", field)
+	case errors.Is(err, money.ErrUnknownCurrency), errors.Is(err, money.ErrInvalidAmount), errors.Is(err, money.ErrPrecision):
+		// Money is the only type decoded with its own validation
+		errs.Add("amount", validation.CodeInvalid, "%v", err)
+	default:
+		return err
+	}
+	return errs
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/utils/model/transactions"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// eventsInterval is how often an event stream reads the store for changes made by other processes
+// and keeps the connection alive
+const eventsInterval = 15 * time.Second
+
+// statusEvent is the data of a status event on the event stream of a transaction. Done is set once
+// the checkout is over, RedirectURL is where the checkout page sends the customer then.
+type statusEvent struct {
+	Status      transactions.Status `json:"status"`
+	Done        bool                `json:"done"`
+	RedirectURL string              `json:"redirect_url,omitempty"`
+}
+
+// checkoutDone reports whether the customer has nothing left to wait for on the checkout page.
+// Authorized transactions are done as well, capturing them is up to the merchant.
+func checkoutDone(status transactions.Status) bool {
+	return status != transactions.StatusOpen && status != transactions.StatusPending
+}
+
+// TransactionEvents streams the status of a transaction to the checkout page as Server-Sent Events.
+// The current status is sent right away and every change after it, the stream ends once the
+// checkout is done.
+func TransactionEvents(w http.ResponseWriter, r *http.Request) {
+	// Parse the transaction_id to an objectID
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["transaction_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Incorrect URI")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Streaming is not supported")
+		return
+	}
+
+	// Subscribe before reading the transaction, so no change can slip in between
+	events, unsubscribe := transactions.Subscribe(id)
+	defer unsubscribe()
+
+	// Get the transaction
+	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
+		return
+	}
+
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to expire transaction")
+		return
+	}
+
+	// Start the stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	logStatus(r, http.StatusOK, "Streaming transaction events")
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	// send writes a status event unless the status was sent before and reports whether the checkout is done
+	var sent transactions.Status
+	send := func(transaction *transactions.Transaction) bool {
+		if transaction.Status == sent {
+			return false
+		}
+		sent = transaction.Status
+
+		event := statusEvent{Status: transaction.Status, Done: checkoutDone(transaction.Status)}
+		if event.Done {
+			event.RedirectURL = redirectURL(transaction)
+		}
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+		flusher.Flush()
+		return event.Done
+	}
+	if send(transaction) {
+		return
+	}
+
+	ticker := time.NewTicker(eventsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case changed, ok := <-events:
+			// The subscriptions are closed when the server shuts down
+			if !ok {
+				return
+			}
+			if send(&changed) {
+				return
+			}
+		case <-ticker.C:
+			// Read the store in case another process changed the transaction
+			latest, err := transactions.GetByID(r.Context(), id)
+			if err == nil {
+				latest, err = expiry.ExpireIfDue(r.Context(), latest)
+			}
+			if err == nil && send(latest) {
+				return
+			}
+
+			// Keep proxies from closing an idle connection
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -1,26 +1,32 @@
 package handler
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/internal/mollie"
+	"dev-payment-gate/internal/stripe"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/merchants"
 	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
 	"dev-payment-gate/web/templates"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// StatusData holds the data for a transaction
-type StatusData struct {
-    Status string `json:"status"`
-}
-
 // applyColorFunc defines a type for applying color to a string.
 type applyColorFunc func(a ...interface{}) string
 
@@ -61,50 +67,118 @@ func logStatus(r *http.Request, status int , message string) {
 
 // NotAvailable Notifies the client that the resource does not exist
 func NotAvailable(w http.ResponseWriter, r *http.Request) {
-	errMsg := "Endpoint not found"
-	http.Error(w, errMsg, http.StatusNotFound)
-	logStatus(r, http.StatusNotFound, errMsg)
+	writeError(w, r, http.StatusNotFound, codeNotFound, "Endpoint not found")
+}
+
+// authenticate returns the merchant owning the API key in the Authorization header and the key
+// itself. It answers the request and returns nil when the key is missing or unknown.
+func authenticate(w http.ResponseWriter, r *http.Request) (*merchants.Merchant, *merchants.APIKey) {
+	// Take the key from the bearer token
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return nil, nil
+	}
+
+	// Find the merchant owning the key
+	merchant, apiKey, status, message := checkKey(r.Context(), key)
+	switch status {
+	case http.StatusOK:
+		return merchant, apiKey
+	case http.StatusUnauthorized:
+		writeError(w, r, status, codeUnauthorized, message)
+	default:
+		writeError(w, r, status, codeInternal, message)
+	}
+	return nil, nil
+}
+
+// checkKey finds the merchant owning an API key. Keys that are not accepted return the HTTP status
+// and message to respond with, so emulated APIs can wrap them in their own error format.
+func checkKey(ctx context.Context, key string) (*merchants.Merchant, *merchants.APIKey, int, string) {
+	merchant, apiKey, err := merchants.Authenticate(ctx, key)
+	switch {
+	case errors.Is(err, merchants.ErrKeyExpired):
+		return nil, nil, http.StatusUnauthorized, "API key has expired"
+	case errors.Is(err, merchants.ErrKeyRevoked):
+		return nil, nil, http.StatusUnauthorized, "API key has been revoked"
+	case errors.Is(err, merchants.ErrInvalidKey):
+		return nil, nil, http.StatusUnauthorized, "Unauthorized"
+	case err != nil:
+		return nil, nil, http.StatusInternalServerError, "Failed to check the API key"
+	}
+	return merchant, apiKey, http.StatusOK, ""
+}
+
+// requestScheme returns the scheme the client used to reach the gate. X-Forwarded-Proto is only
+// trusted when TRUST_PROXY is "true", since any client can send it.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if os.Getenv("TRUST_PROXY") == "true" {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// baseURL returns the scheme and host the client used to reach the gate, e.g. "https://gate.test"
+func baseURL(r *http.Request) string {
+	return fmt.Sprintf("%s://%s", requestScheme(r), r.Host)
+}
+
+// checkoutURL constructs the URL of the checkout page of a transaction
+func checkoutURL(r *http.Request, id primitive.ObjectID) string {
+	return fmt.Sprintf("%s/transaction/%s", baseURL(r), id.Hex())
 }
 
 // CreateTransaction creates a transaction inside the database and returns the transaction url
 func CreateTransaction(w http.ResponseWriter, r *http.Request) {
-	// Get the Authorization header value from the request
-	if auth := r.Header.Get("Authorization"); auth != fmt.Sprintf("Bearer %s", os.Getenv("API_KEY")) {
-		errMsg := "Unauthorized"
-		logStatus(r, http.StatusUnauthorized, errMsg)
-		http.Error(w, errMsg, http.StatusUnauthorized)
+	// Find the merchant creating the transaction
+	merchant, apiKey := authenticate(w, r)
+	if merchant == nil {
 		return
 	}
 
 	// Parse JSON request body into the TransactionInput struct
 	var transactionInput transactions.TransactionInput
-	err := json.NewDecoder(r.Body).Decode(&transactionInput)
-	if err != nil {
-		errMsg := "Invalid JSON input"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+	if err := decodeJSON(w, r, &transactionInput); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// Validate the input, reporting every rejected field at once
+	var fieldErrors validation.Errors
+	if err := transactionInput.Validate(time.Now()); errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid transaction input", fieldErrors...)
 		return
 	}
 
-	// Create a new transaction from the TransactionInput
+	// Create a new transaction from the TransactionInput, using the default expiry when none is given
 	transaction := transactions.Create(transactionInput)
+	transaction.MerchantID = merchant.ID
+	transaction.Mode = string(apiKey.Mode)
+	if transaction.ExpiresAt.IsZero() {
+		transaction.ExpiresAt = transaction.Timestamp.Add(expiry.DefaultExpiry())
+	}
 	id, err := transactions.Insert(r.Context(), &transaction)
 	if err != nil {
-		errMsg := "Failed to insert transaction"
-		logStatus(r, http.StatusInternalServerError, errMsg)
-    	http.Error(w, errMsg, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to insert transaction")
     	return
 	}
 
 	// Construct the transaction URL
-	transactionURL := fmt.Sprintf("http://%s/transaction/%s", r.Host, id.Hex())
+	transactionURL := checkoutURL(r, *id)
 
-	// Return the transaction URL in the response
+	// Return the transaction URL and ID in the response
 	logStatus(r, http.StatusCreated, "Transaction Initialized")
 	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"url": transactionURL})
+	json.NewEncoder(w).Encode(map[string]string{"id": id.Hex(), "url": transactionURL})
 	return
 }
 
@@ -115,99 +189,142 @@ func redirectUser(w http.ResponseWriter, url string) {
 	json.NewEncoder(w).Encode(map[string]interface{}{"url": url})
 }
 
-// PostTransaction handles the payment and callback
+// redirectURL appends the status of the transaction to its redirect url. Transactions of an
+// emulated API are redirected the way that API does.
+func redirectURL(transaction *transactions.Transaction) string {
+	switch transaction.API {
+	case transactions.APIMollie:
+		return mollie.RedirectURL(transaction)
+	case transactions.APIStripe, transactions.APIStripeCheckout:
+		return stripe.RedirectURL(transaction)
+	}
+
+	redirect, err := url.Parse(transaction.RedirectURL)
+	if err != nil {
+		return transaction.RedirectURL
+	}
+
+	query := redirect.Query()
+	query.Set("status", string(transaction.Status))
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// PaymentInput represents the JSON data sent by the checkout page to choose the payment outcome
+type PaymentInput struct {
+	Outcome string `json:"outcome"`
+}
+
+// outcomes maps the outcomes offered on the checkout page to the status they move the transaction to.
+// The "pending" outcome is settled as paid after PENDING_DELAY.
+var outcomes = map[string]transactions.Status{
+	"paid":     transactions.StatusPaid,
+	"pending":  transactions.StatusPending,
+	"failed":   transactions.StatusFailed,
+	"canceled": transactions.StatusCanceled,
+	"expired":  transactions.StatusExpired,
+}
+
+// settlePending settles a pending transaction after PENDING_DELAY. The expiry sweeper settles it
+// instead when the gate restarts in the meantime.
+func settlePending(id primitive.ObjectID) {
+	time.AfterFunc(expiry.PendingDelay(), func() {
+		if _, err := expiry.Settle(context.Background(), id); err != nil {
+			log.Printf("[Warning] failed to settle pending transaction with ID %s: %v", id.Hex(), err)
+		}
+	})
+}
+
+// PostTransaction handles the payment outcome chosen on the checkout page and the callback
 func PostTransaction(w http.ResponseWriter, r *http.Request) {
 	transactionID := mux.Vars(r)["transaction_id"]
 
 	// Parse the transaction_id to an objectID
 	id, err := primitive.ObjectIDFromHex(transactionID)
 	if err != nil {
-		errMsg := "Incorrect URI"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Incorrect URI")
+		return
+	}
+
+	// Parse the chosen outcome, an empty body pays the transaction
+	paymentInput := PaymentInput{Outcome: "paid"}
+	if err := decodeJSON(w, r, &paymentInput); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+	status, ok := outcomes[paymentInput.Outcome]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Unknown outcome %q", paymentInput.Outcome),
+			validation.FieldError{Field: "outcome", Code: validation.CodeInvalid, Message: "outcome must be one of paid, pending, failed, canceled or expired"})
 		return
 	}
 
 	// Get the transaction
 	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return
+	}
 	if err != nil {
-		errMsg := "Failed to get transaction"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
 		return
 	}
 
-	// Delete the transaction after the function completes
-	defer func() {
-		err = transactions.Delete(r.Context(), transaction.ID)
-		if err != nil {
-			log.Printf("[Warning] failed to delete transaction with ID %s from the database: %v", transaction.ID.Hex(), err)
-		}
-	}()
-
-	// Create a StatusData instance
-    statusData := StatusData{
-        Status: "Success",
-    }
-
-	// Marshal the statusData struct into JSON
-	data, err := json.Marshal(statusData)
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
 	if err != nil {
-		errMsg := "Failed to create update request body"
-		logStatus(r, http.StatusInternalServerError, errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to expire transaction")
 		return
 	}
 
-	// Create a new request with the desired method, URL, and body
-    req, err := http.NewRequest("POST", transaction.WebhookURL, bytes.NewBuffer(data))
-    if err != nil {
-		errMsg := "Failed to construct update request"
-		logStatus(r, http.StatusInternalServerError, errMsg)
-        http.Error(w, errMsg, http.StatusInternalServerError)
-        return
-    }
-
-    // Set the request headers
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", transaction.WebhookKey))
-
-    // Create a custom http.Client that skips TLS verification
-    httpClient := &http.Client{
-        Transport: &http.Transport{
-            TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-        },
-    }
-
-    // Send the request using the custom httpClient
-    response, err := httpClient.Do(req)
-    if err != nil {
-		errMsg := "Could not reach webhook"
-		logStatus(r, http.StatusSeeOther, errMsg)
-        redirectUser(w, transaction.RedirectURL)
-        return
-    }
-    defer response.Body.Close()
-
-	// Check if the response was successful
-	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		// Return the redirect URL in the response
-		logStatus(r, http.StatusSeeOther, "Transaction Completed")
-
-		// Redirect the user to the redirection URL
-		redirectUser(w, transaction.RedirectURL)
+	// Move the transaction to the chosen status, finished transactions are kept but can not be paid again
+	transaction, err = expiry.UpdateStatus(r.Context(), transaction.ID, status)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeError(w, r, http.StatusConflict, codeIllegalTransition, "Outcome is not allowed for the current transaction status")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to update transaction")
 		return
 	}
 
-	// Handle non-successful responses
-	errMsg := fmt.Sprintf("Source returned error")
-	logStatus(r, response.StatusCode, errMsg)
+	// Settle pending payments in the background
+	if transaction.Status == transactions.StatusPending {
+		settlePending(transaction.ID)
+	}
+
+	// Queue a notification for the webhook, it is delivered and retried in the background
+	if _, err := webhook.Enqueue(r.Context(), transaction); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
 
 	// Redirect the user to the redirection URL
-	redirectUser(w, transaction.RedirectURL)
+	logStatus(r, http.StatusSeeOther, fmt.Sprintf("Transaction %s", transaction.Status))
+	redirectUser(w, redirectURL(transaction))
 	return
 }
 
+// CancelTransaction cancels an open, pending or authorized transaction so it can no longer be paid
+func CancelTransaction(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Cancel the transaction
+	canceled, err := transactions.UpdateStatus(r.Context(), transaction.ID, transactions.StatusCanceled)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeError(w, r, http.StatusConflict, codeIllegalTransition, fmt.Sprintf("A %s transaction can not be canceled", transaction.Status))
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to cancel transaction")
+		return
+	}
+
+	respondTransaction(w, r, canceled)
+}
+
 // GetTransactionHTML renders the HTML for the transaction page
 func GetTransactionHTML(w http.ResponseWriter, r *http.Request) {
 	transactionID := mux.Vars(r)["transaction_id"]
@@ -215,28 +332,41 @@ func GetTransactionHTML(w http.ResponseWriter, r *http.Request) {
 	// Parse the transaction_id to an objectID
 	id, err := primitive.ObjectIDFromHex(transactionID)
 	if err != nil {
-		errMsg := "Incorrect URI"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Incorrect URI")
 		return
 	}
 
 	// Get the transaction
 	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return
+	}
 	if err != nil {
-		errMsg := "Failed to get transaction"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
+		return
+	}
+
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to expire transaction")
 		return
 	}
 
 	// Setup the transaction page variables
 	data := struct {
-		Amount float64
-		ID     string
+		Amount    money.Money
+		ID        string
+		Status    transactions.Status
+		Open      bool
+		ExpiresAt time.Time
 	}{
-		Amount: transaction.Amount,
-		ID:     transaction.ID.Hex(),
+		Amount:    transaction.Amount,
+		ID:        transaction.ID.Hex(),
+		Status:    transaction.Status,
+		Open:      transaction.Status == transactions.StatusOpen,
+		ExpiresAt: transaction.ExpiresAt,
 	}
 
 	// Set the Content-Type header to specify that the response is HTML
@@ -245,9 +375,7 @@ func GetTransactionHTML(w http.ResponseWriter, r *http.Request) {
 	// Render the transaction page
 	err = templates.RenderHTML(w, "transaction.html", data)
 	if err != nil {
-		errMsg := "Failed to render HTML template"
-		logStatus(r, http.StatusInternalServerError, errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to render HTML template")
 		return
 	}
 
@@ -263,18 +391,18 @@ func GetTransactionJS(w http.ResponseWriter, r *http.Request) {
 	// Parse the transaction_id to an objectID
 	id, err := primitive.ObjectIDFromHex(transactionID)
 	if err != nil {
-		errMsg := "Incorrect URI"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Incorrect URI")
 		return
 	}
 
 	// Get the transaction
 	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Transaction not found")
+		return
+	}
 	if err != nil {
-		errMsg := "Failed to get transaction"
-		logStatus(r, http.StatusBadRequest, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get transaction")
 		return
 	}
 
@@ -291,9 +419,7 @@ func GetTransactionJS(w http.ResponseWriter, r *http.Request) {
 	// Render the transaction javascript
 	err = templates.RenderJS(w, "transaction.js", data)
 	if err != nil {
-		errMsg := "Failed to render JS template"
-		logStatus(r, http.StatusInternalServerError, errMsg)
-		http.Error(w, errMsg, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to render JS template")
 		return
 	}
 
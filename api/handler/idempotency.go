@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"dev-payment-gate/internal/mollie"
+	"dev-payment-gate/utils/model/idempotency"
+	"dev-payment-gate/utils/model/merchants"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxIdempotencyKeyLength limits the length of the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder passes a response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code before writing it
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body before writing it
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// idempotencyTTL returns how long idempotency keys are remembered
+func idempotencyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return ttl
+}
+
+// idempotencyLease returns how long a request holds its idempotency key before the key is
+// considered abandoned and may be reserved again
+func idempotencyLease() time.Duration {
+	lease, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_LEASE"))
+	if err != nil || lease <= 0 {
+		return time.Minute
+	}
+	return lease
+}
+
+// hashHex returns the hex encoded SHA-256 of the given parts
+func hashHex(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyScope returns the ID of the merchant the request authenticates as. The API key is read
+// the way the handlers read it: a bearer token, or the username of basic authentication for Stripe
+// clients, without the "sk_" prefix for Mollie clients. It returns false when the key is not
+// accepted, the handler rejects the request then.
+func idempotencyScope(r *http.Request) (string, bool) {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		key, _, _ = r.BasicAuth()
+	}
+	if key == "" {
+		return "", false
+	}
+
+	merchant, _, err := merchants.Authenticate(r.Context(), mollie.APIKey(key))
+	if err != nil {
+		return "", false
+	}
+	return merchant.ID.Hex(), true
+}
+
+// ErrorWriter writes an error found before a request reaches its handler in the format of the API
+// the route belongs to. The code is one of the error codes of the native API.
+type ErrorWriter func(w http.ResponseWriter, r *http.Request, status int, code, message string)
+
+// Idempotent makes a handler honour the Idempotency-Key header. The first response for a key is
+// stored and replayed for retries with an identical request, retries with a different request
+// are rejected with 422. Keys are scoped to the authenticated merchant, so every API key of the
+// merchant shares them and rotating a key keeps retries working. A key whose request never
+// finished, because the process stopped, can be used again once its lease ends. Errors are written
+// with errorWriter so they match the other errors of the route.
+func Idempotent(errorWriter ErrorWriter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Requests without a key are handled as usual
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			errorWriter(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Idempotency-Key may not be longer than %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Requests that do not authenticate can not do anything to remember
+		merchantID, ok := idempotencyScope(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		// Read the body to fingerprint the request and restore it for the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			errorWriter(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Request body may not be larger than %d bytes", maxBytesError.Limit))
+			return
+		}
+		if err != nil {
+			errorWriter(w, r, http.StatusBadRequest, codeInvalidRequest, "Unable to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		scopedKey := hashHex([]byte(merchantID), []byte(key))
+		fingerprint := hashHex([]byte(r.Method), []byte(r.URL.Path), body)
+
+		// Reserve the key, or replay the response of the request that reserved it first
+		record := idempotency.Create(scopedKey, fingerprint, idempotencyTTL(), idempotencyLease())
+		err = idempotency.Reserve(r.Context(), &record)
+		if errors.Is(err, idempotency.ErrExists) {
+			replay(w, r, errorWriter, scopedKey, fingerprint)
+			return
+		}
+		if err != nil {
+			errorWriter(w, r, http.StatusInternalServerError, codeInternal, "Failed to store idempotency key")
+			return
+		}
+
+		// Release the key when the handler panics so retries are not answered with a conflict, the
+		// panic is passed on to the server afterwards
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				releaseIdempotencyKey(r, scopedKey)
+				panic(recovered)
+			}
+		}()
+
+		// Handle the request and keep its response
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		// Forget the key when the request did not get to do anything, so it can be retried
+		if recorder.status >= 500 || recorder.status == http.StatusUnauthorized {
+			releaseIdempotencyKey(r, scopedKey)
+			return
+		}
+
+		// A response that could not be stored leaves the key pending until its lease ends, the
+		// request may have had an effect so it is not released right away
+		ctx := context.WithoutCancel(r.Context())
+		err = idempotency.Complete(ctx, scopedKey, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			log.Printf("[Warning] failed to store response for idempotency key: %v", err)
+		}
+	}
+}
+
+// releaseIdempotencyKey forgets an idempotency key, also when the client already went away
+func releaseIdempotencyKey(r *http.Request, scopedKey string) {
+	if err := idempotency.Release(context.WithoutCancel(r.Context()), scopedKey); err != nil {
+		log.Printf("[Warning] failed to release idempotency key: %v", err)
+	}
+}
+
+// replay answers a retried request with the stored response of its idempotency key
+func replay(w http.ResponseWriter, r *http.Request, errorWriter ErrorWriter, scopedKey, fingerprint string) {
+	record, err := idempotency.Get(r.Context(), scopedKey)
+	if err != nil {
+		errorWriter(w, r, http.StatusInternalServerError, codeInternal, "Failed to read idempotency key")
+		return
+	}
+
+	// The key may only be reused for the exact same request
+	if record.Fingerprint != fingerprint {
+		errorWriter(w, r, http.StatusUnprocessableEntity, codeIdempotencyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+
+	// The first request may still be running
+	if !record.Completed {
+		errorWriter(w, r, http.StatusConflict, codeIdempotencyPending, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	// Send the stored response again
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+	logStatus(r, record.StatusCode, "Replayed idempotent response")
+}
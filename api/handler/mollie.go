@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/internal/mollie"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultMollieLimit and maxMollieLimit bound the page size of the Mollie payment list
+	defaultMollieLimit = 50
+	maxMollieLimit     = 250
+)
+
+// writeMollieJSON writes data as the body of a Mollie API response
+func writeMollieJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", mollie.ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeMollieError logs a failed Mollie API request and writes the error in the format of Mollie
+func writeMollieError(w http.ResponseWriter, r *http.Request, status int, detail, field string) {
+	logStatus(r, status, detail)
+	writeMollieJSON(w, status, mollie.NewError(status, detail, field))
+}
+
+// MollieErrors writes errors in the format of Mollie, which has no error codes of its own
+func MollieErrors(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeMollieError(w, r, status, message, "")
+}
+
+// mollieAuthenticate finds the merchant owning the API key of a Mollie API request, writing a
+// Mollie error and returning nil when the key is not accepted
+func mollieAuthenticate(w http.ResponseWriter, r *http.Request) (*merchants.Merchant, *merchants.APIKey) {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || key == "" {
+		writeMollieError(w, r, http.StatusUnauthorized, "Missing authentication, or failed to authenticate", "")
+		return nil, nil
+	}
+
+	merchant, apiKey, status, message := checkKey(r.Context(), mollie.APIKey(key))
+	if status != http.StatusOK {
+		if status == http.StatusUnauthorized {
+			message = fmt.Sprintf("Missing authentication, or failed to authenticate: %s", message)
+		}
+		writeMollieError(w, r, status, message, "")
+		return nil, nil
+	}
+	return merchant, apiKey
+}
+
+// molliePayment looks up the transaction of the payment in the URI, writing a Mollie error and
+// returning nil when it does not exist or belongs to another merchant
+func molliePayment(w http.ResponseWriter, r *http.Request, merchant *merchants.Merchant) *transactions.Transaction {
+	paymentID := mux.Vars(r)["payment_id"]
+	notFound := fmt.Sprintf("No payment exists with token %s.", paymentID)
+
+	id, err := mollie.ParsePaymentID(paymentID)
+	if err != nil {
+		writeMollieError(w, r, http.StatusNotFound, notFound, "")
+		return nil
+	}
+	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) || (err == nil && transaction.MerchantID != merchant.ID) {
+		writeMollieError(w, r, http.StatusNotFound, notFound, "")
+		return nil
+	}
+	if err != nil {
+		writeMollieError(w, r, http.StatusInternalServerError, "Failed to get payment", "")
+		return nil
+	}
+
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
+	if err != nil {
+		writeMollieError(w, r, http.StatusInternalServerError, "Failed to expire payment", "")
+		return nil
+	}
+	return transaction
+}
+
+// newMolliePayment converts a transaction into a Mollie payment with links for this request
+func newMolliePayment(r *http.Request, transaction *transactions.Transaction) mollie.Payment {
+	return mollie.NewPayment(transaction, baseURL(r), checkoutURL(r, transaction.ID))
+}
+
+// CreateMolliePayment creates a transaction from a Mollie v2 create payment request
+func CreateMolliePayment(w http.ResponseWriter, r *http.Request) {
+	merchant, apiKey := mollieAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+
+	// Parse the payment, Mollie ignores fields it does not know so the same is done here
+	var paymentInput mollie.PaymentInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := decoder.Decode(&paymentInput); err != nil {
+		var fieldErrors validation.Errors
+		if errors.As(fieldError(err), &fieldErrors) {
+			field := fieldErrors[0].Field
+			if errors.Is(err, money.ErrUnknownCurrency) {
+				field = "amount.currency"
+			} else if field == "amount" {
+				field = "amount.value"
+			}
+			writeMollieError(w, r, http.StatusUnprocessableEntity, fieldErrors[0].Message, field)
+			return
+		}
+		writeMollieError(w, r, http.StatusBadRequest, "The request body is not valid JSON", "")
+		return
+	}
+
+	// Validate the payment, Mollie reports the first rejected field
+	var fieldErrors validation.Errors
+	if err := paymentInput.Validate(); errors.As(err, &fieldErrors) {
+		writeMollieError(w, r, http.StatusUnprocessableEntity, fieldErrors[0].Message, fieldErrors[0].Field)
+		return
+	}
+
+	// Create the transaction behind the payment
+	transaction := paymentInput.Transaction()
+	transaction.MerchantID = merchant.ID
+	transaction.Mode = string(apiKey.Mode)
+	transaction.ExpiresAt = transaction.Timestamp.Add(expiry.DefaultExpiry())
+	id, err := transactions.Insert(r.Context(), &transaction)
+	if err != nil {
+		writeMollieError(w, r, http.StatusInternalServerError, "Failed to create payment", "")
+		return
+	}
+	transaction.ID = *id
+
+	logStatus(r, http.StatusCreated, fmt.Sprintf("Payment %s created", mollie.PaymentID(transaction.ID)))
+	writeMollieJSON(w, http.StatusCreated, newMolliePayment(r, &transaction))
+}
+
+// GetMolliePayment returns a transaction as a Mollie v2 payment
+func GetMolliePayment(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := mollieAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+	transaction := molliePayment(w, r, merchant)
+	if transaction == nil {
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment %s", transaction.Status))
+	writeMollieJSON(w, http.StatusOK, newMolliePayment(r, transaction))
+}
+
+// ListMolliePayments returns a page of the transactions of the merchant as Mollie v2 payments,
+// newest first. The page starts at the payment in "from" and holds at most "limit" payments.
+func ListMolliePayments(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := mollieAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+
+	// Parse the page size
+	query := r.URL.Query()
+	limit := defaultMollieLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxMollieLimit {
+			writeMollieError(w, r, http.StatusBadRequest, fmt.Sprintf("The limit must be a number between 1 and %d", maxMollieLimit), "limit")
+			return
+		}
+		limit = parsed
+	}
+
+	// Start with the payment in from, the transactions after it are listed before its ID
+	filter := transactions.Filter{MerchantID: merchant.ID}
+	var page []transactions.Transaction
+	before := primitive.NilObjectID
+	if from := query.Get("from"); from != "" {
+		id, err := mollie.ParsePaymentID(from)
+		if err != nil {
+			writeMollieError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid payment ID %s", from), "from")
+			return
+		}
+		first, err := transactions.GetByID(r.Context(), id)
+		if err == nil && first.MerchantID == merchant.ID {
+			page = append(page, *first)
+		}
+		before = id
+	}
+
+	// Get one payment more than requested to find out if there is another page
+	found, err := transactions.List(r.Context(), filter, before, limit+1-len(page))
+	if err != nil {
+		writeMollieError(w, r, http.StatusInternalServerError, "Failed to list payments", "")
+		return
+	}
+	page = append(page, found...)
+
+	// Link to the next page, which starts at the extra payment
+	list := mollie.PaymentList{}
+	list.Links.Self = mollie.Link{Href: baseURL(r) + r.URL.RequestURI(), Type: mollie.ContentType}
+	list.Links.Documentation = mollie.Link{Href: "https://docs.mollie.com/reference/v2/payments-api/list-payments", Type: "text/html"}
+	if len(page) > limit {
+		next := url.Values{"from": {mollie.PaymentID(page[limit].ID)}, "limit": {strconv.Itoa(limit)}}
+		list.Links.Next = &mollie.Link{Href: fmt.Sprintf("%s/v2/payments?%s", baseURL(r), next.Encode()), Type: mollie.ContentType}
+		page = page[:limit]
+	}
+	list.Embedded.Payments = []mollie.Payment{}
+	for i := range page {
+		list.Embedded.Payments = append(list.Embedded.Payments, newMolliePayment(r, &page[i]))
+	}
+	list.Count = len(list.Embedded.Payments)
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Listed %d payments", list.Count))
+	writeMollieJSON(w, http.StatusOK, list)
+}
+
+// CancelMolliePayment cancels a payment that has not been paid yet
+func CancelMolliePayment(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := mollieAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+	transaction := molliePayment(w, r, merchant)
+	if transaction == nil {
+		return
+	}
+
+	// Cancel the transaction
+	transaction, err := transactions.UpdateStatus(r.Context(), transaction.ID, transactions.StatusCanceled)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeMollieError(w, r, http.StatusUnprocessableEntity, "The payment cannot be canceled", "")
+		return
+	}
+	if err != nil {
+		writeMollieError(w, r, http.StatusInternalServerError, "Failed to cancel payment", "")
+		return
+	}
+
+	// Queue a notification for the webhook, it is delivered and retried in the background
+	if _, err := webhook.Enqueue(r.Context(), transaction); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment %s canceled", mollie.PaymentID(transaction.ID)))
+	writeMollieJSON(w, http.StatusOK, newMolliePayment(r, transaction))
+}
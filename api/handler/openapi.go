@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"dev-payment-gate/api/openapi"
+	"net/http"
+)
+
+// OpenAPI serves the OpenAPI document of the native API so consumers can generate clients
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openapi.Spec)
+}
+
+// DocsRedirect sends visitors of /docs to the page rendering the OpenAPI document
+func DocsRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/static/docs.html", http.StatusSeeOther)
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// refundResponse is the JSON representation of a refund returned by the API
+type refundResponse struct {
+	ID            string      `json:"id"`
+	TransactionID string      `json:"transaction_id"`
+	Amount        money.Money `json:"amount"`
+	Reason        string      `json:"reason,omitempty"`
+	Status        string      `json:"status"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// newRefundResponse converts a refund into its JSON representation
+func newRefundResponse(transactionID primitive.ObjectID, refund transactions.Refund) refundResponse {
+	return refundResponse{
+		ID:            refund.ID.Hex(),
+		TransactionID: transactionID.Hex(),
+		Amount:        refund.Amount,
+		Reason:        refund.Reason,
+		Status:        string(refund.Status),
+		CreatedAt:     refund.CreatedAt,
+		UpdatedAt:     refund.UpdatedAt,
+	}
+}
+
+// settleRefund settles a pending refund after PENDING_DELAY. The expiry sweeper settles it instead
+// when the gate restarts in the meantime.
+func settleRefund(id, refundID primitive.ObjectID) {
+	time.AfterFunc(expiry.PendingDelay(), func() {
+		if _, _, err := expiry.SettleRefund(context.Background(), id, refundID); err != nil {
+			log.Printf("[Warning] failed to settle refund %s of transaction with ID %s: %v", refundID.Hex(), id.Hex(), err)
+		}
+	})
+}
+
+// CreateRefund refunds a paid transaction in full or in part. The refund starts out pending and
+// settles in the background, both steps are sent to the webhook of the transaction.
+func CreateRefund(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Parse and validate the refund
+	var refundInput transactions.RefundInput
+	if err := decodeJSON(w, r, &refundInput); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	var fieldErrors validation.Errors
+	if err := refundInput.Validate(); errors.As(err, &fieldErrors) {
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid refund input", fieldErrors...)
+		return
+	}
+	if refundInput.Outcome == "" {
+		refundInput.Outcome = transactions.RefundSucceeded
+	}
+
+	// Add the refund, which has to fit in what is left of the paid amount
+	transaction, refund, err := transactions.CreateRefund(r.Context(), transaction.ID, refundInput.Amount, refundInput.Reason, refundInput.Outcome)
+	switch {
+	case errors.Is(err, transactions.ErrNotRefundable):
+		writeError(w, r, http.StatusConflict, codeNotRefundable, "Only paid transactions can be refunded")
+		return
+	case errors.Is(err, transactions.ErrCurrencyMismatch):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid refund input",
+			validation.FieldError{Field: "amount.currency", Code: validation.CodeInvalid, Message: err.Error()})
+		return
+	case errors.Is(err, transactions.ErrRefundExceeded):
+		writeError(w, r, http.StatusBadRequest, codeValidationFailed, "Invalid refund input",
+			validation.FieldError{Field: "amount.value", Code: validation.CodeOutOfRange, Message: err.Error()})
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to refund transaction")
+		return
+	}
+
+	// Notify the webhook and settle the refund in the background
+	if _, err := webhook.EnqueueRefund(r.Context(), transaction, refund); err != nil {
+		log.Printf("[Warning] failed to queue refund webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
+	settleRefund(transaction.ID, refund.ID)
+
+	// Return the refund
+	logStatus(r, http.StatusCreated, fmt.Sprintf("Refund of %s created", refund.Amount))
+	writeJSON(w, http.StatusCreated, newRefundResponse(transaction.ID, *refund))
+}
@@ -0,0 +1,411 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/internal/stripe"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultStripeLimit and maxStripeLimit bound the page size of the Stripe payment intent list
+	defaultStripeLimit = 10
+	maxStripeLimit     = 100
+)
+
+// writeStripeError logs a failed Stripe API request and writes the error in the format of Stripe
+func writeStripeError(w http.ResponseWriter, r *http.Request, status int, response stripe.ErrorResponse) {
+	logStatus(r, status, response.Error.Message)
+	writeJSON(w, status, response)
+}
+
+// writeStripeFieldError responds to a Stripe API request with its first rejected parameter
+func writeStripeFieldError(w http.ResponseWriter, r *http.Request, err error) {
+	var fieldErrors validation.Errors
+	if errors.As(err, &fieldErrors) {
+		writeStripeError(w, r, http.StatusBadRequest, stripe.NewValidationError(fieldErrors[0]))
+		return
+	}
+	writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "", "Invalid request body", ""))
+}
+
+// writeStripeInternalError responds to a Stripe API request that failed on the side of the gate
+func writeStripeInternalError(w http.ResponseWriter, r *http.Request, message string) {
+	writeStripeError(w, r, http.StatusInternalServerError, stripe.NewError(stripe.ErrorTypeAPI, "", message, ""))
+}
+
+// StripeErrors writes errors in the format of Stripe. A reused idempotency key is a 400
+// idempotency_error like on Stripe, a key that is still in use keeps its 409.
+func StripeErrors(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	switch code {
+	case codeIdempotencyReused:
+		writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeIdempotency, "", message, ""))
+	case codeIdempotencyPending:
+		writeStripeError(w, r, status, stripe.NewError(stripe.ErrorTypeInvalidRequest, code, message, ""))
+	case codeInternal:
+		writeStripeInternalError(w, r, message)
+	default:
+		writeStripeError(w, r, status, stripe.NewError(stripe.ErrorTypeInvalidRequest, "", message, ""))
+	}
+}
+
+// stripeAuthenticate finds the merchant owning the API key of a Stripe API request, writing a
+// Stripe error and returning nil when the key is not accepted. Like Stripe, the key is taken from
+// a bearer token or the username of basic authentication.
+func stripeAuthenticate(w http.ResponseWriter, r *http.Request) (*merchants.Merchant, *merchants.APIKey) {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		key, _, _ = r.BasicAuth()
+	}
+	if key == "" {
+		writeStripeError(w, r, http.StatusUnauthorized, stripe.NewError(stripe.ErrorTypeInvalidRequest, "",
+			"You did not provide an API key. You need to provide your API key in the Authorization header, using Bearer auth.", ""))
+		return nil, nil
+	}
+
+	merchant, apiKey, status, message := checkKey(r.Context(), key)
+	switch status {
+	case http.StatusOK:
+		return merchant, apiKey
+	case http.StatusUnauthorized:
+		writeStripeError(w, r, status, stripe.NewError(stripe.ErrorTypeInvalidRequest, "",
+			fmt.Sprintf("Invalid API Key provided: %s", message), ""))
+	default:
+		writeStripeInternalError(w, r, message)
+	}
+	return nil, nil
+}
+
+// parseStripeForm parses the form encoded body of a Stripe API request, writing a Stripe error and
+// returning false when it can not be read
+func parseStripeForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "",
+			"Invalid request body, Stripe requests are form encoded", ""))
+		return false
+	}
+	return true
+}
+
+// stripeTransaction looks up the transaction behind the Stripe object in the URI, writing a Stripe
+// error and returning nil when it does not exist or belongs to another merchant
+func stripeTransaction(w http.ResponseWriter, r *http.Request, merchant *merchants.Merchant, object string, parse func(string) (primitive.ObjectID, error)) *transactions.Transaction {
+	objectID := mux.Vars(r)["id"]
+	notFound := stripe.NewError(stripe.ErrorTypeInvalidRequest, "resource_missing", fmt.Sprintf("No such %s: '%s'", object, objectID), "id")
+
+	id, err := parse(objectID)
+	if err != nil {
+		writeStripeError(w, r, http.StatusNotFound, notFound)
+		return nil
+	}
+	transaction, err := transactions.GetByID(r.Context(), id)
+	if errors.Is(err, transactions.ErrNotFound) || (err == nil && transaction.MerchantID != merchant.ID) {
+		writeStripeError(w, r, http.StatusNotFound, notFound)
+		return nil
+	}
+	if err != nil {
+		writeStripeInternalError(w, r, fmt.Sprintf("Failed to get %s", object))
+		return nil
+	}
+
+	// Only sessions created through the checkout sessions API exist
+	if object == "checkout.session" && transaction.API != transactions.APIStripeCheckout {
+		writeStripeError(w, r, http.StatusNotFound, notFound)
+		return nil
+	}
+
+	// Expire the transaction when its expiry time passed since the last sweep
+	transaction, err = expiry.ExpireIfDue(r.Context(), transaction)
+	if err != nil {
+		writeStripeInternalError(w, r, fmt.Sprintf("Failed to expire %s", object))
+		return nil
+	}
+	return transaction
+}
+
+// insertStripeTransaction stores a transaction created through a Stripe API for the merchant. Stripe
+// has no webhook per payment, the events go to STRIPE_WEBHOOK_URL.
+func insertStripeTransaction(w http.ResponseWriter, r *http.Request, transaction *transactions.Transaction, merchant *merchants.Merchant, apiKey *merchants.APIKey) bool {
+	transaction.MerchantID = merchant.ID
+	transaction.Mode = string(apiKey.Mode)
+	transaction.WebhookURL = os.Getenv("STRIPE_WEBHOOK_URL")
+	if transaction.ExpiresAt.IsZero() {
+		transaction.ExpiresAt = transaction.Timestamp.Add(expiry.DefaultExpiry())
+	}
+
+	id, err := transactions.Insert(r.Context(), transaction)
+	if err != nil {
+		writeStripeInternalError(w, r, "Failed to create transaction")
+		return false
+	}
+	transaction.ID = *id
+	return true
+}
+
+// notifyStripe queues a Stripe event for the webhook about the new status of the transaction
+func notifyStripe(r *http.Request, transaction *transactions.Transaction) {
+	if _, err := webhook.Enqueue(r.Context(), transaction); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", transaction.ID.Hex(), err)
+	}
+}
+
+// unexpectedState responds to a request that is not possible in the current status of a payment intent
+func unexpectedState(w http.ResponseWriter, r *http.Request, transaction *transactions.Transaction, action string, allowed ...string) {
+	intent := stripe.NewPaymentIntent(transaction, "")
+	message := fmt.Sprintf("This PaymentIntent could not be %s because it has a status of %s. Only a PaymentIntent with one of the following statuses may be %s: %s.",
+		action, intent.Status, action, strings.Join(allowed, ", "))
+	writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "payment_intent_unexpected_state", message, ""))
+}
+
+// CreateStripePaymentIntent creates a transaction from a Stripe create payment intent request. The
+// customer pays it on the checkout page the next action redirects to.
+func CreateStripePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	merchant, apiKey := stripeAuthenticate(w, r)
+	if merchant == nil || !parseStripeForm(w, r) {
+		return
+	}
+
+	// Parse and validate the payment intent
+	paymentIntentInput, err := stripe.ParsePaymentIntentInput(r.Form)
+	if err != nil {
+		writeStripeFieldError(w, r, err)
+		return
+	}
+
+	// Create the transaction behind the payment intent
+	transaction := paymentIntentInput.Transaction()
+	if !insertStripeTransaction(w, r, &transaction, merchant, apiKey) {
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment intent %s created", stripe.PaymentIntentID(transaction.ID)))
+	writeJSON(w, http.StatusOK, stripe.NewPaymentIntent(&transaction, checkoutURL(r, transaction.ID)))
+}
+
+// GetStripePaymentIntent returns a transaction as a Stripe payment intent
+func GetStripePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+	transaction := stripeTransaction(w, r, merchant, "payment_intent", stripe.ParsePaymentIntentID)
+	if transaction == nil {
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment intent %s", transaction.Status))
+	writeJSON(w, http.StatusOK, stripe.NewPaymentIntent(transaction, checkoutURL(r, transaction.ID)))
+}
+
+// ListStripePaymentIntents returns a page of the transactions of the merchant as Stripe payment
+// intents, newest first. The page starts after the payment intent in "starting_after".
+func ListStripePaymentIntents(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+
+	// Parse the page size
+	query := r.URL.Query()
+	limit := defaultStripeLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxStripeLimit {
+			writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "parameter_invalid_integer",
+				fmt.Sprintf("Invalid limit: must be between 1 and %d", maxStripeLimit), "limit"))
+			return
+		}
+		limit = parsed
+	}
+
+	// The page continues with the transactions created before the one in starting_after
+	before := primitive.NilObjectID
+	if startingAfter := query.Get("starting_after"); startingAfter != "" {
+		id, err := stripe.ParsePaymentIntentID(startingAfter)
+		if err != nil {
+			writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "resource_missing",
+				fmt.Sprintf("No such payment_intent: '%s'", startingAfter), "starting_after"))
+			return
+		}
+		before = id
+	}
+
+	// Get one payment intent more than requested to find out if there is another page
+	page, err := transactions.List(r.Context(), transactions.Filter{MerchantID: merchant.ID}, before, limit+1)
+	if err != nil {
+		writeStripeInternalError(w, r, "Failed to list payment intents")
+		return
+	}
+	hasMore := len(page) > limit
+	if hasMore {
+		page = page[:limit]
+	}
+	intents := make([]stripe.PaymentIntent, 0, len(page))
+	for i := range page {
+		intents = append(intents, stripe.NewPaymentIntent(&page[i], checkoutURL(r, page[i].ID)))
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Listed %d payment intents", len(intents)))
+	writeJSON(w, http.StatusOK, stripe.NewList(intents, hasMore, "/v1/payment_intents"))
+}
+
+// CancelStripePaymentIntent cancels a payment intent that has not been paid, which voids it when it
+// was authorized
+func CancelStripePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil || !parseStripeForm(w, r) {
+		return
+	}
+	transaction := stripeTransaction(w, r, merchant, "payment_intent", stripe.ParsePaymentIntentID)
+	if transaction == nil {
+		return
+	}
+
+	// Cancel the transaction
+	canceled, err := transactions.UpdateStatus(r.Context(), transaction.ID, transactions.StatusCanceled)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		unexpectedState(w, r, transaction, "canceled", "requires_payment_method", "requires_capture", "requires_action", "processing")
+		return
+	}
+	if err != nil {
+		writeStripeInternalError(w, r, "Failed to cancel payment intent")
+		return
+	}
+	notifyStripe(r, canceled)
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment intent %s canceled", stripe.PaymentIntentID(canceled.ID)))
+	writeJSON(w, http.StatusOK, stripe.NewPaymentIntent(canceled, ""))
+}
+
+// CaptureStripePaymentIntent pays an authorized payment intent with manual capture, for the amount
+// in "amount_to_capture" or the full amount
+func CaptureStripePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil || !parseStripeForm(w, r) {
+		return
+	}
+	transaction := stripeTransaction(w, r, merchant, "payment_intent", stripe.ParsePaymentIntentID)
+	if transaction == nil {
+		return
+	}
+
+	// Parse the amount, which is in the currency of the payment intent
+	var amount *money.Money
+	if value := r.Form.Get("amount_to_capture"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "parameter_invalid_integer",
+				fmt.Sprintf("Invalid positive integer: %s", value), "amount_to_capture"))
+			return
+		}
+		captureAmount := money.New(parsed, transaction.Amount.Currency)
+		amount = &captureAmount
+	}
+
+	// Capture the transaction
+	captured, err := transactions.Capture(r.Context(), transaction.ID, amount)
+	switch {
+	case errors.Is(err, transactions.ErrIllegalTransition):
+		unexpectedState(w, r, transaction, "captured", "requires_capture")
+		return
+	case errors.Is(err, transactions.ErrCaptureExceeded):
+		writeStripeError(w, r, http.StatusBadRequest, stripe.NewError(stripe.ErrorTypeInvalidRequest, "amount_too_large",
+			"The amount_to_capture may not be greater than the amount_capturable.", "amount_to_capture"))
+		return
+	case err != nil:
+		writeStripeInternalError(w, r, "Failed to capture payment intent")
+		return
+	}
+	notifyStripe(r, captured)
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Payment intent %s captured", stripe.PaymentIntentID(captured.ID)))
+	writeJSON(w, http.StatusOK, stripe.NewPaymentIntent(captured, ""))
+}
+
+// CreateStripeSession creates a transaction from a Stripe create checkout session request. The
+// customer pays it on the checkout page the URL of the session points to.
+func CreateStripeSession(w http.ResponseWriter, r *http.Request) {
+	merchant, apiKey := stripeAuthenticate(w, r)
+	if merchant == nil || !parseStripeForm(w, r) {
+		return
+	}
+
+	// Parse and validate the checkout session
+	sessionInput, err := stripe.ParseSessionInput(r.Form, time.Now())
+	if err != nil {
+		writeStripeFieldError(w, r, err)
+		return
+	}
+
+	// Create the transaction behind the checkout session
+	transaction := sessionInput.Transaction()
+	if !insertStripeTransaction(w, r, &transaction, merchant, apiKey) {
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Checkout session %s created", stripe.SessionID(&transaction)))
+	writeJSON(w, http.StatusOK, stripe.NewSession(&transaction, checkoutURL(r, transaction.ID)))
+}
+
+// GetStripeSession returns a transaction as a Stripe checkout session
+func GetStripeSession(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil {
+		return
+	}
+	transaction := stripeTransaction(w, r, merchant, "checkout.session", stripe.ParseSessionID)
+	if transaction == nil {
+		return
+	}
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Checkout session %s", transaction.Status))
+	writeJSON(w, http.StatusOK, stripe.NewSession(transaction, checkoutURL(r, transaction.ID)))
+}
+
+// ExpireStripeSession expires an open checkout session so it can no longer be paid
+func ExpireStripeSession(w http.ResponseWriter, r *http.Request) {
+	merchant, _ := stripeAuthenticate(w, r)
+	if merchant == nil || !parseStripeForm(w, r) {
+		return
+	}
+	transaction := stripeTransaction(w, r, merchant, "checkout.session", stripe.ParseSessionID)
+	if transaction == nil {
+		return
+	}
+
+	// Only open sessions can be expired
+	notOpen := stripe.NewError(stripe.ErrorTypeInvalidRequest, "",
+		fmt.Sprintf("Only Checkout Sessions with a status of open can be expired, this session is %s.", stripe.NewSession(transaction, "").Status), "")
+	if transaction.Status != transactions.StatusOpen {
+		writeStripeError(w, r, http.StatusBadRequest, notOpen)
+		return
+	}
+	expired, err := transactions.UpdateStatus(r.Context(), transaction.ID, transactions.StatusExpired)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		writeStripeError(w, r, http.StatusBadRequest, notOpen)
+		return
+	}
+	if err != nil {
+		writeStripeInternalError(w, r, "Failed to expire checkout session")
+		return
+	}
+	notifyStripe(r, expired)
+
+	logStatus(r, http.StatusOK, fmt.Sprintf("Checkout session %s expired", stripe.SessionID(expired)))
+	writeJSON(w, http.StatusOK, stripe.NewSession(expired, ""))
+}
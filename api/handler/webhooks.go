@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/deliveries"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// attemptResponse is the JSON representation of a single webhook attempt
+type attemptResponse struct {
+	At             time.Time         `json:"at"`
+	URL            string            `json:"url"`
+	RequestHeaders map[string]string `json:"request_headers"`
+	RequestBody    string            `json:"request_body"`
+	StatusCode     int               `json:"status_code,omitempty"`
+	ResponseBody   string            `json:"response_body,omitempty"`
+	LatencyMS      int64             `json:"latency_ms"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// webhookResponse is the JSON representation of a webhook delivery with every attempt
+type webhookResponse struct {
+	ID            string            `json:"id"`
+	TransactionID string            `json:"transaction_id"`
+	Event         string            `json:"event"`
+	Status        string            `json:"status"`
+	URL           string            `json:"url"`
+	ReplayOf      string            `json:"replay_of,omitempty"`
+	ContentType   string            `json:"content_type"`
+	Payload       json.RawMessage   `json:"payload"`
+	CreatedAt     time.Time         `json:"created_at"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	Attempts      []attemptResponse `json:"attempts"`
+}
+
+// newWebhookResponse converts a webhook delivery and its attempts into their JSON representation
+func newWebhookResponse(delivery deliveries.Delivery) webhookResponse {
+	response := webhookResponse{
+		ID:            delivery.ID.Hex(),
+		TransactionID: delivery.TransactionID.Hex(),
+		Event:         delivery.Event,
+		Status:        string(delivery.Status),
+		URL:           delivery.URL,
+		ContentType:   delivery.PayloadType(),
+		Payload:       json.RawMessage(delivery.Payload),
+		CreatedAt:     delivery.CreatedAt,
+		Attempts:      []attemptResponse{},
+	}
+
+	// Payloads that are not JSON, such as form bodies, are returned as a string
+	if !json.Valid(delivery.Payload) {
+		response.Payload, _ = json.Marshal(string(delivery.Payload))
+	}
+	if !delivery.ReplayOf.IsZero() {
+		response.ReplayOf = delivery.ReplayOf.Hex()
+	}
+	if delivery.Status == deliveries.StatusQueued {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	for _, attempt := range delivery.Attempts {
+		headers := attempt.RequestHeaders
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		response.Attempts = append(response.Attempts, attemptResponse{
+			At:             attempt.At,
+			URL:            attempt.URL,
+			RequestHeaders: headers,
+			RequestBody:    string(attempt.RequestBody),
+			StatusCode:     attempt.StatusCode,
+			ResponseBody:   attempt.ResponseBody,
+			LatencyMS:      attempt.Duration.Milliseconds(),
+			Error:          attempt.Error,
+		})
+	}
+	return response
+}
+
+// ListWebhooks returns every webhook delivery of a transaction with the full log of its attempts
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+
+	// Get the webhook deliveries of the transaction
+	webhooks, err := deliveries.ListByTransaction(r.Context(), transaction.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook deliveries")
+		return
+	}
+
+	response := make([]webhookResponse, 0, len(webhooks))
+	for _, delivery := range webhooks {
+		response = append(response, newWebhookResponse(delivery))
+	}
+	logStatus(r, http.StatusOK, fmt.Sprintf("Listed %d webhooks", len(response)))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ReplayWebhook sends a past webhook event of a transaction again as a new delivery with the same
+// payload, so webhook handlers can be debugged without creating new payments
+func ReplayWebhook(w http.ResponseWriter, r *http.Request) {
+	transaction := lookupTransaction(w, r)
+	if transaction == nil {
+		return
+	}
+	deliveryID, ok := parseObjectID(w, r, "delivery_id", "Webhook delivery not found")
+	if !ok {
+		return
+	}
+
+	// Only replay deliveries of this transaction
+	delivery, err := deliveries.GetByID(r.Context(), deliveryID)
+	if errors.Is(err, deliveries.ErrNotFound) || (err == nil && delivery.TransactionID != transaction.ID) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Webhook delivery not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to get webhook delivery")
+		return
+	}
+
+	// Queue the replay, it is delivered and retried in the background
+	replay, err := webhook.Replay(r.Context(), delivery.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to replay webhook")
+		return
+	}
+
+	logStatus(r, http.StatusAccepted, fmt.Sprintf("Webhook %s replayed", delivery.Event))
+	writeJSON(w, http.StatusAccepted, newWebhookResponse(*replay))
+}
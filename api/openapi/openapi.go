@@ -0,0 +1,255 @@
+// Package openapi holds the OpenAPI document of the native API of the gate and a minimal validator
+// that checks requests and responses against it.
+//
+// The validator understands the subset of JSON Schema used by the document: $ref, type, enum,
+// properties, required, additionalProperties, items, oneOf, pattern, format date-time and uri,
+// minimum, maximum and maxLength.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Spec is the OpenAPI document as it is served at /openapi.json
+//
+//go:embed openapi.json
+var Spec []byte
+
+var (
+	// ErrUnknownOperation is returned for a method and path that are not in the document
+	ErrUnknownOperation = errors.New("operation not in the OpenAPI document")
+
+	// ErrUndocumentedStatus is returned for a response status the operation does not document
+	ErrUndocumentedStatus = errors.New("response status not in the OpenAPI document")
+
+	// ErrUndocumentedContentType is returned for a body with a content type the document does not list
+	ErrUndocumentedContentType = errors.New("content type not in the OpenAPI document")
+)
+
+// Document is a parsed OpenAPI document
+type Document struct {
+	root  map[string]interface{}
+	paths []pathTemplate
+}
+
+// pathTemplate is a path of the document split into segments, "{name}" segments match anything
+type pathTemplate struct {
+	template string
+	segments []string
+}
+
+// Load parses the embedded OpenAPI document
+func Load() (*Document, error) {
+	return Parse(Spec)
+}
+
+// Parse parses an OpenAPI document in JSON
+func Parse(data []byte) (*Document, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	paths, ok := root["paths"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the OpenAPI document has no paths")
+	}
+
+	d := &Document{root: root}
+	for template := range paths {
+		d.paths = append(d.paths, pathTemplate{template: template, segments: strings.Split(template, "/")})
+	}
+	return d, nil
+}
+
+// Schema returns the schema in components/schemas with the given name
+func (d *Document) Schema(name string) (map[string]interface{}, bool) {
+	schema, ok := d.resolve("#/components/schemas/" + name).(map[string]interface{})
+	return schema, ok
+}
+
+// ValidateSchema checks a JSON value against the schema in components/schemas with the given name
+func (d *Document) ValidateSchema(name string, body []byte) error {
+	schema, ok := d.Schema(name)
+	if !ok {
+		return fmt.Errorf("no schema named %s", name)
+	}
+	return d.validateJSON(schema, body)
+}
+
+// ValidateRequest checks the body of a request to the operation of the method and path. An empty
+// body is accepted when the request body is not required.
+func (d *Document) ValidateRequest(method, path, contentType string, body []byte) error {
+	operation, err := d.operation(method, path)
+	if err != nil {
+		return err
+	}
+
+	requestBody, ok := d.object(operation["requestBody"])
+	if !ok {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s does not take a request body", method, path)
+		}
+		return nil
+	}
+	if len(body) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			return fmt.Errorf("%s %s requires a request body", method, path)
+		}
+		return nil
+	}
+	return d.validateContent(requestBody, contentType, body)
+}
+
+// ValidateResponse checks the status, content type and body of a response of the operation of the
+// method and path
+func (d *Document) ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	operation, err := d.operation(method, path)
+	if err != nil {
+		return err
+	}
+
+	// Find the response of the status, or of its class such as 2XX
+	responses, _ := d.object(operation["responses"])
+	response, ok := d.object(responses[strconv.Itoa(status)])
+	if !ok {
+		response, ok = d.object(responses[fmt.Sprintf("%dXX", status/100)])
+	}
+	if !ok {
+		response, ok = d.object(responses["default"])
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s %s %d", ErrUndocumentedStatus, method, path, status)
+	}
+	if _, ok := response["content"]; !ok {
+		return nil
+	}
+	return d.validateContent(response, contentType, body)
+}
+
+// operation finds the operation of the method on the path template matching path
+func (d *Document) operation(method, path string) (map[string]interface{}, error) {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	paths, _ := d.root["paths"].(map[string]interface{})
+
+	for _, template := range d.paths {
+		if !template.matches(segments) {
+			continue
+		}
+		item, _ := d.object(paths[template.template])
+		if operation, ok := d.object(item[strings.ToLower(method)]); ok {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrUnknownOperation, method, path)
+}
+
+// matches reports whether the segments of a path match the template
+func (p pathTemplate) matches(segments []string) bool {
+	if len(segments) != len(p.segments) {
+		return false
+	}
+	for i, segment := range p.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateContent checks a body against the schema of its content type in a request body or response
+func (d *Document) validateContent(holder map[string]interface{}, contentType string, body []byte) error {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	content, _ := d.object(holder["content"])
+	media, ok := d.object(content[mediaType])
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUndocumentedContentType, contentType)
+	}
+	schema, ok := d.object(media["schema"])
+	if !ok || mediaType != "application/json" {
+		return nil
+	}
+	return d.validateJSON(schema, body)
+}
+
+// validateJSON parses a JSON body and checks it against the schema
+func (d *Document) validateJSON(schema map[string]interface{}, body []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var errs ValidationError
+	d.validate(schema, value, "$", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// object returns v as a JSON object, following a $ref when it has one
+func (d *Document) object(v interface{}) (map[string]interface{}, bool) {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if ref, ok := object["$ref"].(string); ok {
+		object, ok = d.resolve(ref).(map[string]interface{})
+		return object, ok
+	}
+	return object, true
+}
+
+// resolve returns the value a local reference such as "#/components/schemas/Money" points to, or
+// nil when it points to nothing
+func (d *Document) resolve(ref string) interface{} {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil
+	}
+	var current interface{} = d.root
+	for _, name := range strings.Split(pointer, "/") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[strings.ReplaceAll(strings.ReplaceAll(name, "~1", "/"), "~0", "~")]
+	}
+	return current
+}
+
+// References returns every $ref in the document that does not point to anything
+func (d *Document) References() []string {
+	var broken []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok && d.resolve(ref) == nil {
+				broken = append(broken, ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(d.root)
+	return broken
+}
@@ -0,0 +1,202 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError lists every place where a value does not match its schema, as a path such as
+// "$.amount.value" followed by what is wrong
+type ValidationError []string
+
+// Error joins the problems into a single message
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// add records a problem at the path
+func (e *ValidationError) add(path, format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// validate checks a decoded JSON value against a schema and adds every problem to errs
+func (d *Document) validate(schema map[string]interface{}, value interface{}, path string, errs *ValidationError) {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, ok := d.resolve(ref).(map[string]interface{})
+		if !ok {
+			errs.add(path, "unresolved reference %s", ref)
+			return
+		}
+		schema = resolved
+	}
+
+	// Exactly one of the alternatives has to match
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, alternative := range alternatives {
+			if alternativeSchema, ok := alternative.(map[string]interface{}); ok {
+				var alternativeErrs ValidationError
+				d.validate(alternativeSchema, value, path, &alternativeErrs)
+				if len(alternativeErrs) == 0 {
+					matched++
+				}
+			}
+		}
+		if matched != 1 {
+			errs.add(path, "matches %d of the oneOf schemas instead of exactly one", matched)
+		}
+	}
+
+	if types, ok := schemaTypes(schema); ok && !matchesType(types, value) {
+		errs.add(path, "expected %s, got %s", strings.Join(types, " or "), typeOf(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !contains(enum, value) {
+		errs.add(path, "%v is not one of %v", value, enum)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		d.validateObject(schema, value, path, errs)
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				d.validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		validateString(schema, value, path, errs)
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+			errs.add(path, "%v is less than %v", value, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && value > maximum {
+			errs.add(path, "%v is more than %v", value, maximum)
+		}
+	}
+}
+
+// validateObject checks the properties of an object
+func (d *Document) validateObject(schema map[string]interface{}, value map[string]interface{}, path string, errs *ValidationError) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				errs.add(path, "missing required property %q", name)
+			}
+		}
+	}
+
+	// Check the properties in a stable order so errors are reproducible
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			d.validate(property, value[name], propertyPath, errs)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				errs.add(propertyPath, "unknown property")
+			}
+		case map[string]interface{}:
+			d.validate(additional, value[name], propertyPath, errs)
+		}
+	}
+}
+
+// validateString checks the length, pattern and format of a string
+func validateString(schema map[string]interface{}, value, path string, errs *ValidationError) {
+	if maxLength, ok := schema["maxLength"].(float64); ok && float64(utf8.RuneCountInString(value)) > maxLength {
+		errs.add(path, "longer than %v characters", maxLength)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs.add(path, "invalid pattern %q in the document", pattern)
+		} else if !re.MatchString(value) {
+			errs.add(path, "%q does not match %s", value, pattern)
+		}
+	}
+
+	switch schema["format"] {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			errs.add(path, "%q is not an RFC 3339 date-time", value)
+		}
+	case "uri":
+		if parsed, err := url.Parse(value); err != nil || !parsed.IsAbs() {
+			errs.add(path, "%q is not an absolute URI", value)
+		}
+	}
+}
+
+// schemaTypes returns the type or types a schema allows
+func schemaTypes(schema map[string]interface{}) ([]string, bool) {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}, true
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, true
+	}
+	return nil, false
+}
+
+// matchesType reports whether the value is of one of the JSON Schema types
+func matchesType(types []string, value interface{}) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value, numbers without a fraction are integers
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// contains reports whether the value is one of the enum values
+func contains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
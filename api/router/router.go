@@ -3,6 +3,8 @@ package router
 import (
 	"dev-payment-gate/api/handler"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -45,6 +47,17 @@ func securityMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// emulated reports whether the API of a payment service provider is listed in the comma separated
+// EMULATE variable
+func emulated(provider string) bool {
+	for _, name := range strings.Split(os.Getenv("EMULATE"), ",") {
+		if strings.TrimSpace(name) == provider {
+			return true
+		}
+	}
+	return false
+}
+
 // Router creates a mux router to redirect requests to the correct handler
 func Router() *mux.Router {
 	// Create a new router
@@ -58,10 +71,59 @@ func Router() *mux.Router {
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fileServer))
 
 	// Implement routes
-	router.HandleFunc("/transaction", handler.CreateTransaction).Methods(http.MethodPost)
+	router.HandleFunc("/transaction", handler.Idempotent(handler.NativeErrors, handler.CreateTransaction)).Methods(http.MethodPost)
 	router.HandleFunc("/transaction/{transaction_id}", handler.PostTransaction).Methods(http.MethodPost)
 	router.HandleFunc("/transaction/{transaction_id}", handler.GetTransactionHTML).Methods(http.MethodGet)
 	router.HandleFunc("/transaction/js/{transaction_id}", handler.GetTransactionJS).Methods(http.MethodGet)
+	router.HandleFunc("/transaction/{transaction_id}/events", handler.TransactionEvents).Methods(http.MethodGet)
+
+	// Implement the JSON API
+	router.HandleFunc("/v1/transactions", handler.ListTransactions).Methods(http.MethodGet)
+	router.HandleFunc("/v1/transactions/{transaction_id}", handler.GetTransaction).Methods(http.MethodGet)
+	router.HandleFunc("/v1/transactions/{transaction_id}/capture", handler.Idempotent(handler.NativeErrors, handler.CaptureTransaction)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/transactions/{transaction_id}/void", handler.Idempotent(handler.NativeErrors, handler.VoidTransaction)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/transactions/{transaction_id}/cancel", handler.Idempotent(handler.NativeErrors, handler.CancelTransaction)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/transactions/{transaction_id}/refunds", handler.Idempotent(handler.NativeErrors, handler.CreateRefund)).Methods(http.MethodPost)
+	router.HandleFunc("/v1/transactions/{transaction_id}/webhooks", handler.ListWebhooks).Methods(http.MethodGet)
+	router.HandleFunc("/v1/transactions/{transaction_id}/webhooks/{delivery_id}/replay", handler.Idempotent(handler.NativeErrors, handler.ReplayWebhook)).Methods(http.MethodPost)
+
+	// Implement the admin API for merchants and their API keys
+	router.HandleFunc("/v1/admin/merchants", handler.ListMerchants).Methods(http.MethodGet)
+	router.HandleFunc("/v1/admin/merchants", handler.CreateMerchant).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/merchants/{merchant_id}", handler.UpdateMerchant).Methods(http.MethodPatch)
+	router.HandleFunc("/v1/admin/merchants/{merchant_id}/keys", handler.CreateKey).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/merchants/{merchant_id}/keys/{key_id}/expire", handler.ExpireKey).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/merchants/{merchant_id}/keys/{key_id}/rotate", handler.RotateKey).Methods(http.MethodPost)
+	router.HandleFunc("/v1/admin/merchants/{merchant_id}/keys/{key_id}/revoke", handler.RevokeKey).Methods(http.MethodPost)
+
+	// Implement the API documentation
+	router.HandleFunc("/openapi.json", handler.OpenAPI).Methods(http.MethodGet)
+	router.HandleFunc("/docs", handler.DocsRedirect).Methods(http.MethodGet)
+
+	// Implement the admin dashboard
+	router.HandleFunc("/admin", handler.DashboardRedirect).Methods(http.MethodGet)
+	router.HandleFunc("/admin/transactions", handler.DashboardTransactions).Methods(http.MethodGet)
+	router.HandleFunc("/admin/transactions/{transaction_id}", handler.DashboardTransaction).Methods(http.MethodGet)
+	router.HandleFunc("/admin/transactions/{transaction_id}/status", handler.DashboardForceStatus).Methods(http.MethodPost)
+	router.HandleFunc("/admin/transactions/{transaction_id}/webhooks/{delivery_id}/resend", handler.DashboardResendWebhook).Methods(http.MethodPost)
+
+	// Implement the APIs of the emulated payment service providers
+	if emulated("mollie") {
+		router.HandleFunc("/v2/payments", handler.Idempotent(handler.MollieErrors, handler.CreateMolliePayment)).Methods(http.MethodPost)
+		router.HandleFunc("/v2/payments", handler.ListMolliePayments).Methods(http.MethodGet)
+		router.HandleFunc("/v2/payments/{payment_id}", handler.GetMolliePayment).Methods(http.MethodGet)
+		router.HandleFunc("/v2/payments/{payment_id}", handler.CancelMolliePayment).Methods(http.MethodDelete)
+	}
+	if emulated("stripe") {
+		router.HandleFunc("/v1/payment_intents", handler.Idempotent(handler.StripeErrors, handler.CreateStripePaymentIntent)).Methods(http.MethodPost)
+		router.HandleFunc("/v1/payment_intents", handler.ListStripePaymentIntents).Methods(http.MethodGet)
+		router.HandleFunc("/v1/payment_intents/{id}", handler.GetStripePaymentIntent).Methods(http.MethodGet)
+		router.HandleFunc("/v1/payment_intents/{id}/cancel", handler.Idempotent(handler.StripeErrors, handler.CancelStripePaymentIntent)).Methods(http.MethodPost)
+		router.HandleFunc("/v1/payment_intents/{id}/capture", handler.Idempotent(handler.StripeErrors, handler.CaptureStripePaymentIntent)).Methods(http.MethodPost)
+		router.HandleFunc("/v1/checkout/sessions", handler.Idempotent(handler.StripeErrors, handler.CreateStripeSession)).Methods(http.MethodPost)
+		router.HandleFunc("/v1/checkout/sessions/{id}", handler.GetStripeSession).Methods(http.MethodGet)
+		router.HandleFunc("/v1/checkout/sessions/{id}/expire", handler.Idempotent(handler.StripeErrors, handler.ExpireStripeSession)).Methods(http.MethodPost)
+	}
 
 	// Custom NotFoundHandler for undefined routes
 	router.NotFoundHandler = http.HandlerFunc(handler.NotAvailable)
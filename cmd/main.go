@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"dev-payment-gate/api/router"
 	"dev-payment-gate/internal/app"
+	"dev-payment-gate/internal/cli"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +15,11 @@ import (
 )
 
 func main() {
+	// Run a command line tool instead of the server when arguments are given
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Initialize the application
     if err := app.Initialize("./"); err != nil {
         log.Fatalf("[Error] %v", err)
@@ -48,9 +55,8 @@ func main() {
 		os.Exit(0)
 	}()
 
-	// Start the server
-	log.Printf("Listening to port %s for HTTP requests...\n", os.Getenv("PORT"))
-	if err := server.ListenAndServe(); err != nil {
+	// Start the server, over HTTPS when a certificate is configured
+	if err := app.Serve(&server); err != nil {
 		log.Printf("%v", err)
 	}
 
@@ -61,3 +67,24 @@ func main() {
 	}
 	os.Exit(1)
 }
+
+// runCommand runs a command line tool on top of the configured storage and returns the exit code
+func runCommand(args []string) int {
+	if err := app.InitializeStorage("./"); err != nil {
+		log.Printf("[Error] %v", err)
+		return 1
+	}
+	defer app.CloseStorage()
+
+	// Refuse to change a store that is gone once the command exits
+	if err := cli.CheckStore(os.Getenv("STORE")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := cli.Run(context.Background(), args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ require (
 	github.com/fatih/color v1.15.0
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.3.8
 	go.mongodb.org/mongo-driver v1.12.1
 )
 
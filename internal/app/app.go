@@ -2,8 +2,16 @@ package app
 
 import (
 	"context"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/internal/tlsserver"
+	"dev-payment-gate/internal/webhook"
 	"dev-payment-gate/utils/database"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/idempotency"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
 	"dev-payment-gate/web/templates"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,9 +23,9 @@ import (
 
 // Initialize initializes the application
 func Initialize(relativeRootFolder string) error {
-    // Load configurations from .env file
-    if err := godotenv.Load(fmt.Sprintf("%s.env", relativeRootFolder)); err != nil {
-        return fmt.Errorf("failed to load environment configurations from .env file: %v", err)
+    // Load the configuration and connect the storage backend
+    if err := InitializeStorage(relativeRootFolder); err != nil {
+        return err
     }
 
     // Load templates from the templates folder
@@ -25,14 +33,133 @@ func Initialize(relativeRootFolder string) error {
         return fmt.Errorf("failed to load .html templates: %v", err)
     }
 
-    // Initialize the database connection
-    if err := database.Connect(os.Getenv("MONGO_URI"), "dev-payment-gate"); err != nil {
-        return fmt.Errorf("unable to establish connection to the database: %v", err)
+    // Start delivering queued webhooks in the background
+    if err := webhook.Start(webhook.ConfigFromEnv()); err != nil {
+        return fmt.Errorf("failed to start the webhook worker: %v", err)
     }
 
+    // Start expiring abandoned transactions in the background
+    expiry.StartFromEnv()
+
     return nil
 }
 
+// InitializeStorage loads the configuration and connects the storage backend without starting any
+// background work, which is all the command line tools need
+func InitializeStorage(relativeRootFolder string) error {
+    // Load configurations from .env file, falling back to the process environment when it does not exist
+    if err := godotenv.Load(fmt.Sprintf("%s.env", relativeRootFolder)); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return fmt.Errorf("failed to load environment configurations from .env file: %v", err)
+    }
+
+    // Initialize the configured transaction store
+    if err := initializeStore(os.Getenv("STORE")); err != nil {
+        return err
+    }
+
+    // Keep the configured API key and webhook secret working by giving them to a default merchant
+    if key := os.Getenv("API_KEY"); key != "" {
+        if _, err := merchants.Bootstrap(context.Background(), "default", key, os.Getenv("WEBHOOK_SECRET")); err != nil {
+            return fmt.Errorf("unable to create the merchant of API_KEY: %v", err)
+        }
+    }
+
+    return nil
+}
+
+// initializeStore connects the storage backend selected with the STORE variable
+func initializeStore(backend string) error {
+    switch backend {
+    case "", "mongo":
+        // Initialize the database connection
+        if err := database.Connect(os.Getenv("MONGO_URI"), "dev-payment-gate"); err != nil {
+            return fmt.Errorf("unable to establish connection to the database: %v", err)
+        }
+        transactionStore, err := transactions.NewMongoStore(context.Background())
+        if err != nil {
+            return fmt.Errorf("unable to create the transactions indexes: %v", err)
+        }
+        deliveryStore, err := deliveries.NewMongoStore(context.Background())
+        if err != nil {
+            return fmt.Errorf("unable to create the deliveries indexes: %v", err)
+        }
+        idempotencyStore, err := idempotency.NewMongoStore(context.Background())
+        if err != nil {
+            return fmt.Errorf("unable to create the idempotency indexes: %v", err)
+        }
+        merchantStore, err := merchants.NewMongoStore(context.Background())
+        if err != nil {
+            return fmt.Errorf("unable to create the merchants indexes: %v", err)
+        }
+        transactions.SetStore(transactionStore)
+        deliveries.SetStore(deliveryStore)
+        idempotency.SetStore(idempotencyStore)
+        merchants.SetStore(merchantStore)
+    case "bolt":
+        // Open the single-file embedded database
+        path := os.Getenv("BOLT_PATH")
+        if path == "" {
+            path = "dev-payment-gate.db"
+        }
+        if err := database.OpenBolt(path); err != nil {
+            return fmt.Errorf("unable to open the embedded database: %v", err)
+        }
+        transactionStore, err := transactions.NewBoltStore()
+        if err != nil {
+            return err
+        }
+        deliveryStore, err := deliveries.NewBoltStore()
+        if err != nil {
+            return err
+        }
+        idempotencyStore, err := idempotency.NewBoltStore()
+        if err != nil {
+            return err
+        }
+        merchantStore, err := merchants.NewBoltStore()
+        if err != nil {
+            return err
+        }
+        transactions.SetStore(transactionStore)
+        deliveries.SetStore(deliveryStore)
+        idempotency.SetStore(idempotencyStore)
+        merchants.SetStore(merchantStore)
+    case "memory":
+        // Keep everything in memory, nothing survives a restart
+        transactions.SetStore(transactions.NewMemoryStore())
+        deliveries.SetStore(deliveries.NewMemoryStore())
+        idempotency.SetStore(idempotency.NewMemoryStore())
+        merchants.SetStore(merchants.NewMemoryStore())
+    default:
+        return fmt.Errorf("unknown store %q, expected \"mongo\", \"bolt\" or \"memory\"", backend)
+    }
+
+    return nil
+}
+
+// Serve accepts requests on the server until it is shut down, over HTTPS when TLS_CERT_FILE and
+// TLS_KEY_FILE are set or TLS_SELF_SIGNED is "true"
+func Serve(server *http.Server) error {
+    // End the event streams on shutdown, the server does not cancel long-lived requests itself
+    server.RegisterOnShutdown(transactions.CloseSubscriptions)
+
+    config := tlsserver.ConfigFromEnv()
+    if !config.Enabled() {
+        log.Printf("Listening to port %s for HTTP requests...\n", os.Getenv("PORT"))
+        return server.ListenAndServe()
+    }
+
+    // Load the certificate and keep reloading it in the background
+    tlsConfig, err := tlsserver.Start(config)
+    if err != nil {
+        return fmt.Errorf("unable to serve HTTPS: %v", err)
+    }
+    server.TLSConfig = tlsConfig
+
+    log.Printf("Listening to port %s for HTTPS requests...\n", os.Getenv("PORT"))
+    return server.ListenAndServeTLS("", "")
+}
+
 // Clean is a function that performs cleanup operations, closing the server and disconnecting from the database.
 func Clean(server *http.Server) error {
     log.Println("Shutting down gracefully...")
@@ -45,9 +172,18 @@ func Clean(server *http.Server) error {
         errs = append(errs, fmt.Errorf("unable to shutdown the server: %v", err))
     }
 
-    // Attempt to disconnect from the database
-    if err := database.Disconnect(); err != nil {
-        errs = append(errs, fmt.Errorf("unable to disconnect the database: %v", err))
+    // Stop reloading the TLS certificate
+    tlsserver.Stop()
+
+    // Stop the expiry sweeper
+    expiry.Stop()
+
+    // Stop the webhook worker, queued deliveries are picked up again after a restart
+    webhook.Stop()
+
+    // Attempt to close the storage backend
+    if err := CloseStorage(); err != nil {
+        errs = append(errs, err)
     }
 
 	// No errors, return nil
@@ -58,3 +194,17 @@ func Clean(server *http.Server) error {
     // If there are errors, return them as a multi-error
     return errs[0]
 }
+
+// CloseStorage disconnects from the database and closes the embedded database
+func CloseStorage() error {
+    // Attempt to disconnect from the database
+    if err := database.Disconnect(); err != nil {
+        return fmt.Errorf("unable to disconnect the database: %v", err)
+    }
+
+    // Attempt to close the embedded database
+    if err := database.CloseBolt(); err != nil {
+        return fmt.Errorf("unable to close the embedded database: %v", err)
+    }
+    return nil
+}
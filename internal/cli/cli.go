@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"dev-payment-gate/utils/model/merchants"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// usage describes the available commands
+const usage = `Usage:
+  dev-payment-gate                                           start the payment gate
+  dev-payment-gate merchant create -name NAME [-mode MODE]   create a merchant with an API key and webhook secret
+  dev-payment-gate merchant key -id ID [-mode MODE]          add an API key to a merchant
+  dev-payment-gate merchant list                             list the merchants and their keys
+
+MODE is "test" (default) or "live".
+
+The commands change the configured store directly. STORE=memory is not supported, and with
+STORE=bolt the server has to be stopped first, since it keeps the database file locked.`
+
+var (
+	// ErrUsage is returned when the command line does not match any command
+	ErrUsage = errors.New(usage)
+
+	// ErrMemoryStore is returned when the commands would run on the in-memory store
+	ErrMemoryStore = errors.New("the merchant commands need a persistent store, STORE=memory only lives as long as the command: use STORE=mongo, or STORE=bolt with the server stopped")
+)
+
+// CheckStore reports whether the commands can run on the storage backend selected with STORE. The
+// in-memory store of a command is not the one of the server, so changes to it would be lost.
+func CheckStore(backend string) error {
+	if backend == "memory" {
+		return ErrMemoryStore
+	}
+	return nil
+}
+
+// Run executes the command given by args and writes its output to out. The storage backend has to
+// be initialized before.
+func Run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) < 2 || args[0] != "merchant" {
+		return ErrUsage
+	}
+
+	switch args[1] {
+	case "create":
+		return createMerchant(ctx, args[2:], out)
+	case "key":
+		return addKey(ctx, args[2:], out)
+	case "list":
+		return listMerchants(ctx, out)
+	default:
+		return ErrUsage
+	}
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// createMerchant creates a merchant with a first API key
+func createMerchant(ctx context.Context, args []string, out io.Writer) error {
+	flags := newFlagSet("merchant create")
+	name := flags.String("name", "", "name of the merchant")
+	mode := flags.String("mode", string(merchants.ModeTest), "mode of the API key")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%v\n\n%w", err, ErrUsage)
+	}
+	if strings.TrimSpace(*name) == "" {
+		return fmt.Errorf("a name is required\n\n%w", ErrUsage)
+	}
+
+	// Create the merchant together with its key and webhook secret
+	merchant := merchants.Create(*name)
+	key, _, err := merchant.NewKey(merchants.Mode(*mode), merchant.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err := merchant.NewWebhookSecret(); err != nil {
+		return err
+	}
+	if _, err := merchants.Insert(ctx, &merchant); err != nil {
+		return fmt.Errorf("unable to store the merchant: %v", err)
+	}
+
+	fmt.Fprintf(out, "Created merchant %s (%s)\n", merchant.ID.Hex(), merchant.Name)
+	fmt.Fprintf(out, "API key: %s\n", key)
+	fmt.Fprintln(out, "Store the API key now, it can not be shown again.")
+	fmt.Fprintf(out, "Webhook secret: %s\n", merchant.WebhookSecret)
+	return nil
+}
+
+// addKey adds an API key to an existing merchant
+func addKey(ctx context.Context, args []string, out io.Writer) error {
+	flags := newFlagSet("merchant key")
+	id := flags.String("id", "", "ID of the merchant")
+	mode := flags.String("mode", string(merchants.ModeTest), "mode of the API key")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%v\n\n%w", err, ErrUsage)
+	}
+	merchantID, err := primitive.ObjectIDFromHex(*id)
+	if err != nil {
+		return fmt.Errorf("invalid merchant ID %q\n\n%w", *id, ErrUsage)
+	}
+
+	// Generate and store the key
+	key, _, err := merchants.AddKey(ctx, merchantID, merchants.Mode(*mode), nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "API key: %s\n", key)
+	fmt.Fprintln(out, "Store the API key now, it can not be shown again.")
+	return nil
+}
+
+// listMerchants prints every merchant with its webhook secret and the hints of its keys
+func listMerchants(ctx context.Context, out io.Writer) error {
+	found, err := merchants.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, merchant := range found {
+		fmt.Fprintf(out, "%s %s\n", merchant.ID.Hex(), merchant.Name)
+		if merchant.WebhookSecret != "" {
+			fmt.Fprintf(out, "  webhook secret %s\n", merchant.WebhookSecret)
+		}
+		for _, key := range merchant.Keys {
+			fmt.Fprintf(out, "  %s %-4s %-7s %s created %s\n", key.ID.Hex(), key.Mode, key.Status(now), key.Hint, key.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package expiry
+
+import (
+	"context"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/transactions"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// batchSize limits how many transactions are read from the store at once
+const batchSize = 100
+
+// DefaultExpiry returns how long a transaction can be paid when it does not set its own expiry time
+func DefaultExpiry() time.Duration {
+	return envDuration("TRANSACTION_EXPIRY", 15*time.Minute)
+}
+
+// AuthorizationExpiry returns how long an authorized transaction can be captured before it expires
+func AuthorizationExpiry() time.Duration {
+	return envDuration("AUTHORIZATION_EXPIRY", 7*24*time.Hour)
+}
+
+// PendingDelay returns how long a pending payment or refund takes to settle, from PENDING_DELAY
+func PendingDelay() time.Duration {
+	delay, err := time.ParseDuration(os.Getenv("PENDING_DELAY"))
+	if err != nil || delay < 0 {
+		return 5 * time.Second
+	}
+	return delay
+}
+
+// envDuration parses a positive duration from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// sweeper periodically settles pending payments and expires abandoned transactions
+type sweeper struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	running     *sweeper
+	runningLock sync.Mutex
+)
+
+// Start launches the background sweeper that checks for expired transactions every interval,
+// restarting it when one is already running
+func Start(interval time.Duration) {
+	Stop()
+
+	s := &sweeper{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+
+	// Lock the mutex to safely set the sweeper
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	running = s
+}
+
+// StartFromEnv launches the sweeper with the interval from EXPIRY_SWEEP_INTERVAL
+func StartFromEnv() {
+	Start(envDuration("EXPIRY_SWEEP_INTERVAL", 10*time.Second))
+}
+
+// Stop halts the background sweeper
+func Stop() {
+	// Lock the mutex to safely clear the sweeper
+	runningLock.Lock()
+	s := running
+	running = nil
+	runningLock.Unlock()
+
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// run sweeps until the sweeper is stopped
+func (s *sweeper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		// Settle what is pending first, it would have been settled long before it expired
+		if _, err := SweepPending(context.Background(), time.Now()); err != nil {
+			log.Printf("[Warning] failed to sweep pending transactions: %v", err)
+		}
+		if _, err := Sweep(context.Background(), time.Now()); err != nil {
+			log.Printf("[Warning] failed to sweep expired transactions: %v", err)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires every open, pending or authorized transaction whose expiry time passed before now
+// and returns how many transactions were expired
+func Sweep(ctx context.Context, now time.Time) (int, error) {
+	filter := transactions.Filter{
+		Statuses:      []transactions.Status{transactions.StatusOpen, transactions.StatusPending, transactions.StatusAuthorized},
+		ExpiresBefore: &now,
+	}
+
+	expired := 0
+	cursor := primitive.NilObjectID
+	for {
+		found, err := transactions.List(ctx, filter, cursor, batchSize)
+		if err != nil {
+			return expired, err
+		}
+
+		for i := range found {
+			if _, err := Expire(ctx, &found[i]); err != nil {
+				log.Printf("[Warning] failed to expire transaction with ID %s: %v", found[i].ID.Hex(), err)
+				continue
+			}
+			expired++
+		}
+
+		if len(found) < batchSize {
+			return expired, nil
+		}
+		cursor = found[len(found)-1].ID
+	}
+}
+
+// Expire marks the transaction as expired and notifies its webhook. Transactions that were finished
+// in the meantime are returned unchanged.
+func Expire(ctx context.Context, transaction *transactions.Transaction) (*transactions.Transaction, error) {
+	updated, err := transactions.UpdateStatus(ctx, transaction.ID, transactions.StatusExpired)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		return transactions.GetByID(ctx, transaction.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := webhook.Enqueue(ctx, updated); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", updated.ID.Hex(), err)
+	}
+	return updated, nil
+}
+
+// ExpireIfDue expires the transaction when its expiry time has passed, which keeps the checkout page
+// accurate in between two sweeps
+func ExpireIfDue(ctx context.Context, transaction *transactions.Transaction) (*transactions.Transaction, error) {
+	if !transaction.Expired(time.Now()) {
+		return transaction, nil
+	}
+	return Expire(ctx, transaction)
+}
+
+// UpdateStatus moves a stored transaction to a new status. Successful payments of transactions with
+// manual capture are only authorized, which restarts their expiry time at AUTHORIZATION_EXPIRY.
+func UpdateStatus(ctx context.Context, id primitive.ObjectID, to transactions.Status) (*transactions.Transaction, error) {
+	return transactions.Modify(ctx, id, func(transaction *transactions.Transaction) error {
+		status := to
+		if status == transactions.StatusPaid {
+			status = transaction.PaidStatus()
+		}
+
+		now := time.Now()
+		if err := transaction.Transition(status, now); err != nil {
+			return err
+		}
+		if status == transactions.StatusAuthorized {
+			transaction.ExpiresAt = now.Add(AuthorizationExpiry())
+		}
+		return nil
+	})
+}
+
+// SweepPending settles every payment and refund that has been pending for PENDING_DELAY at now and
+// returns how many were settled. It picks up what a restart kept from settling.
+func SweepPending(ctx context.Context, now time.Time) (int, error) {
+	settled, err := sweepPendingPayments(ctx, now)
+	if err != nil {
+		return settled, err
+	}
+	refunds, err := sweepPendingRefunds(ctx, now)
+	return settled + refunds, err
+}
+
+// sweepPendingPayments settles the pending payments that are due at now and returns how many were
+// settled
+func sweepPendingPayments(ctx context.Context, now time.Time) (int, error) {
+	filter := transactions.Filter{Statuses: []transactions.Status{transactions.StatusPending}}
+	due := now.Add(-PendingDelay())
+
+	settled := 0
+	cursor := primitive.NilObjectID
+	for {
+		found, err := transactions.List(ctx, filter, cursor, batchSize)
+		if err != nil {
+			return settled, err
+		}
+
+		for i := range found {
+			if found[i].ChangedAt(transactions.StatusPending).After(due) {
+				continue
+			}
+			if _, err := Settle(ctx, found[i].ID); err != nil {
+				log.Printf("[Warning] failed to settle pending transaction with ID %s: %v", found[i].ID.Hex(), err)
+				continue
+			}
+			settled++
+		}
+
+		if len(found) < batchSize {
+			return settled, nil
+		}
+		cursor = found[len(found)-1].ID
+	}
+}
+
+// sweepPendingRefunds settles the pending refunds that are due at now and returns how many were
+// settled
+func sweepPendingRefunds(ctx context.Context, now time.Time) (int, error) {
+	filter := transactions.Filter{PendingRefunds: true}
+	due := now.Add(-PendingDelay())
+
+	settled := 0
+	cursor := primitive.NilObjectID
+	for {
+		found, err := transactions.List(ctx, filter, cursor, batchSize)
+		if err != nil {
+			return settled, err
+		}
+
+		for i := range found {
+			for _, refund := range found[i].Refunds {
+				if refund.Status != transactions.RefundPending || refund.CreatedAt.After(due) {
+					continue
+				}
+				if _, _, err := SettleRefund(ctx, found[i].ID, refund.ID); err != nil {
+					log.Printf("[Warning] failed to settle refund %s of transaction with ID %s: %v", refund.ID.Hex(), found[i].ID.Hex(), err)
+					continue
+				}
+				settled++
+			}
+		}
+
+		if len(found) < batchSize {
+			return settled, nil
+		}
+		cursor = found[len(found)-1].ID
+	}
+}
+
+// Settle marks a pending transaction as paid, or authorized for manual capture, and notifies its
+// webhook. Transactions that were settled or finished in the meantime are returned unchanged.
+func Settle(ctx context.Context, id primitive.ObjectID) (*transactions.Transaction, error) {
+	updated, err := UpdateStatus(ctx, id, transactions.StatusPaid)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		return transactions.GetByID(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := webhook.Enqueue(ctx, updated); err != nil {
+		log.Printf("[Warning] failed to queue webhook for transaction with ID %s: %v", updated.ID.Hex(), err)
+	}
+	log.Printf("Settled pending transaction with ID %s", updated.ID.Hex())
+	return updated, nil
+}
+
+// SettleRefund moves a pending refund to its outcome and notifies the webhook of its transaction.
+// Refunds that were settled in the meantime are returned unchanged.
+func SettleRefund(ctx context.Context, id, refundID primitive.ObjectID) (*transactions.Transaction, *transactions.Refund, error) {
+	transaction, refund, err := transactions.SettleRefund(ctx, id, refundID)
+	if errors.Is(err, transactions.ErrIllegalTransition) {
+		if transaction, err = transactions.GetByID(ctx, id); err != nil {
+			return nil, nil, err
+		}
+		return transaction, transaction.FindRefund(refundID), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := webhook.EnqueueRefund(ctx, transaction, refund); err != nil {
+		log.Printf("[Warning] failed to queue refund webhook for transaction with ID %s: %v", id.Hex(), err)
+	}
+	log.Printf("Refund %s of transaction with ID %s %s", refundID.Hex(), id.Hex(), refund.Status)
+	return transaction, refund, nil
+}
@@ -0,0 +1,25 @@
+package mollie
+
+import "net/http"
+
+// errorDocumentationURL is linked from every error
+const errorDocumentationURL = "https://docs.mollie.com/overview/handling-errors"
+
+// Error is the body of an error response of the Mollie API. Field names the rejected field of a
+// request body.
+type Error struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Field  string `json:"field,omitempty"`
+	Links  struct {
+		Documentation Link `json:"documentation"`
+	} `json:"_links"`
+}
+
+// NewError creates an error response with the given HTTP status
+func NewError(status int, detail, field string) Error {
+	e := Error{Status: status, Title: http.StatusText(status), Detail: detail, Field: field}
+	e.Links.Documentation = Link{Href: errorDocumentationURL, Type: "text/html"}
+	return e
+}
@@ -0,0 +1,72 @@
+package mollie
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// maxDescriptionLength limits the length of a payment description
+	maxDescriptionLength = 255
+
+	// maxMetadataLength limits the size of the metadata of a payment in bytes
+	maxMetadataLength = 1024
+)
+
+// PaymentInput represents the JSON data received to create a Mollie payment. Other fields Mollie
+// accepts, such as method and locale, are ignored.
+type PaymentInput struct {
+	Amount      money.Money     `json:"amount"`
+	Description string          `json:"description"`
+	RedirectURL string          `json:"redirectUrl"`
+	CancelURL   string          `json:"cancelUrl"`
+	WebhookURL  string          `json:"webhookUrl"`
+	Metadata    json.RawMessage `json:"metadata"`
+	CaptureMode string          `json:"captureMode"`
+}
+
+// Validate checks the input before a payment is created from it. It returns validation.Errors
+// listing every rejected field with its Mollie name, or nil when the input is valid.
+func (input PaymentInput) Validate() error {
+	var errs validation.Errors
+
+	transactions.ValidateAmount(&errs, "amount", input.Amount)
+	if strings.TrimSpace(input.Description) == "" {
+		errs.Add("description", validation.CodeRequired, "The description is required")
+	}
+	errs.MaxLength("description", input.Description, maxDescriptionLength)
+
+	// Only the redirect URL is required, payments without a webhook are polled for their status
+	errs.URL("redirectUrl", input.RedirectURL, true)
+	errs.URL("cancelUrl", input.CancelURL, false)
+	errs.URL("webhookUrl", input.WebhookURL, false)
+
+	if len(input.Metadata) > maxMetadataLength {
+		errs.Add("metadata", validation.CodeTooLong, "The metadata may not be larger than %d bytes", maxMetadataLength)
+	}
+	if input.CaptureMode != "" && input.CaptureMode != "automatic" && input.CaptureMode != "manual" {
+		errs.Add("captureMode", validation.CodeInvalid, "The capture mode must be automatic or manual")
+	}
+
+	return errs.Err()
+}
+
+// Transaction creates the transaction of the payment
+func (input PaymentInput) Transaction() transactions.Transaction {
+	transaction := transactions.Create(transactions.TransactionInput{
+		Amount:        input.Amount,
+		WebhookURL:    input.WebhookURL,
+		RedirectURL:   input.RedirectURL,
+		Reference:     input.Description,
+		CaptureMethod: transactions.CaptureMethod(input.CaptureMode),
+	})
+	transaction.API = transactions.APIMollie
+	transaction.CancelURL = input.CancelURL
+	if metadata := strings.TrimSpace(string(input.Metadata)); metadata != "" && metadata != "null" {
+		transaction.Metadata = metadata
+	}
+	return transaction
+}
@@ -0,0 +1,74 @@
+package mollie
+
+import (
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"errors"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// paymentPrefix is put in front of the ID of a transaction to form the ID of a Mollie payment
+	paymentPrefix = "tr_"
+
+	// profilePrefix is put in front of the ID of a merchant to form the ID of a Mollie profile
+	profilePrefix = "pfl_"
+
+	// ContentType is the content type of every response of the Mollie API
+	ContentType = "application/hal+json"
+)
+
+// ErrInvalidID is returned for payment IDs that do not belong to a transaction
+var ErrInvalidID = errors.New("invalid payment ID")
+
+// PaymentID returns the Mollie payment ID of a transaction
+func PaymentID(id primitive.ObjectID) string {
+	return paymentPrefix + id.Hex()
+}
+
+// ParsePaymentID returns the ID of the transaction behind a Mollie payment ID
+func ParsePaymentID(paymentID string) (primitive.ObjectID, error) {
+	hex, ok := strings.CutPrefix(paymentID, paymentPrefix)
+	if !ok {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	return id, nil
+}
+
+// ProfileID returns the Mollie profile ID of a merchant
+func ProfileID(merchantID primitive.ObjectID) string {
+	return profilePrefix + merchantID.Hex()
+}
+
+// APIKey returns the key of the gate for a key given to a Mollie client. Mollie clients check that
+// keys start with "test_" or "live_", so the "sk_" prefix of the gate may be left out.
+func APIKey(key string) string {
+	for _, mode := range []merchants.Mode{merchants.ModeTest, merchants.ModeLive} {
+		if strings.HasPrefix(key, string(mode)+"_") {
+			return "sk_" + key
+		}
+	}
+	return key
+}
+
+// RedirectURL returns where the checkout page sends the customer. Like Mollie, the URL is left as
+// it is and canceled payments go to the cancel URL when there is one.
+func RedirectURL(transaction *transactions.Transaction) string {
+	if transaction.Status == transactions.StatusCanceled && transaction.CancelURL != "" {
+		return transaction.CancelURL
+	}
+	return transaction.RedirectURL
+}
+
+// WebhookPayload returns the form body Mollie posts to a webhook, which only holds the payment ID.
+// The receiver fetches the payment to learn what changed.
+func WebhookPayload(transaction *transactions.Transaction) []byte {
+	return []byte(url.Values{"id": {PaymentID(transaction.ID)}}.Encode())
+}
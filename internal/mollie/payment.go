@@ -0,0 +1,142 @@
+package mollie
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"time"
+)
+
+// documentationURL is linked from every payment
+const documentationURL = "https://docs.mollie.com/reference/v2/payments-api/get-payment"
+
+// Link is a link in the _links object of a Mollie resource
+type Link struct {
+	Href string `json:"href"`
+	Type string `json:"type"`
+}
+
+// PaymentLinks holds the links of a payment, Checkout is only set while the payment can be paid
+type PaymentLinks struct {
+	Self          Link  `json:"self"`
+	Checkout      *Link `json:"checkout,omitempty"`
+	Documentation Link  `json:"documentation"`
+}
+
+// Payment is the Mollie v2 representation of a transaction
+type Payment struct {
+	Resource        string              `json:"resource"`
+	ID              string              `json:"id"`
+	Mode            string              `json:"mode"`
+	CreatedAt       time.Time           `json:"createdAt"`
+	Amount          money.Money         `json:"amount"`
+	AmountRefunded  *money.Money        `json:"amountRefunded,omitempty"`
+	AmountRemaining *money.Money        `json:"amountRemaining,omitempty"`
+	AmountCaptured  *money.Money        `json:"amountCaptured,omitempty"`
+	Description     string              `json:"description"`
+	Method          *string             `json:"method"`
+	Metadata        json.RawMessage     `json:"metadata"`
+	Status          transactions.Status `json:"status"`
+	IsCancelable    bool                `json:"isCancelable"`
+	AuthorizedAt    *time.Time          `json:"authorizedAt,omitempty"`
+	PaidAt          *time.Time          `json:"paidAt,omitempty"`
+	CanceledAt      *time.Time          `json:"canceledAt,omitempty"`
+	ExpiresAt       *time.Time          `json:"expiresAt,omitempty"`
+	ExpiredAt       *time.Time          `json:"expiredAt,omitempty"`
+	FailedAt        *time.Time          `json:"failedAt,omitempty"`
+	ProfileID       string              `json:"profileId"`
+	SequenceType    string              `json:"sequenceType"`
+	CaptureMode     string              `json:"captureMode"`
+	RedirectURL     string              `json:"redirectUrl"`
+	CancelURL       string              `json:"cancelUrl,omitempty"`
+	WebhookURL      string              `json:"webhookUrl,omitempty"`
+	Links           PaymentLinks        `json:"_links"`
+}
+
+// NewPayment converts a transaction into a Mollie payment. The self link is built from baseURL,
+// the scheme and host the client used to reach the gate.
+func NewPayment(transaction *transactions.Transaction, baseURL, checkoutURL string) Payment {
+	payment := Payment{
+		Resource:     "payment",
+		ID:           PaymentID(transaction.ID),
+		Mode:         transaction.Mode,
+		CreatedAt:    transaction.Timestamp,
+		Amount:       transaction.Amount,
+		Description:  transaction.Reference,
+		Metadata:     json.RawMessage("null"),
+		Status:       transaction.Status,
+		IsCancelable: transaction.Status.CanTransitionTo(transactions.StatusCanceled),
+		ProfileID:    ProfileID(transaction.MerchantID),
+		SequenceType: "oneoff",
+		CaptureMode:  "automatic",
+		RedirectURL:  transaction.RedirectURL,
+		CancelURL:    transaction.CancelURL,
+		WebhookURL:   transaction.WebhookURL,
+		Links: PaymentLinks{
+			Self:          Link{Href: baseURL + "/v2/payments/" + PaymentID(transaction.ID), Type: ContentType},
+			Documentation: Link{Href: documentationURL, Type: "text/html"},
+		},
+	}
+	if payment.Mode == "" {
+		payment.Mode = "test"
+	}
+	if transaction.Metadata != "" {
+		payment.Metadata = json.RawMessage(transaction.Metadata)
+	}
+	if transaction.CaptureMethod == transactions.CaptureManual {
+		payment.CaptureMode = "manual"
+	}
+
+	// Only open payments can be paid on the checkout page
+	if transaction.Status == transactions.StatusOpen {
+		payment.Links.Checkout = &Link{Href: checkoutURL, Type: "text/html"}
+		payment.ExpiresAt = &transaction.ExpiresAt
+	}
+	if transaction.Status == transactions.StatusAuthorized {
+		payment.ExpiresAt = &transaction.ExpiresAt
+	}
+
+	// Add the time of the last change to each status
+	for i := range transaction.History {
+		change := &transaction.History[i]
+		switch change.To {
+		case transactions.StatusAuthorized:
+			payment.AuthorizedAt = &change.At
+		case transactions.StatusPaid:
+			payment.PaidAt = &change.At
+		case transactions.StatusCanceled:
+			payment.CanceledAt = &change.At
+		case transactions.StatusExpired:
+			payment.ExpiredAt = &change.At
+		case transactions.StatusFailed:
+			payment.FailedAt = &change.At
+		}
+	}
+
+	// Paid payments can be refunded
+	if transaction.Status == transactions.StatusPaid {
+		refunded, remaining := transaction.RefundedAmount(), transaction.RefundableAmount()
+		payment.AmountRefunded, payment.AmountRemaining = &refunded, &remaining
+		if transaction.CaptureMethod == transactions.CaptureManual {
+			payment.AmountCaptured = &transaction.Captured
+		}
+	}
+	return payment
+}
+
+// ListLinks holds the links of a page of payments, Previous and Next are null without another page
+type ListLinks struct {
+	Self          Link  `json:"self"`
+	Previous      *Link `json:"previous"`
+	Next          *Link `json:"next"`
+	Documentation Link  `json:"documentation"`
+}
+
+// PaymentList is a page of payments
+type PaymentList struct {
+	Count    int `json:"count"`
+	Embedded struct {
+		Payments []Payment `json:"payments"`
+	} `json:"_embedded"`
+	Links ListLinks `json:"_links"`
+}
@@ -0,0 +1,58 @@
+package stripe
+
+import "dev-payment-gate/utils/validation"
+
+const (
+	// ErrorTypeInvalidRequest is the type of errors caused by the parameters or state of a request
+	ErrorTypeInvalidRequest = "invalid_request_error"
+
+	// ErrorTypeAPI is the type of errors on the side of the gate
+	ErrorTypeAPI = "api_error"
+
+	// ErrorTypeIdempotency is the type of errors for an idempotency key reused with other parameters
+	ErrorTypeIdempotency = "idempotency_error"
+
+	// ErrorTypeCard is the type of the last payment error of a failed payment intent
+	ErrorTypeCard = "card_error"
+)
+
+// errorDocumentationURL is followed by the error code to link to its documentation
+const errorDocumentationURL = "https://stripe.com/docs/error-codes/"
+
+// Error is a Stripe error object. Param names the rejected form parameter of a request.
+type Error struct {
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	DocURL  string `json:"doc_url,omitempty"`
+}
+
+// ErrorResponse is the body of an error response of the Stripe API
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// NewError creates an error response, errors with a code link to its documentation
+func NewError(errorType, code, message, param string) ErrorResponse {
+	e := Error{Type: errorType, Code: code, Message: message, Param: param}
+	if code != "" {
+		e.DocURL = errorDocumentationURL + code
+	}
+	return ErrorResponse{Error: e}
+}
+
+// NewValidationError creates the error response for a rejected parameter. The codes of the
+// validation package are translated into their Stripe counterparts, Stripe codes are kept.
+func NewValidationError(fieldError validation.FieldError) ErrorResponse {
+	code := fieldError.Code
+	switch code {
+	case validation.CodeRequired:
+		code = "parameter_missing"
+	case validation.CodeInvalidURL:
+		code = "url_invalid"
+	case validation.CodeInvalid, validation.CodeTooLong, validation.CodeOutOfRange:
+		code = "parameter_invalid_string"
+	}
+	return NewError(ErrorTypeInvalidRequest, code, fieldError.Message, fieldError.Field)
+}
@@ -0,0 +1,119 @@
+package stripe
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event is a Stripe event object, the body of a Stripe webhook
+type Event struct {
+	ID         string `json:"id"`
+	Object     string `json:"object"`
+	APIVersion string `json:"api_version"`
+	Created    int64  `json:"created"`
+	Data       struct {
+		Object interface{} `json:"object"`
+	} `json:"data"`
+	Livemode        bool `json:"livemode"`
+	PendingWebhooks int  `json:"pending_webhooks"`
+	Request         struct {
+		ID             *string `json:"id"`
+		IdempotencyKey *string `json:"idempotency_key"`
+	} `json:"request"`
+	Type string `json:"type"`
+}
+
+// newEvent creates the event of the given type about an object of the transaction
+func newEvent(id primitive.ObjectID, created time.Time, transaction *transactions.Transaction, eventType string, object interface{}) *Event {
+	event := &Event{
+		ID:              EventID(id),
+		Object:          "event",
+		APIVersion:      APIVersion,
+		Created:         created.Unix(),
+		Livemode:        transaction.Mode == "live",
+		PendingWebhooks: 1,
+		Type:            eventType,
+	}
+	event.Data.Object = object
+	return event
+}
+
+// NewTransactionEvent returns the event Stripe sends when the transaction changed to its current
+// status, or nil when Stripe sends none. The event is about the checkout session for transactions
+// created through the checkout sessions API, until the session completed.
+func NewTransactionEvent(id primitive.ObjectID, created time.Time, transaction *transactions.Transaction) *Event {
+	var from transactions.Status
+	if len(transaction.History) > 0 {
+		from = transaction.History[len(transaction.History)-1].From
+	}
+
+	// A completed session only changes through its payment intent, e.g. when it is captured
+	if transaction.API == transactions.APIStripeCheckout && from != transactions.StatusAuthorized && from != transactions.StatusPaid {
+		eventType := sessionEventType(transaction.Status, from)
+		if eventType == "" {
+			return nil
+		}
+		return newEvent(id, created, transaction, eventType, NewSession(transaction, ""))
+	}
+
+	eventType := paymentIntentEventType(transaction.Status)
+	if eventType == "" {
+		return nil
+	}
+	return newEvent(id, created, transaction, eventType, NewPaymentIntent(transaction, ""))
+}
+
+// sessionEventType returns the type of the event about a checkout session that changed to the
+// given status. Sessions paid with a delayed payment method complete while pending and report the
+// outcome with an async event.
+func sessionEventType(status, from transactions.Status) string {
+	switch status {
+	case transactions.StatusPaid, transactions.StatusAuthorized:
+		if from == transactions.StatusPending {
+			return "checkout.session.async_payment_succeeded"
+		}
+		return "checkout.session.completed"
+	case transactions.StatusPending:
+		return "checkout.session.completed"
+	case transactions.StatusFailed, transactions.StatusCanceled, transactions.StatusExpired:
+		if from == transactions.StatusPending {
+			return "checkout.session.async_payment_failed"
+		}
+		return "checkout.session.expired"
+	default:
+		return ""
+	}
+}
+
+// paymentIntentEventType returns the type of the event about a payment intent that changed to the
+// given status
+func paymentIntentEventType(status transactions.Status) string {
+	switch status {
+	case transactions.StatusPending:
+		return "payment_intent.processing"
+	case transactions.StatusAuthorized:
+		return "payment_intent.amount_capturable_updated"
+	case transactions.StatusPaid:
+		return "payment_intent.succeeded"
+	case transactions.StatusFailed:
+		return "payment_intent.payment_failed"
+	case transactions.StatusCanceled, transactions.StatusExpired:
+		return "payment_intent.canceled"
+	default:
+		return ""
+	}
+}
+
+// NewRefundEvent returns the event Stripe sends when a refund of the transaction is created or settled
+func NewRefundEvent(id primitive.ObjectID, created time.Time, transaction *transactions.Transaction, refund *transactions.Refund) *Event {
+	eventType := "refund.updated"
+	switch refund.Status {
+	case transactions.RefundPending:
+		eventType = "refund.created"
+	case transactions.RefundFailed:
+		eventType = "refund.failed"
+	}
+	return newEvent(id, created, transaction, eventType, NewRefund(transaction, refund))
+}
@@ -0,0 +1,309 @@
+package stripe
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDescriptionLength limits the length of a description or client reference ID
+	maxDescriptionLength = 255
+
+	// maxMetadataKeys, maxMetadataKeyLength and maxMetadataValueLength are the metadata limits of Stripe
+	maxMetadataKeys        = 50
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
+
+	// maxLineItems limits the number of line items of a checkout session
+	maxLineItems = 100
+)
+
+// PaymentIntentInput represents the form data received to create a Stripe payment intent. Other
+// parameters Stripe accepts, such as confirm and payment_method_types, are ignored.
+type PaymentIntentInput struct {
+	Amount        money.Money
+	Description   string
+	ReturnURL     string
+	CaptureMethod string
+	Metadata      map[string]string
+}
+
+// SessionInput represents the form data received to create a Stripe checkout session. Only line
+// items with price_data are supported, their total is the amount of the session.
+type SessionInput struct {
+	Amount            money.Money
+	SuccessURL        string
+	CancelURL         string
+	ClientReferenceID string
+	CaptureMethod     string
+	ExpiresAt         *time.Time
+	Metadata          map[string]string
+}
+
+// ParsePaymentIntentInput parses and checks the form of a create payment intent request. It
+// returns validation.Errors listing every rejected parameter, or nil when the form is valid.
+func ParsePaymentIntentInput(form url.Values) (PaymentIntentInput, error) {
+	var errs validation.Errors
+	input := PaymentIntentInput{
+		Description:   form.Get("description"),
+		ReturnURL:     form.Get("return_url"),
+		CaptureMethod: form.Get("capture_method"),
+	}
+
+	input.Amount = parseAmount(&errs, form, "amount", "currency")
+	errs.MaxLength("description", input.Description, maxDescriptionLength)
+	errs.URL("return_url", input.ReturnURL, false)
+	validateCaptureMethod(&errs, "capture_method", input.CaptureMethod)
+	input.Metadata = parseMetadata(&errs, form)
+
+	return input, errs.Err()
+}
+
+// ParseSessionInput parses and checks the form of a create checkout session request at the given
+// time. It returns validation.Errors listing every rejected parameter, or nil when the form is valid.
+func ParseSessionInput(form url.Values, now time.Time) (SessionInput, error) {
+	var errs validation.Errors
+	input := SessionInput{
+		SuccessURL:        form.Get("success_url"),
+		CancelURL:         form.Get("cancel_url"),
+		ClientReferenceID: form.Get("client_reference_id"),
+		CaptureMethod:     form.Get("payment_intent_data[capture_method]"),
+	}
+
+	// Subscriptions and setup sessions have no single payment to emulate
+	switch mode := form.Get("mode"); mode {
+	case "payment":
+	case "":
+		errs.Add("mode", validation.CodeRequired, "Missing required param: mode.")
+	default:
+		errs.Add("mode", validation.CodeInvalid, "Invalid mode: %s. The gate only supports payment mode.", mode)
+	}
+
+	input.Amount = parseLineItems(&errs, form)
+	errs.URL("success_url", input.SuccessURL, true)
+	errs.URL("cancel_url", input.CancelURL, false)
+	errs.MaxLength("client_reference_id", input.ClientReferenceID, maxDescriptionLength)
+	validateCaptureMethod(&errs, "payment_intent_data[capture_method]", input.CaptureMethod)
+	input.Metadata = parseMetadata(&errs, form)
+
+	if value := form.Get("expires_at"); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs.Add("expires_at", "parameter_invalid_integer", "Invalid integer: %s", value)
+		} else if expiresAt := time.Unix(seconds, 0); !expiresAt.After(now) {
+			errs.Add("expires_at", validation.CodeOutOfRange, "The expires_at timestamp must be in the future.")
+		} else {
+			input.ExpiresAt = &expiresAt
+		}
+	}
+
+	return input, errs.Err()
+}
+
+// parseAmount parses an amount in minor units and its currency, adding an error for each
+// parameter that is missing or invalid
+func parseAmount(errs *validation.Errors, form url.Values, amountParam, currencyParam string) money.Money {
+	valid := true
+
+	// Stripe currencies are lowercase, the gate uses ISO 4217 codes
+	currency := strings.ToUpper(form.Get(currencyParam))
+	if _, ok := money.Exponent(currency); !ok {
+		valid = false
+		if currency == "" {
+			errs.Add(currencyParam, validation.CodeRequired, "Missing required param: %s.", currencyParam)
+		} else {
+			errs.Add(currencyParam, validation.CodeInvalid, "Invalid currency: %s.", strings.ToLower(currency))
+		}
+	}
+
+	var value int64
+	switch raw := form.Get(amountParam); {
+	case raw == "":
+		valid = false
+		errs.Add(amountParam, validation.CodeRequired, "Missing required param: %s.", amountParam)
+	default:
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			valid = false
+			errs.Add(amountParam, "parameter_invalid_integer", "Invalid integer: %s", raw)
+		}
+		value = parsed
+	}
+	if !valid {
+		return money.Money{}
+	}
+
+	amount := money.New(value, currency)
+	validateAmount(errs, amountParam, amount)
+	return amount
+}
+
+// validateAmount adds an error with the Stripe code when the amount is out of bounds
+func validateAmount(errs *validation.Errors, param string, amount money.Money) {
+	var amountErrs validation.Errors
+	transactions.ValidateAmount(&amountErrs, param, amount)
+	for _, amountErr := range amountErrs {
+		code := "amount_too_large"
+		if amount.Value <= 0 {
+			code = "amount_too_small"
+		}
+		errs.Add(param, code, "%s", amountErr.Message)
+	}
+}
+
+// parseLineItems returns the total of the line items of a checkout session. Every line item needs
+// price_data in the same currency and a quantity.
+func parseLineItems(errs *validation.Errors, form url.Values) money.Money {
+	var total money.Money
+	valid := true
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("line_items[%d]", i)
+		if !hasPrefix(form, prefix) {
+			if i == 0 {
+				errs.Add("line_items", validation.CodeRequired, "Missing required param: line_items.")
+				return money.Money{}
+			}
+			break
+		}
+		if i == maxLineItems {
+			errs.Add("line_items", validation.CodeOutOfRange, "A checkout session can have at most %d line items.", maxLineItems)
+			return money.Money{}
+		}
+
+		// Prices are not stored by the gate, so only inline prices can be used
+		if price := form.Get(prefix + "[price]"); price != "" {
+			errs.Add(prefix+"[price]", "resource_missing", "No such price: '%s'; the gate only supports price_data.", price)
+			valid = false
+			continue
+		}
+		if name := prefix + "[price_data][product_data][name]"; form.Get(name) == "" {
+			errs.Add(name, validation.CodeRequired, "Missing required param: %s.", name)
+		}
+		price := parseAmount(errs, form, prefix+"[price_data][unit_amount]", prefix+"[price_data][currency]")
+
+		quantityParam := prefix + "[quantity]"
+		quantity, err := strconv.ParseInt(form.Get(quantityParam), 10, 64)
+		switch {
+		case form.Get(quantityParam) == "":
+			errs.Add(quantityParam, validation.CodeRequired, "Missing required param: %s.", quantityParam)
+		case err != nil || quantity < 1 || quantity > 999999:
+			errs.Add(quantityParam, "parameter_invalid_integer", "The quantity must be between 1 and 999999.")
+		}
+		if price.Currency == "" || quantity < 1 || quantity > 999999 {
+			valid = false
+			continue
+		}
+
+		// Add the line item to the total, which has a single currency
+		if total.Currency != "" && price.Currency != total.Currency {
+			errs.Add(prefix+"[price_data][currency]", validation.CodeInvalid, "All line items must use the same currency.")
+			valid = false
+			continue
+		}
+		total = money.New(total.Value+price.Value*quantity, price.Currency)
+	}
+	if !valid {
+		return money.Money{}
+	}
+
+	validateAmount(errs, "line_items", total)
+	return total
+}
+
+// hasPrefix reports whether the form has a parameter starting with prefix
+func hasPrefix(form url.Values, prefix string) bool {
+	for key := range form {
+		if strings.HasPrefix(key, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCaptureMethod adds an error unless the capture method is empty or one Stripe knows
+func validateCaptureMethod(errs *validation.Errors, param, captureMethod string) {
+	switch captureMethod {
+	case "", "automatic", "automatic_async", "manual":
+	default:
+		errs.Add(param, validation.CodeInvalid, "Invalid %s: must be one of automatic, automatic_async, or manual", param)
+	}
+}
+
+// parseMetadata collects the metadata[key] parameters of the form within the limits of Stripe
+func parseMetadata(errs *validation.Errors, form url.Values) map[string]string {
+	values := map[string]string{}
+	for param := range form {
+		key, ok := strings.CutPrefix(param, "metadata[")
+		if !ok || !strings.HasSuffix(key, "]") {
+			continue
+		}
+		key = strings.TrimSuffix(key, "]")
+		value := form.Get(param)
+
+		switch {
+		case len(key) > maxMetadataKeyLength:
+			errs.Add(param, validation.CodeTooLong, "Metadata keys can have up to %d characters.", maxMetadataKeyLength)
+		case len(value) > maxMetadataValueLength:
+			errs.Add(param, validation.CodeTooLong, "Metadata values can have up to %d characters.", maxMetadataValueLength)
+		case value != "":
+			// Empty values unset a key in Stripe, which is the same as leaving it out on creation
+			values[key] = value
+		}
+	}
+	if len(values) > maxMetadataKeys {
+		errs.Add("metadata", validation.CodeOutOfRange, "Metadata can have up to %d keys.", maxMetadataKeys)
+	}
+	return values
+}
+
+// captureMethod returns the capture method of the transaction for a Stripe capture method
+func captureMethod(stripeCaptureMethod string) transactions.CaptureMethod {
+	if stripeCaptureMethod == "manual" {
+		return transactions.CaptureManual
+	}
+	return transactions.CaptureAutomatic
+}
+
+// encodeMetadata returns the metadata as it is stored with the transaction
+func encodeMetadata(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// Transaction creates the transaction of the payment intent
+func (input PaymentIntentInput) Transaction() transactions.Transaction {
+	transaction := transactions.Create(transactions.TransactionInput{
+		Amount:        input.Amount,
+		RedirectURL:   input.ReturnURL,
+		Reference:     input.Description,
+		CaptureMethod: captureMethod(input.CaptureMethod),
+	})
+	transaction.API = transactions.APIStripe
+	transaction.Metadata = encodeMetadata(input.Metadata)
+	return transaction
+}
+
+// Transaction creates the transaction of the checkout session
+func (input SessionInput) Transaction() transactions.Transaction {
+	transaction := transactions.Create(transactions.TransactionInput{
+		Amount:        input.Amount,
+		RedirectURL:   input.SuccessURL,
+		Reference:     input.ClientReferenceID,
+		CaptureMethod: captureMethod(input.CaptureMethod),
+		ExpiresAt:     input.ExpiresAt,
+	})
+	transaction.API = transactions.APIStripeCheckout
+	transaction.CancelURL = input.CancelURL
+	transaction.Metadata = encodeMetadata(input.Metadata)
+	return transaction
+}
@@ -0,0 +1,230 @@
+package stripe
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"strings"
+)
+
+// RedirectToURL tells a client where to send the customer to pay a payment intent
+type RedirectToURL struct {
+	URL       string  `json:"url"`
+	ReturnURL *string `json:"return_url"`
+}
+
+// NextAction is the action a payment intent waits for, which is always a redirect to the checkout page
+type NextAction struct {
+	Type          string        `json:"type"`
+	RedirectToURL RedirectToURL `json:"redirect_to_url"`
+}
+
+// PaymentIntent is the Stripe representation of a transaction created through the payment intents API
+type PaymentIntent struct {
+	ID                 string            `json:"id"`
+	Object             string            `json:"object"`
+	Amount             int64             `json:"amount"`
+	AmountCapturable   int64             `json:"amount_capturable"`
+	AmountReceived     int64             `json:"amount_received"`
+	CanceledAt         *int64            `json:"canceled_at"`
+	CancellationReason *string           `json:"cancellation_reason"`
+	CaptureMethod      string            `json:"capture_method"`
+	ClientSecret       string            `json:"client_secret"`
+	Created            int64             `json:"created"`
+	Currency           string            `json:"currency"`
+	Description        *string           `json:"description"`
+	LastPaymentError   *Error            `json:"last_payment_error"`
+	Livemode           bool              `json:"livemode"`
+	Metadata           map[string]string `json:"metadata"`
+	NextAction         *NextAction       `json:"next_action"`
+	PaymentMethodTypes []string          `json:"payment_method_types"`
+	ReturnURL          *string           `json:"return_url"`
+	Status             string            `json:"status"`
+}
+
+// Session is the Stripe representation of a transaction created through the checkout sessions API
+type Session struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	AmountSubtotal    int64             `json:"amount_subtotal"`
+	AmountTotal       int64             `json:"amount_total"`
+	CancelURL         *string           `json:"cancel_url"`
+	ClientReferenceID *string           `json:"client_reference_id"`
+	Created           int64             `json:"created"`
+	Currency          string            `json:"currency"`
+	ExpiresAt         int64             `json:"expires_at"`
+	Livemode          bool              `json:"livemode"`
+	Metadata          map[string]string `json:"metadata"`
+	Mode              string            `json:"mode"`
+	PaymentIntent     string            `json:"payment_intent"`
+	PaymentStatus     string            `json:"payment_status"`
+	Status            string            `json:"status"`
+	SuccessURL        string            `json:"success_url"`
+	URL               *string           `json:"url"`
+}
+
+// Refund is the Stripe representation of a refund of a transaction
+type Refund struct {
+	ID            string            `json:"id"`
+	Object        string            `json:"object"`
+	Amount        int64             `json:"amount"`
+	Created       int64             `json:"created"`
+	Currency      string            `json:"currency"`
+	Metadata      map[string]string `json:"metadata"`
+	PaymentIntent string            `json:"payment_intent"`
+	Reason        *string           `json:"reason"`
+	Status        string            `json:"status"`
+}
+
+// List is a page of Stripe objects, the next page starts after the last object of Data
+type List struct {
+	Object  string      `json:"object"`
+	Data    interface{} `json:"data"`
+	HasMore bool        `json:"has_more"`
+	URL     string      `json:"url"`
+}
+
+// NewList creates a page of objects listed at the given path
+func NewList(data interface{}, hasMore bool, path string) List {
+	return List{Object: "list", Data: data, HasMore: hasMore, URL: path}
+}
+
+// optional returns nil for empty strings, which Stripe sends as null
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// lastChange returns the last change of the transaction to the given status
+func lastChange(transaction *transactions.Transaction, status transactions.Status) *transactions.StatusChange {
+	for i := len(transaction.History) - 1; i >= 0; i-- {
+		if transaction.History[i].To == status {
+			return &transaction.History[i]
+		}
+	}
+	return nil
+}
+
+// NewPaymentIntent converts a transaction into a payment intent. While the transaction is open the
+// payment intent waits for the customer to be redirected to checkoutURL.
+func NewPaymentIntent(transaction *transactions.Transaction, checkoutURL string) PaymentIntent {
+	intent := PaymentIntent{
+		ID:                 PaymentIntentID(transaction.ID),
+		Object:             "payment_intent",
+		Amount:             transaction.Amount.Value,
+		CaptureMethod:      "automatic",
+		ClientSecret:       clientSecret(transaction),
+		Created:            transaction.Timestamp.Unix(),
+		Currency:           strings.ToLower(transaction.Amount.Currency),
+		Livemode:           transaction.Mode == "live",
+		Metadata:           metadata(transaction),
+		PaymentMethodTypes: []string{"card"},
+		Status:             paymentIntentStatus(transaction.Status),
+	}
+	if transaction.CaptureMethod == transactions.CaptureManual {
+		intent.CaptureMethod = "manual"
+	}
+	if transaction.API != transactions.APIStripeCheckout {
+		intent.Description = optional(transaction.Reference)
+		intent.ReturnURL = optional(transaction.RedirectURL)
+	}
+
+	switch transaction.Status {
+	case transactions.StatusOpen:
+		intent.NextAction = &NextAction{
+			Type:          "redirect_to_url",
+			RedirectToURL: RedirectToURL{URL: checkoutURL, ReturnURL: intent.ReturnURL},
+		}
+	case transactions.StatusAuthorized:
+		intent.AmountCapturable = transaction.Amount.Value
+	case transactions.StatusPaid:
+		intent.AmountReceived = transaction.Amount.Value
+		if transaction.CaptureMethod == transactions.CaptureManual {
+			intent.AmountReceived = transaction.Captured.Value
+		}
+	case transactions.StatusFailed:
+		intent.LastPaymentError = &Error{Type: ErrorTypeCard, Code: "card_declined", Message: "Your card was declined."}
+	case transactions.StatusCanceled, transactions.StatusExpired:
+		reason := "requested_by_customer"
+		if transaction.Status == transactions.StatusExpired {
+			reason = "abandoned"
+		}
+		intent.CancellationReason = &reason
+		if change := lastChange(transaction, transaction.Status); change != nil {
+			canceledAt := change.At.Unix()
+			intent.CanceledAt = &canceledAt
+		}
+	}
+	return intent
+}
+
+// paymentIntentStatus returns the status of the payment intent of a transaction with the given
+// status. Open transactions wait for the customer to pay on the checkout page.
+func paymentIntentStatus(status transactions.Status) string {
+	switch status {
+	case transactions.StatusOpen:
+		return "requires_action"
+	case transactions.StatusPending:
+		return "processing"
+	case transactions.StatusAuthorized:
+		return "requires_capture"
+	case transactions.StatusPaid:
+		return "succeeded"
+	case transactions.StatusCanceled, transactions.StatusExpired:
+		return "canceled"
+	default:
+		return "requires_payment_method"
+	}
+}
+
+// NewSession converts a transaction into a checkout session. The URL to pay the session is only
+// set while it is open and checkoutURL is known.
+func NewSession(transaction *transactions.Transaction, checkoutURL string) Session {
+	session := Session{
+		ID:                SessionID(transaction),
+		Object:            "checkout.session",
+		AmountSubtotal:    transaction.Amount.Value,
+		AmountTotal:       transaction.Amount.Value,
+		CancelURL:         optional(transaction.CancelURL),
+		ClientReferenceID: optional(transaction.Reference),
+		Created:           transaction.Timestamp.Unix(),
+		Currency:          strings.ToLower(transaction.Amount.Currency),
+		ExpiresAt:         transaction.ExpiresAt.Unix(),
+		Livemode:          transaction.Mode == "live",
+		Metadata:          metadata(transaction),
+		Mode:              "payment",
+		PaymentIntent:     PaymentIntentID(transaction.ID),
+		PaymentStatus:     "unpaid",
+		SuccessURL:        transaction.RedirectURL,
+	}
+
+	switch transaction.Status {
+	case transactions.StatusOpen:
+		session.Status = "open"
+		session.URL = optional(checkoutURL)
+	case transactions.StatusPaid:
+		session.Status = "complete"
+		session.PaymentStatus = "paid"
+	case transactions.StatusAuthorized, transactions.StatusPending:
+		// The session is completed, but the money is not available until it is captured or settled
+		session.Status = "complete"
+	default:
+		session.Status = "expired"
+	}
+	return session
+}
+
+// NewRefund converts a refund of a transaction into a Stripe refund
+func NewRefund(transaction *transactions.Transaction, refund *transactions.Refund) Refund {
+	return Refund{
+		ID:            RefundID(refund.ID),
+		Object:        "refund",
+		Amount:        refund.Amount.Value,
+		Created:       refund.CreatedAt.Unix(),
+		Currency:      strings.ToLower(refund.Amount.Currency),
+		Metadata:      map[string]string{},
+		PaymentIntent: PaymentIntentID(transaction.ID),
+		Reason:        optional(refund.Reason),
+		Status:        string(refund.Status),
+	}
+}
@@ -0,0 +1,142 @@
+package stripe
+
+import (
+	"crypto/sha256"
+	"dev-payment-gate/utils/model/transactions"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// paymentIntentPrefix, sessionPrefix, refundPrefix and eventPrefix are put in front of the IDs
+	// of the gate to form the IDs of Stripe objects. Sessions also carry the mode, e.g. "cs_test_".
+	paymentIntentPrefix = "pi_"
+	sessionPrefix       = "cs_"
+	refundPrefix        = "re_"
+	eventPrefix         = "evt_"
+
+	// APIVersion is the Stripe API version the objects are shaped after
+	APIVersion = "2023-10-16"
+
+	// SignatureHeader is the HTTP header carrying the signature of a Stripe webhook
+	SignatureHeader = "Stripe-Signature"
+)
+
+// ErrInvalidID is returned for Stripe IDs that do not belong to a transaction
+var ErrInvalidID = errors.New("invalid Stripe ID")
+
+// PaymentIntentID returns the ID of the payment intent of a transaction
+func PaymentIntentID(id primitive.ObjectID) string {
+	return paymentIntentPrefix + id.Hex()
+}
+
+// SessionID returns the ID of the checkout session of a transaction
+func SessionID(transaction *transactions.Transaction) string {
+	return sessionPrefix + mode(transaction) + "_" + transaction.ID.Hex()
+}
+
+// RefundID returns the Stripe ID of a refund
+func RefundID(id primitive.ObjectID) string {
+	return refundPrefix + id.Hex()
+}
+
+// EventID returns the Stripe ID of the event sent with a webhook delivery
+func EventID(deliveryID primitive.ObjectID) string {
+	return eventPrefix + deliveryID.Hex()
+}
+
+// ParsePaymentIntentID returns the ID of the transaction behind a payment intent ID
+func ParsePaymentIntentID(paymentIntentID string) (primitive.ObjectID, error) {
+	hex, ok := strings.CutPrefix(paymentIntentID, paymentIntentPrefix)
+	if !ok {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	return parseHex(hex)
+}
+
+// ParseSessionID returns the ID of the transaction behind a checkout session ID
+func ParseSessionID(sessionID string) (primitive.ObjectID, error) {
+	for _, prefix := range []string{sessionPrefix + "test_", sessionPrefix + "live_"} {
+		if hex, ok := strings.CutPrefix(sessionID, prefix); ok {
+			return parseHex(hex)
+		}
+	}
+	return primitive.NilObjectID, ErrInvalidID
+}
+
+// parseHex parses the hex encoded ID of a transaction
+func parseHex(hex string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	return id, nil
+}
+
+// mode returns "live" or "test" for the transaction
+func mode(transaction *transactions.Transaction) string {
+	if transaction.Mode == "live" {
+		return "live"
+	}
+	return "test"
+}
+
+// clientSecret returns the client secret of a payment intent. The gate never asks for it, it is
+// only there for clients that expect one.
+func clientSecret(transaction *transactions.Transaction) string {
+	sum := sha256.Sum256([]byte(transaction.ID.Hex()))
+	return PaymentIntentID(transaction.ID) + "_secret_" + hex.EncodeToString(sum[:12])
+}
+
+// metadata returns the metadata of a transaction as the string map Stripe uses
+func metadata(transaction *transactions.Transaction) map[string]string {
+	values := map[string]string{}
+	if transaction.Metadata != "" {
+		json.Unmarshal([]byte(transaction.Metadata), &values)
+	}
+	return values
+}
+
+// RedirectURL returns where the checkout page sends the customer. Checkout sessions go to their
+// success or cancel URL like Stripe Checkout, payment intents go to their return URL with the
+// query parameters Stripe adds after a redirect.
+func RedirectURL(transaction *transactions.Transaction) string {
+	if transaction.API == transactions.APIStripeCheckout {
+		redirect := transaction.RedirectURL
+		switch transaction.Status {
+		case transactions.StatusPaid, transactions.StatusAuthorized, transactions.StatusPending:
+		default:
+			if transaction.CancelURL != "" {
+				redirect = transaction.CancelURL
+			}
+		}
+		return strings.ReplaceAll(redirect, "{CHECKOUT_SESSION_ID}", SessionID(transaction))
+	}
+
+	// Payment intents without a return URL show their status on the checkout page
+	if transaction.RedirectURL == "" {
+		return "/transaction/" + transaction.ID.Hex()
+	}
+	redirect, err := url.Parse(transaction.RedirectURL)
+	if err != nil {
+		return transaction.RedirectURL
+	}
+	status := "failed"
+	switch transaction.Status {
+	case transactions.StatusPaid, transactions.StatusAuthorized:
+		status = "succeeded"
+	case transactions.StatusPending:
+		status = "processing"
+	}
+	query := redirect.Query()
+	query.Set("payment_intent", PaymentIntentID(transaction.ID))
+	query.Set("payment_intent_client_secret", clientSecret(transaction))
+	query.Set("redirect_status", status)
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
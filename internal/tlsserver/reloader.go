@@ -0,0 +1,78 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate from files that can be replaced while the server is running
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certMod     time.Time
+	keyMod      time.Time
+}
+
+// NewReloader loads the certificate and private key from the given PEM files
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the last loaded certificate, it is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// Reload loads the certificate from its files again. The previous certificate stays in use when
+// the files can not be loaded, e.g. while only one of them has been replaced.
+func (r *Reloader) Reload() error {
+	certMod, keyMod := modTime(r.certFile), modTime(r.keyFile)
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	// Lock the mutex to safely swap the certificate
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certMod, r.keyMod = certMod, keyMod
+	if err != nil {
+		return fmt.Errorf("unable to load the certificate %s: %v", r.certFile, err)
+	}
+	r.certificate = &certificate
+	return nil
+}
+
+// ReloadIfChanged reloads the certificate when either of its files was modified since it was last
+// loaded and reports whether it did
+func (r *Reloader) ReloadIfChanged() (bool, error) {
+	r.mu.RLock()
+	changed := !modTime(r.certFile).Equal(r.certMod) || !modTime(r.keyFile).Equal(r.keyMod)
+	r.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	if err := r.Reload(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// modTime returns when a file was last modified, or the zero time when it can not be read
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,164 @@
+package tlsserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultCertFile and defaultKeyFile are used for a self-signed certificate without configured files
+	defaultCertFile = "dev-payment-gate.crt"
+	defaultKeyFile  = "dev-payment-gate.key"
+)
+
+// Config holds the settings for serving HTTPS
+type Config struct {
+	CertFile       string
+	KeyFile        string
+	SelfSigned     bool
+	Hosts          []string
+	ReloadInterval time.Duration
+}
+
+// ConfigFromEnv reads the HTTPS settings from the environment, falling back to the defaults
+func ConfigFromEnv() Config {
+	config := Config{
+		CertFile:       os.Getenv("TLS_CERT_FILE"),
+		KeyFile:        os.Getenv("TLS_KEY_FILE"),
+		SelfSigned:     os.Getenv("TLS_SELF_SIGNED") == "true",
+		Hosts:          []string{"localhost", "127.0.0.1", "::1"},
+		ReloadInterval: 5 * time.Second,
+	}
+	if hosts := os.Getenv("TLS_HOSTS"); hosts != "" {
+		config.Hosts = nil
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				config.Hosts = append(config.Hosts, host)
+			}
+		}
+	}
+	if interval, err := time.ParseDuration(os.Getenv("TLS_RELOAD_INTERVAL")); err == nil && interval > 0 {
+		config.ReloadInterval = interval
+	}
+	if config.SelfSigned && config.CertFile == "" && config.KeyFile == "" {
+		config.CertFile, config.KeyFile = defaultCertFile, defaultKeyFile
+	}
+	return config
+}
+
+// Enabled reports whether the server should be served over HTTPS
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.SelfSigned
+}
+
+// watcher reloads the certificate on SIGHUP and whenever its files change
+type watcher struct {
+	reloader *Reloader
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	running     *watcher
+	runningLock sync.Mutex
+)
+
+// Start loads the certificate, generating a self-signed one first when asked to, and returns the
+// TLS settings for the server. The certificate is reloaded in the background on SIGHUP and when
+// its files change, so renewed certificates are picked up without a restart.
+func Start(config Config) (*tls.Config, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE have to be set together")
+	}
+
+	// Generate a self-signed certificate unless one was generated before
+	if config.SelfSigned {
+		generated, err := EnsureSelfSigned(config.CertFile, config.KeyFile, config.Hosts, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate a self-signed certificate: %v", err)
+		}
+		if generated {
+			log.Printf("Generated a self-signed certificate in %s for %s", config.CertFile, strings.Join(config.Hosts, ", "))
+		}
+	}
+
+	// Load the certificate
+	reloader, err := NewReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	Stop()
+
+	w := &watcher{
+		reloader: reloader,
+		interval: config.ReloadInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+
+	// Lock the mutex to safely set the watcher
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	running = w
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// Stop halts reloading the certificate, the last loaded certificate stays in use
+func Stop() {
+	// Lock the mutex to safely clear the watcher
+	runningLock.Lock()
+	w := running
+	running = nil
+	runningLock.Unlock()
+
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+// run reloads the certificate until the watcher is stopped
+func (w *watcher) run() {
+	defer close(w.done)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-hangup:
+			if err := w.reloader.Reload(); err != nil {
+				log.Printf("[Warning] failed to reload the TLS certificate: %v", err)
+			} else {
+				log.Println("Reloaded the TLS certificate")
+			}
+		case <-ticker.C:
+			if reloaded, err := w.reloader.ReloadIfChanged(); err != nil {
+				log.Printf("[Warning] failed to reload the TLS certificate: %v", err)
+			} else if reloaded {
+				log.Println("Reloaded the changed TLS certificate")
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig builds the TLS settings used to call webhooks. Certificates are verified against the
+// system roots plus the CA bundle in CAFile, and the client certificate is presented for mTLS
+// when both ClientCertFile and ClientKeyFile are set.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	// Trust the extra CA bundle on top of the system roots
+	if c.CAFile != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read WEBHOOK_CA_FILE: %v", err)
+		}
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no PEM certificates found in WEBHOOK_CA_FILE %s", c.CAFile)
+		}
+		config.RootCAs = roots
+	}
+
+	// Present a client certificate when both halves are configured
+	switch {
+	case c.ClientCertFile != "" && c.ClientKeyFile != "":
+		certificate, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the webhook client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	case c.ClientCertFile != "" || c.ClientKeyFile != "":
+		return nil, errors.New("WEBHOOK_CLIENT_CERT and WEBHOOK_CLIENT_KEY have to be set together")
+	}
+
+	return config, nil
+}
+
+// insecureTLSConfig returns the TLS settings for merchants that opted out of certificate
+// verification. The client certificate is still presented.
+func insecureTLSConfig(config *tls.Config) *tls.Config {
+	insecure := config.Clone()
+	insecure.InsecureSkipVerify = true
+	return insecure
+}
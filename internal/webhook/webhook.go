@@ -0,0 +1,474 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"dev-payment-gate/internal/mollie"
+	"dev-payment-gate/internal/stripe"
+	"dev-payment-gate/pkg/signature"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// batchSize limits how many due deliveries are attempted per poll
+	batchSize = 50
+
+	// maxResponseBody limits how much of a webhook response is kept with its attempt
+	maxResponseBody = 4 << 10
+)
+
+// Payload is the JSON body posted to the webhook of a transaction
+type Payload struct {
+	ID            string         `json:"id"`
+	Event         string         `json:"event"`
+	TransactionID string         `json:"transaction_id"`
+	Status        string         `json:"status"`
+	Refund        *RefundPayload `json:"refund,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// RefundPayload describes the refund a refund event is about
+type RefundPayload struct {
+	ID     string      `json:"id"`
+	Amount money.Money `json:"amount"`
+	Status string      `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// Config holds the retry behaviour of the delivery worker
+type Config struct {
+	// MaxAttempts is the number of attempts after which a delivery is dead-lettered
+	MaxAttempts int
+
+	// BaseBackoff is the delay after the first failed attempt, it doubles after every next failure
+	BaseBackoff time.Duration
+
+	// MaxBackoff caps the delay between two attempts
+	MaxBackoff time.Duration
+
+	// Timeout limits how long a single attempt may take
+	Timeout time.Duration
+
+	// PollInterval is how often the queue is checked for due deliveries
+	PollInterval time.Duration
+
+	// Secret signs the payloads of merchants without a webhook secret of their own, such as
+	// merchants stored before they had one. Payloads are not signed when neither is set.
+	Secret string
+
+	// CAFile is a PEM bundle of certificate authorities trusted next to the system roots
+	CAFile string
+
+	// ClientCertFile and ClientKeyFile hold the PEM client certificate presented for mTLS
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+// ConfigFromEnv reads the worker configuration from the environment, using defaults for missing values
+func ConfigFromEnv() Config {
+	return Config{
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseBackoff:  envDuration("WEBHOOK_BASE_BACKOFF", 5*time.Second),
+		MaxBackoff:   envDuration("WEBHOOK_MAX_BACKOFF", time.Hour),
+		Timeout:      envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		PollInterval: envDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		Secret:       os.Getenv("WEBHOOK_SECRET"),
+
+		CAFile:         os.Getenv("WEBHOOK_CA_FILE"),
+		ClientCertFile: os.Getenv("WEBHOOK_CLIENT_CERT"),
+		ClientKeyFile:  os.Getenv("WEBHOOK_CLIENT_KEY"),
+	}
+}
+
+// envInt parses a positive integer from the environment
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// envDuration parses a positive duration from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts
+func (c Config) Backoff(attempts int) time.Duration {
+	delay := c.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= c.MaxBackoff {
+			return c.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Worker delivers queued webhooks in the background
+type Worker struct {
+	config Config
+	client *http.Client
+
+	// insecureClient skips certificate verification for merchants that opted in
+	insecureClient *http.Client
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	worker     *Worker
+	workerLock sync.Mutex
+)
+
+// newClient creates an HTTP client for webhook calls with the given TLS settings
+func newClient(config Config, tlsConfig *tls.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: config.Timeout, Transport: transport}
+}
+
+// Start launches the background delivery worker, restarting it when one is already running. It
+// fails when the TLS settings can not be loaded.
+func Start(config Config) error {
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return err
+	}
+	Stop()
+
+	w := &Worker{
+		config:         config,
+		client:         newClient(config, tlsConfig),
+		insecureClient: newClient(config, insecureTLSConfig(tlsConfig)),
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go w.run()
+
+	// Lock the mutex to safely set the worker
+	workerLock.Lock()
+	defer workerLock.Unlock()
+	worker = w
+	return nil
+}
+
+// Stop halts the background delivery worker after its current attempt, queued deliveries stay queued
+func Stop() {
+	// Lock the mutex to safely clear the worker
+	workerLock.Lock()
+	w := worker
+	worker = nil
+	workerLock.Unlock()
+
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+// Enqueue queues a notification about the current status of the transaction and returns the queued delivery
+func Enqueue(ctx context.Context, transaction *transactions.Transaction) (*deliveries.Delivery, error) {
+	return enqueue(ctx, transaction, fmt.Sprintf("transaction.%s", transaction.Status), nil)
+}
+
+// EnqueueRefund queues a notification about the current status of a refund and returns the queued delivery
+func EnqueueRefund(ctx context.Context, transaction *transactions.Transaction, refund *transactions.Refund) (*deliveries.Delivery, error) {
+	return enqueue(ctx, transaction, fmt.Sprintf("refund.%s", refund.Status), refund)
+}
+
+// enqueue stores a delivery of the event for the webhook of the transaction and wakes the worker.
+// Transactions without a webhook, which emulated APIs allow, and events the emulated API does not
+// send are not notified and return nil.
+func enqueue(ctx context.Context, transaction *transactions.Transaction, event string, refund *transactions.Refund) (*deliveries.Delivery, error) {
+	if transaction.WebhookURL == "" {
+		return nil, nil
+	}
+
+	// Create the delivery first, its ID doubles as the event ID so receivers can deduplicate retries
+	delivery := deliveries.Create(transaction.ID, event, transaction.WebhookURL, transaction.WebhookKey, nil)
+
+	// Notify in the format of the API the transaction was created with
+	switch transaction.API {
+	case transactions.APIMollie:
+		delivery.ContentType = "application/x-www-form-urlencoded"
+		delivery.Payload = mollie.WebhookPayload(transaction)
+	case transactions.APIStripe, transactions.APIStripeCheckout:
+		stripeEvent := stripe.NewTransactionEvent(delivery.ID, delivery.CreatedAt, transaction)
+		if refund != nil {
+			stripeEvent = stripe.NewRefundEvent(delivery.ID, delivery.CreatedAt, transaction, refund)
+		}
+		if stripeEvent == nil {
+			return nil, nil
+		}
+		payload, err := json.Marshal(stripeEvent)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Event = stripeEvent.Type
+		delivery.Payload = payload
+		delivery.SignatureHeader = stripe.SignatureHeader
+	default:
+		var refundPayload *RefundPayload
+		if refund != nil {
+			refundPayload = &RefundPayload{
+				ID:     refund.ID.Hex(),
+				Amount: refund.Amount,
+				Status: string(refund.Status),
+				Reason: refund.Reason,
+			}
+		}
+		payload, err := json.Marshal(Payload{
+			ID:            delivery.ID.Hex(),
+			Event:         event,
+			TransactionID: transaction.ID.Hex(),
+			Status:        string(transaction.Status),
+			Refund:        refundPayload,
+			CreatedAt:     delivery.CreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+	}
+
+	// Store the delivery in the persistent queue
+	if _, err := deliveries.Insert(ctx, &delivery); err != nil {
+		return nil, err
+	}
+
+	wake()
+	return &delivery, nil
+}
+
+// merchantOf returns the merchant owning the transaction, or nil for transactions without one
+func merchantOf(ctx context.Context, transaction *transactions.Transaction) (*merchants.Merchant, error) {
+	if transaction.MerchantID.IsZero() {
+		return nil, nil
+	}
+	merchant, err := merchants.GetByID(ctx, transaction.MerchantID)
+	if errors.Is(err, merchants.ErrNotFound) {
+		return nil, nil
+	}
+	return merchant, err
+}
+
+// deliveryMerchant returns the merchant owning the transaction of the delivery, or nil when the
+// transaction or its merchant no longer exists. It is looked up for every attempt so changed
+// webhook settings of the merchant, such as its secret, are used for retries as well.
+func deliveryMerchant(ctx context.Context, delivery *deliveries.Delivery) (*merchants.Merchant, error) {
+	transaction, err := transactions.GetByID(ctx, delivery.TransactionID)
+	if errors.Is(err, transactions.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return merchantOf(ctx, transaction)
+}
+
+// signingSecret returns the secret payloads for the merchant are signed with, which is its webhook
+// secret and Config.Secret for merchants without one
+func (w *Worker) signingSecret(merchant *merchants.Merchant) string {
+	if merchant != nil && merchant.WebhookSecret != "" {
+		return merchant.WebhookSecret
+	}
+	return w.config.Secret
+}
+
+// Resend queues a stored delivery for another attempt with the same payload, also when it was
+// delivered or dead-lettered before
+func Resend(ctx context.Context, id primitive.ObjectID) (*deliveries.Delivery, error) {
+	delivery, err := deliveries.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Requeue(time.Now())
+	if err := deliveries.Update(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	wake()
+	return delivery, nil
+}
+
+// Replay queues a new delivery of a past event with exactly the same payload, so the event ID
+// stays the same. The original delivery is left as it is.
+func Replay(ctx context.Context, id primitive.ObjectID) (*deliveries.Delivery, error) {
+	original, err := deliveries.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the event into a new delivery that points back to the original
+	delivery := deliveries.Create(original.TransactionID, original.Event, original.URL, original.Key, original.Payload)
+	delivery.ReplayOf = original.ID
+	delivery.ContentType = original.ContentType
+	delivery.SignatureHeader = original.SignatureHeader
+	if _, err := deliveries.Insert(ctx, &delivery); err != nil {
+		return nil, err
+	}
+
+	wake()
+	return &delivery, nil
+}
+
+// wake wakes the worker so a queued delivery is not delayed by the poll interval
+func wake() {
+	workerLock.Lock()
+	defer workerLock.Unlock()
+	if worker != nil {
+		select {
+		case worker.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run polls the queue until the worker is stopped
+func (w *Worker) run() {
+	defer close(w.done)
+
+	for {
+		w.deliverDue()
+
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-time.After(w.config.PollInterval):
+		}
+	}
+}
+
+// deliverDue attempts every delivery that is due
+func (w *Worker) deliverDue() {
+	due, err := deliveries.ListDue(context.Background(), time.Now(), batchSize)
+	if err != nil {
+		log.Printf("[Warning] failed to read the webhook queue: %v", err)
+		return
+	}
+
+	for i := range due {
+		// Leave the rest of the batch queued when stopping
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		w.deliver(&due[i])
+	}
+}
+
+// deliver makes a single attempt and schedules a retry or dead-letters the delivery when it fails
+func (w *Worker) deliver(delivery *deliveries.Delivery) {
+	attempt := w.attempt(delivery)
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.UpdatedAt = attempt.At
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = deliveries.StatusDelivered
+		log.Printf("Delivered %s webhook for transaction %s", delivery.Event, delivery.TransactionID.Hex())
+	case len(delivery.Attempts) >= w.config.MaxAttempts:
+		delivery.Status = deliveries.StatusDead
+		log.Printf("[Warning] giving up on %s webhook for transaction %s after %d attempts: %s", delivery.Event, delivery.TransactionID.Hex(), len(delivery.Attempts), attempt.Error)
+	default:
+		delivery.NextAttemptAt = attempt.At.Add(w.config.Backoff(len(delivery.Attempts)))
+		log.Printf("[Warning] %s webhook for transaction %s failed, retrying at %s: %s", delivery.Event, delivery.TransactionID.Hex(), delivery.NextAttemptAt.Format(time.RFC3339), attempt.Error)
+	}
+
+	if err := deliveries.Update(context.Background(), delivery); err != nil {
+		log.Printf("[Warning] failed to update webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// attempt posts the payload of the delivery to its webhook once
+func (w *Worker) attempt(delivery *deliveries.Delivery) deliveries.Attempt {
+	attempt := deliveries.Attempt{At: time.Now(), URL: delivery.URL}
+
+	// Create a new request with the desired method, URL, and body
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	// Look up the webhook settings of the merchant as they are now
+	merchant, err := deliveryMerchant(context.Background(), delivery)
+	if err != nil {
+		attempt.Error = fmt.Sprintf("unable to find the merchant of the webhook: %v", err)
+		return attempt
+	}
+	secret := w.signingSecret(merchant)
+
+	// Set the request headers
+	req.Header.Set("Content-Type", delivery.PayloadType())
+	if delivery.Key != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", delivery.Key))
+	}
+	if secret != "" {
+		req.Header.Set(delivery.SignatureHeaderName(), signature.Sign(secret, attempt.At, delivery.Payload))
+	}
+
+	// Record the request as it is sent, without the webhook key
+	attempt.RequestBody = delivery.Payload
+	attempt.RequestHeaders = make(map[string]string, len(req.Header))
+	for name := range req.Header {
+		attempt.RequestHeaders[name] = req.Header.Get(name)
+	}
+	if delivery.Key != "" {
+		attempt.RequestHeaders["Authorization"] = "Bearer [redacted]"
+	}
+
+	// Send the request, only skipping certificate verification for merchants that opted in
+	client := w.client
+	if merchant != nil && merchant.WebhookInsecure {
+		client = w.insecureClient
+	}
+	response, err := client.Do(req)
+	attempt.Duration = time.Since(attempt.At)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+
+	// Keep the start of the response for inspection and drain the rest
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxResponseBody))
+	attempt.ResponseBody = string(body)
+	io.Copy(io.Discard, response.Body)
+
+	// Only 2xx responses acknowledge the delivery
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("webhook responded with status %d", response.StatusCode)
+	}
+	return attempt
+}
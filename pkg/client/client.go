@@ -0,0 +1,234 @@
+// Package client is the Go SDK for the native API of the dev payment gate.
+//
+// A Client creates, fetches, lists, cancels, captures and refunds transactions with typed requests
+// and responses. Failed requests return an *Error that can be matched with errors.Is against the
+// sentinel errors of this package. Requests that fail on the network, are rate limited or hit a
+// server error are retried with backoff, and every POST carries an Idempotency-Key that is kept
+// across retries so a payment or refund is never created twice.
+//
+// Webhook notifications are verified and decoded into an Event by ParseWebhook or WebhookHandler.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is the number of times a failed request is retried by default
+	DefaultMaxRetries = 2
+
+	// DefaultBackoff is the delay before the first retry, it doubles for every following retry
+	DefaultBackoff = 500 * time.Millisecond
+
+	// maxBackoff caps the delay between retries, including delays asked for with Retry-After
+	maxBackoff = 30 * time.Second
+
+	// maxErrorBody limits how much of a response that is not an error envelope is kept in an Error
+	maxErrorBody = 1 << 10
+)
+
+// Client calls the API of a payment gate on behalf of a single merchant
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option changes the behaviour of a Client
+type Option func(*Client)
+
+// WithHTTPClient sends the requests with the given HTTP client instead of http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how often a failed request is retried and the delay before the first retry.
+// Zero retries disables retrying.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the gate at baseURL, such as "https://pay.example.com", authenticating
+// with the API key of a merchant
+func New(baseURL, apiKey string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// NewIdempotencyKey returns a random key to send in the Idempotency-Key header
+func NewIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("client: could not generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// idempotencyKeyContext is the context key of an idempotency key chosen by the caller
+type idempotencyKeyContext struct{}
+
+// WithIdempotencyKey returns a context that makes the POST request it is passed to use the given
+// Idempotency-Key instead of a random one, so the same operation can be retried safely even
+// across restarts of the caller
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContext{}, key)
+}
+
+// do sends a request to the API and decodes the JSON response into out. The request is retried
+// when that is safe, POST requests keep the same Idempotency-Key for every attempt.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	uri := c.baseURL + path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+
+	// POST requests are not idempotent by themselves, the key makes retrying them safe
+	var idempotencyKey string
+	if method == http.MethodPost {
+		idempotencyKey, _ = ctx.Value(idempotencyKeyContext{}).(string)
+		if idempotencyKey == "" {
+			var err error
+			if idempotencyKey, err = NewIdempotencyKey(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, uri, body, idempotencyKey, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(ctx, err) {
+			return err
+		}
+
+		// Wait before the next attempt, unless the caller gives up first
+		timer := time.NewTimer(c.delay(attempt, retryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends a request once. It returns the delay the server asked for with Retry-After.
+func (c *Client) attempt(ctx context.Context, method, uri string, body []byte, idempotencyKey string, out interface{}) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return parseRetryAfter(resp.Header.Get("Retry-After")), newError(resp)
+	}
+	if out == nil {
+		return 0, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("client: decoding response of %s %s: %w", method, req.URL.Path, err)
+	}
+	return 0, nil
+}
+
+// retryable reports whether a request that failed with err may be sent again
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// Network errors, the request may not have reached the gate
+		return true
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return true
+	case apiErr.StatusCode >= http.StatusInternalServerError && apiErr.StatusCode != http.StatusNotImplemented:
+		return true
+	case apiErr.Code == CodeIdempotencyKeyInUse:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait before retrying, doubling with every attempt with some jitter so
+// clients that failed together do not retry together
+func (c *Client) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, maxBackoff)
+	}
+	delay := float64(c.backoff) * math.Pow(2, float64(attempt))
+	delay = delay/2 + mathrand.Float64()*delay/2
+	return min(time.Duration(delay), maxBackoff)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes returned by the gate in the error envelope
+const (
+	CodeUnauthorized         = "unauthorized"
+	CodeNotFound             = "not_found"
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidJSON          = "invalid_json"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeValidationFailed     = "validation_failed"
+	CodeIllegalTransition    = "illegal_transition"
+	CodeNotRefundable        = "not_refundable"
+	CodeKeyInactive          = "key_inactive"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	CodeInternal             = "internal_error"
+)
+
+var (
+	// ErrUnauthorized matches errors for a missing, unknown, expired or revoked API key
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrNotFound matches errors for transactions and webhooks that do not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrValidation matches errors for rejected input, the Details of the Error list every field
+	ErrValidation = errors.New("validation failed")
+
+	// ErrConflict matches errors for operations that are not possible in the current status of a
+	// transaction, such as capturing a transaction that is not authorized
+	ErrConflict = errors.New("conflict")
+
+	// ErrIdempotencyKeyReused matches errors for an Idempotency-Key sent before with another request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+
+	// ErrServer matches errors of the gate itself
+	ErrServer = errors.New("server error")
+)
+
+// FieldError describes why a single field of the input was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is returned for every response of the gate with an error status
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int `json:"-"`
+
+	// Code is the machine readable error code, one of the Code constants
+	Code string `json:"code"`
+
+	// Message describes the error for humans
+	Message string `json:"message"`
+
+	// Details lists the rejected fields of validation errors
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// Error describes the error with its status, code and rejected fields
+func (e *Error) Error() string {
+	message := fmt.Sprintf("payment gate: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	for _, detail := range e.Details {
+		message += fmt.Sprintf("; %s: %s", detail.Field, detail.Message)
+	}
+	return message
+}
+
+// Is matches the error against the sentinel errors of this package
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrValidation:
+		return e.Code == CodeValidationFailed
+	case ErrConflict:
+		return e.Code == CodeIllegalTransition || e.Code == CodeNotRefundable
+	case ErrIdempotencyKeyReused:
+		return e.Code == CodeIdempotencyKeyReused
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// newError reads the error envelope of a response, responses of proxies in front of the gate that
+// are not an envelope are kept as the message
+func newError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	var envelope struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil {
+		envelope.Error.StatusCode = resp.StatusCode
+		return envelope.Error
+	}
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"dev-payment-gate/utils/money"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status describes where a transaction is in its lifecycle
+type Status string
+
+const (
+	StatusOpen       Status = "open"
+	StatusPending    Status = "pending"
+	StatusAuthorized Status = "authorized"
+	StatusPaid       Status = "paid"
+	StatusFailed     Status = "failed"
+	StatusCanceled   Status = "canceled"
+	StatusExpired    Status = "expired"
+)
+
+// CaptureMethod describes whether a successful payment is captured right away or only authorized
+type CaptureMethod string
+
+const (
+	// CaptureAutomatic payments are paid as soon as the payment succeeds
+	CaptureAutomatic CaptureMethod = "automatic"
+
+	// CaptureManual payments are authorized first and paid when they are captured
+	CaptureManual CaptureMethod = "manual"
+)
+
+// RefundStatus describes where a refund is in its lifecycle
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// StatusChange records a single transition in the history of a transaction. Forced changes were
+// made by an admin and did not have to follow the lifecycle.
+type StatusChange struct {
+	From   Status    `json:"from,omitempty"`
+	To     Status    `json:"to"`
+	At     time.Time `json:"at"`
+	Forced bool      `json:"forced,omitempty"`
+}
+
+// Delivery is the state of a webhook notification about a transaction
+type Delivery struct {
+	ID             string     `json:"id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// Refund is a refund of part or all of a paid transaction
+type Refund struct {
+	ID            string       `json:"id"`
+	TransactionID string       `json:"transaction_id"`
+	Amount        money.Money  `json:"amount"`
+	Reason        string       `json:"reason,omitempty"`
+	Status        RefundStatus `json:"status"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// Transaction is a payment as returned by the gate
+type Transaction struct {
+	ID             string         `json:"id"`
+	Amount         money.Money    `json:"amount"`
+	AmountCaptured *money.Money   `json:"amount_captured,omitempty"`
+	AmountRefunded money.Money    `json:"amount_refunded"`
+	CaptureMethod  CaptureMethod  `json:"capture_method"`
+	Status         Status         `json:"status"`
+	Reference      string         `json:"reference,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	ExpiresAt      *time.Time     `json:"expires_at,omitempty"`
+	History        []StatusChange `json:"history"`
+	Refunds        []Refund       `json:"refunds"`
+	CheckoutURL    string         `json:"checkout_url"`
+	RedirectURL    string         `json:"redirect_url"`
+	WebhookURL     string         `json:"webhook_url"`
+	Webhooks       []Delivery     `json:"webhooks"`
+}
+
+// TransactionList is a page of transactions, newest first
+type TransactionList struct {
+	Data       []Transaction `json:"data"`
+	HasMore    bool          `json:"has_more"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// CreatedTransaction is the response to creating a transaction
+type CreatedTransaction struct {
+	// ID of the new transaction
+	ID string `json:"id"`
+
+	// URL of the checkout page to send the customer to
+	URL string `json:"url"`
+}
+
+// CreateParams describes a transaction to create
+type CreateParams struct {
+	Amount        money.Money   `json:"amount"`
+	WebhookURL    string        `json:"webhook_url"`
+	WebhookKey    string        `json:"webhook_key,omitempty"`
+	RedirectURL   string        `json:"redirect_url"`
+	Reference     string        `json:"reference,omitempty"`
+	CaptureMethod CaptureMethod `json:"capture_method,omitempty"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+}
+
+// ListParams filters and pages the transactions listed by ListTransactions. Zero values do not filter.
+type ListParams struct {
+	Statuses    []Status
+	Currency    string
+	AmountMin   *money.Money
+	AmountMax   *money.Money
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Reference   string
+	Cursor      string
+	Limit       int
+}
+
+// query converts the parameters into the query string of the list endpoint
+func (p ListParams) query() url.Values {
+	query := url.Values{}
+	if len(p.Statuses) > 0 {
+		statuses := make([]string, len(p.Statuses))
+		for i, status := range p.Statuses {
+			statuses[i] = string(status)
+		}
+		query.Set("status", strings.Join(statuses, ","))
+	}
+	if p.Currency != "" {
+		query.Set("currency", p.Currency)
+	}
+	if p.AmountMin != nil {
+		query.Set("currency", p.AmountMin.Currency)
+		query.Set("amount_min", p.AmountMin.String())
+	}
+	if p.AmountMax != nil {
+		query.Set("currency", p.AmountMax.Currency)
+		query.Set("amount_max", p.AmountMax.String())
+	}
+	if !p.CreatedFrom.IsZero() {
+		query.Set("created_from", p.CreatedFrom.Format(time.RFC3339))
+	}
+	if !p.CreatedTo.IsZero() {
+		query.Set("created_to", p.CreatedTo.Format(time.RFC3339))
+	}
+	if p.Reference != "" {
+		query.Set("reference", p.Reference)
+	}
+	if p.Cursor != "" {
+		query.Set("cursor", p.Cursor)
+	}
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return query
+}
+
+// captureParams is the body of a capture request
+type captureParams struct {
+	Amount *money.Money `json:"amount,omitempty"`
+}
+
+// RefundParams describes a refund to create
+type RefundParams struct {
+	// Amount to refund, nil refunds everything that was not refunded yet
+	Amount *money.Money `json:"amount,omitempty"`
+
+	// Reason is shown in the refund and its webhook notification
+	Reason string `json:"reason,omitempty"`
+}
+
+// transactionPath returns the path of a transaction or of an action on it
+func transactionPath(id string, action ...string) string {
+	return strings.Join(append([]string{"/v1/transactions", url.PathEscape(id)}, action...), "/")
+}
+
+// CreateTransaction creates a transaction and returns its ID and the checkout URL
+func (c *Client) CreateTransaction(ctx context.Context, params CreateParams) (*CreatedTransaction, error) {
+	var created CreatedTransaction
+	if err := c.do(ctx, http.MethodPost, "/transaction", nil, params, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetTransaction returns the current state of a transaction
+func (c *Client) GetTransaction(ctx context.Context, id string) (*Transaction, error) {
+	var transaction Transaction
+	if err := c.do(ctx, http.MethodGet, transactionPath(id), nil, nil, &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// ListTransactions returns a page of transactions, pass the NextCursor of a page as the Cursor of
+// the parameters to get the next page
+func (c *Client) ListTransactions(ctx context.Context, params ListParams) (*TransactionList, error) {
+	var list TransactionList
+	if err := c.do(ctx, http.MethodGet, "/v1/transactions", params.query(), nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// CancelTransaction cancels an open, pending or authorized transaction
+func (c *Client) CancelTransaction(ctx context.Context, id string) (*Transaction, error) {
+	return c.transactionAction(ctx, id, "cancel", nil)
+}
+
+// CaptureTransaction pays an authorized transaction with manual capture, a nil amount captures the
+// full authorized amount
+func (c *Client) CaptureTransaction(ctx context.Context, id string, amount *money.Money) (*Transaction, error) {
+	return c.transactionAction(ctx, id, "capture", captureParams{Amount: amount})
+}
+
+// VoidTransaction releases an authorized transaction with manual capture without paying it
+func (c *Client) VoidTransaction(ctx context.Context, id string) (*Transaction, error) {
+	return c.transactionAction(ctx, id, "void", nil)
+}
+
+// RefundTransaction refunds part or all of a paid transaction. The refund starts out pending, its
+// outcome is sent to the webhook.
+func (c *Client) RefundTransaction(ctx context.Context, id string, params RefundParams) (*Refund, error) {
+	var refund Refund
+	if err := c.do(ctx, http.MethodPost, transactionPath(id, "refunds"), nil, params, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// transactionAction posts an action on a transaction and returns the changed transaction
+func (c *Client) transactionAction(ctx context.Context, id, action string, params interface{}) (*Transaction, error) {
+	var transaction Transaction
+	if err := c.do(ctx, http.MethodPost, transactionPath(id, action), nil, params, &transaction); err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
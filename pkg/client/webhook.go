@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"dev-payment-gate/pkg/signature"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxWebhookBody limits the size of the webhook bodies read by ParseWebhook
+const maxWebhookBody = 64 << 10
+
+// ErrInvalidWebhook is returned for webhook bodies that are not a notification of the gate
+var ErrInvalidWebhook = errors.New("invalid webhook payload")
+
+// EventRefund describes the refund a refund event is about
+type EventRefund struct {
+	ID     string       `json:"id"`
+	Amount money.Money  `json:"amount"`
+	Status RefundStatus `json:"status"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// Event is a webhook notification about a transaction of the native API. Retries and replays of a
+// notification keep the ID of the event, so it can be used to skip events that were handled before.
+type Event struct {
+	ID string `json:"id"`
+
+	// Type is "transaction.<status>" for status changes and "refund.<status>" for refunds
+	Type string `json:"event"`
+
+	// TransactionID and Status are the transaction and its status when the event happened
+	TransactionID string `json:"transaction_id"`
+	Status        Status `json:"status"`
+
+	// Refund is only set for refund events
+	Refund *EventRefund `json:"refund,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsRefund reports whether the event is about a refund instead of a status change
+func (e *Event) IsRefund() bool {
+	return strings.HasPrefix(e.Type, "refund.")
+}
+
+// ParseWebhook verifies the signature of a webhook request with the webhook secret of the merchant,
+// as returned by the admin API and the CLI when the merchant is created, and decodes it into an Event. An empty secret skips the verification, for gates running without one.
+// Errors of the verification are those of the signature package.
+func ParseWebhook(secret string, r *http.Request) (*Event, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxWebhookBody)
+
+	var payload []byte
+	var err error
+	if secret != "" {
+		payload, err = signature.VerifyRequest(secret, r)
+	} else {
+		payload, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseEvent(payload)
+}
+
+// ParseEvent decodes the body of a webhook notification that was verified before
+func ParseEvent(payload []byte) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if event.ID == "" || event.Type == "" || event.TransactionID == "" {
+		return nil, fmt.Errorf("%w: missing id, event or transaction_id", ErrInvalidWebhook)
+	}
+	return &event, nil
+}
+
+// WebhookHandler returns a handler for the webhook URL of transactions that verifies and decodes
+// every notification and passes it to handle. Notifications with an invalid signature or body are
+// rejected. When handle returns an error the gate is answered with a server error so it retries
+// the notification later.
+func WebhookHandler(secret string, handle func(ctx context.Context, event *Event) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		event, err := ParseWebhook(secret, r)
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			http.Error(w, "Webhook body too large", http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, ErrInvalidWebhook):
+			http.Error(w, "Invalid webhook payload", http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "Invalid webhook signature", http.StatusUnauthorized)
+			return
+		}
+
+		if err := handle(r.Context(), event); err != nil {
+			log.Printf("[Warning] failed to handle webhook event %s: %v", event.ID, err)
+			http.Error(w, "Failed to handle webhook", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
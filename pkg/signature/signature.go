@@ -0,0 +1,138 @@
+// Package signature signs and verifies the webhook payloads sent by the dev payment gate.
+//
+// Every webhook carries a header in the form "t=<unix timestamp>,v1=<hex signature>", where the
+// signature is the HMAC-SHA256 of "<timestamp>.<raw JSON body>" keyed with the merchant secret.
+// Backends import this package to verify the notifications they receive.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header is the HTTP header carrying the signature of a webhook
+const Header = "X-Gate-Signature"
+
+// DefaultTolerance is the maximum age of a signature accepted by VerifyRequest
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	// ErrMissingHeader is returned when a request does not carry a signature header
+	ErrMissingHeader = errors.New("missing signature header")
+
+	// ErrInvalidHeader is returned when the signature header can not be parsed
+	ErrInvalidHeader = errors.New("invalid signature header")
+
+	// ErrNoValidSignature is returned when none of the signatures in the header match the payload
+	ErrNoValidSignature = errors.New("no valid signature found")
+
+	// ErrTooOld is returned when the signature timestamp is outside of the tolerance
+	ErrTooOld = errors.New("signature timestamp outside of tolerance")
+)
+
+// Compute returns the hex encoded HMAC-SHA256 of the timestamp and payload keyed with the secret
+func Compute(secret string, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the signature header value for the payload at the given time
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), Compute(secret, timestamp, payload))
+}
+
+// Verify checks a signature header against the payload. Signatures older than tolerance are rejected,
+// a tolerance of zero disables the age check.
+func Verify(secret, header string, payload []byte, tolerance time.Duration) error {
+	return verifyAt(secret, header, payload, tolerance, time.Now())
+}
+
+// VerifyRequest checks the signature of an incoming webhook request using DefaultTolerance and returns
+// its body. The body of the request is restored so it can be decoded afterwards.
+func VerifyRequest(secret string, r *http.Request) ([]byte, error) {
+	// Read the raw body, the signature is computed over the exact bytes that were sent
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(payload))
+
+	header := r.Header.Get(Header)
+	if header == "" {
+		return nil, ErrMissingHeader
+	}
+	if err := Verify(secret, header, payload, DefaultTolerance); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// verifyAt checks a signature header as if the current time was now
+func verifyAt(secret, header string, payload []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, signatures, err := parse(header)
+	if err != nil {
+		return err
+	}
+
+	// Reject signatures that are too old (or too far in the future) to prevent replays
+	if tolerance > 0 {
+		age := now.Sub(timestamp)
+		if age > tolerance || age < -tolerance {
+			return ErrTooOld
+		}
+	}
+
+	// Accept the payload when any of the signatures match, which allows rotating secrets
+	expected, _ := hex.DecodeString(Compute(secret, timestamp, payload))
+	for _, signature := range signatures {
+		if hmac.Equal(expected, signature) {
+			return nil
+		}
+	}
+	return ErrNoValidSignature
+}
+
+// parse splits a signature header into its timestamp and v1 signatures
+func parse(header string) (time.Time, [][]byte, error) {
+	var timestamp time.Time
+	var signatures [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return time.Time{}, nil, ErrInvalidHeader
+		}
+
+		switch key {
+		case "t":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return time.Time{}, nil, ErrInvalidHeader
+			}
+			timestamp = time.Unix(seconds, 0)
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return time.Time{}, nil, ErrInvalidHeader
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if timestamp.IsZero() || len(signatures) == 0 {
+		return time.Time{}, nil, ErrInvalidHeader
+	}
+	return timestamp, signatures, nil
+}
@@ -0,0 +1,227 @@
+package client_test
+
+import (
+	"context"
+	"dev-payment-gate/pkg/client"
+	"dev-payment-gate/pkg/signature"
+	"dev-payment-gate/utils/money"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first requests with the given status and then answers with a transaction. It
+// records the Idempotency-Key of every request.
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	keys     []string
+}
+
+// ServeHTTP answers a request of the test client
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, r.Header.Get("Idempotency-Key"))
+	w.Header().Set("Content-Type", "application/json")
+	if len(s.keys) <= s.failures {
+		w.WriteHeader(s.status)
+		fmt.Fprint(w, `{"error": {"code": "internal_error", "message": "Failed to insert transaction"}}`)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, `{"id": "6522c1b5e4b0a1b2c3d4e5f6", "url": "http://gate.test/transaction/6522c1b5e4b0a1b2c3d4e5f6"}`)
+}
+
+// newClient creates a client for the test server that retries without waiting
+func newClient(server *httptest.Server) *client.Client {
+	return client.New(server.URL, "sk_test_client", client.WithRetries(2, time.Millisecond))
+}
+
+// createParams describes a valid transaction
+var createParams = client.CreateParams{Amount: money.New(1000, "EUR"), WebhookURL: "https://shop.test/webhook", RedirectURL: "https://shop.test"}
+
+// TestRetryWithIdempotencyKey verifies that server errors are retried with the same Idempotency-Key
+func TestRetryWithIdempotencyKey(t *testing.T) {
+	flaky := &flakyServer{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	created, err := newClient(server).CreateTransaction(context.Background(), createParams)
+	if err != nil {
+		t.Fatalf("Expected the request to succeed after retrying, got: %v", err)
+	}
+	if created.ID != "6522c1b5e4b0a1b2c3d4e5f6" || !strings.HasSuffix(created.URL, created.ID) {
+		t.Errorf("Unexpected created transaction: %+v", created)
+	}
+
+	if len(flaky.keys) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(flaky.keys))
+	}
+	for _, key := range flaky.keys {
+		if key == "" || key != flaky.keys[0] {
+			t.Errorf("Expected every attempt to use the same Idempotency-Key, got %v", flaky.keys)
+			break
+		}
+	}
+}
+
+// TestRetryGivesUp verifies that the last error is returned once the retries are used up
+func TestRetryGivesUp(t *testing.T) {
+	flaky := &flakyServer{failures: 10, status: http.StatusInternalServerError}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	_, err := newClient(server).CreateTransaction(context.Background(), createParams)
+	if !errors.Is(err, client.ErrServer) {
+		t.Errorf("Expected %v, got: %v", client.ErrServer, err)
+	}
+	if len(flaky.keys) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", len(flaky.keys))
+	}
+}
+
+// TestNoRetryOnClientError verifies that rejected requests are not retried
+func TestNoRetryOnClientError(t *testing.T) {
+	flaky := &flakyServer{failures: 10, status: http.StatusBadRequest}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	if _, err := newClient(server).CreateTransaction(context.Background(), createParams); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if len(flaky.keys) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(flaky.keys))
+	}
+}
+
+// TestCallerIdempotencyKey verifies that an Idempotency-Key chosen by the caller is sent
+func TestCallerIdempotencyKey(t *testing.T) {
+	flaky := &flakyServer{}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	ctx := client.WithIdempotencyKey(context.Background(), "order-42")
+	if _, err := newClient(server).CreateTransaction(ctx, createParams); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(flaky.keys) != 1 || flaky.keys[0] != "order-42" {
+		t.Errorf("Expected the Idempotency-Key order-42, got %v", flaky.keys)
+	}
+}
+
+// TestErrors verifies that error responses are decoded into an *Error matching the sentinel errors
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+		code     string
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error": {"code": "unauthorized", "message": "Unknown API key"}}`, client.ErrUnauthorized, client.CodeUnauthorized},
+		{"not found", http.StatusNotFound, `{"error": {"code": "not_found", "message": "Transaction not found"}}`, client.ErrNotFound, client.CodeNotFound},
+		{"validation", http.StatusBadRequest, `{"error": {"code": "validation_failed", "message": "Invalid refund input", "details": [{"field": "amount.value", "code": "out_of_range", "message": "too much"}]}}`, client.ErrValidation, client.CodeValidationFailed},
+		{"conflict", http.StatusConflict, `{"error": {"code": "illegal_transition", "message": "Only authorized transactions can be captured"}}`, client.ErrConflict, client.CodeIllegalTransition},
+		{"proxy", http.StatusBadGateway, `Bad Gateway`, client.ErrServer, ""},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, test.body)
+		}))
+
+		_, err := client.New(server.URL, "sk_test_client", client.WithRetries(0, 0)).GetTransaction(context.Background(), "6522c1b5e4b0a1b2c3d4e5f6")
+		server.Close()
+
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("[%s] Expected an *Error, got: %v", test.name, err)
+			continue
+		}
+		if !errors.Is(err, test.sentinel) {
+			t.Errorf("[%s] Expected the error to match %v, got: %v", test.name, test.sentinel, err)
+		}
+		if apiErr.StatusCode != test.status || apiErr.Code != test.code {
+			t.Errorf("[%s] Expected status %d and code %q, got %d and %q", test.name, test.status, test.code, apiErr.StatusCode, apiErr.Code)
+		}
+	}
+}
+
+// TestRetryAfterContext verifies that waiting for a retry stops when the context is canceled
+func TestRetryAfterContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := newClient(server).GetTransaction(ctx, "6522c1b5e4b0a1b2c3d4e5f6")
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected to stop waiting when the context ended, waited %v", elapsed)
+	}
+}
+
+// serveWebhook posts a webhook body with a signature header to the handler
+func serveWebhook(handler http.Handler, body, header string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		request.Header.Set(signature.Header, header)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestWebhookHandler verifies that notifications are verified, decoded and acknowledged
+func TestWebhookHandler(t *testing.T) {
+	const body = `{"id":"6522c1b5e4b0a1b2c3d4e5f7","event":"refund.succeeded","transaction_id":"6522c1b5e4b0a1b2c3d4e5f6","status":"paid","refund":{"id":"6522c1b5e4b0a1b2c3d4e5f8","amount":{"currency":"EUR","value":"2.50"},"status":"succeeded"},"created_at":"2023-10-08T12:00:00Z"}`
+
+	var received *client.Event
+	var failure error
+	handler := client.WebhookHandler("secret", func(ctx context.Context, event *client.Event) error {
+		received = event
+		return failure
+	})
+
+	// A valid notification is decoded and acknowledged
+	if recorder := serveWebhook(handler, body, signature.Sign("secret", time.Now(), []byte(body))); recorder.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, recorder.Code)
+	}
+	if received == nil || !received.IsRefund() || received.Status != client.StatusPaid || received.Refund.Amount != money.New(250, "EUR") {
+		t.Errorf("Unexpected event: %+v", received)
+	}
+
+	// Failing to handle it makes the gate retry
+	failure = errors.New("database down")
+	if recorder := serveWebhook(handler, body, signature.Sign("secret", time.Now(), []byte(body))); recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, recorder.Code)
+	}
+
+	// Forged and malformed notifications are rejected
+	if recorder := serveWebhook(handler, body, signature.Sign("other", time.Now(), []byte(body))); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	if recorder := serveWebhook(handler, body, ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	if recorder := serveWebhook(handler, `{}`, signature.Sign("secret", time.Now(), []byte(`{}`))); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
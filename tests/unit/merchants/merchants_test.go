@@ -0,0 +1,333 @@
+package merchants_test
+
+import (
+	"bytes"
+	"context"
+	"dev-payment-gate/internal/cli"
+	"dev-payment-gate/utils/database"
+	"dev-payment-gate/utils/model/merchants"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore verifies the basic operations of the in-memory store
+func TestMemoryStore(t *testing.T) {
+	testStore(t, merchants.NewMemoryStore())
+}
+
+// TestBoltStore verifies the basic operations of the embedded file-backed store
+func TestBoltStore(t *testing.T) {
+	if err := database.OpenBolt(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("Could not open embedded database: %v", err)
+	}
+	defer database.CloseBolt()
+
+	store, err := merchants.NewBoltStore()
+	if err != nil {
+		t.Fatalf("Could not create bolt store: %v", err)
+	}
+	testStore(t, store)
+}
+
+// testStore runs the operations every MerchantStore has to support
+func testStore(t *testing.T, store merchants.MerchantStore) {
+	ctx := context.TODO()
+
+	// Insert a merchant with a key and find it by the hash of that key
+	merchant := merchants.Create("shop")
+	key, apiKey, err := merchant.NewKey(merchants.ModeTest, time.Now())
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	id, err := store.Insert(ctx, &merchant)
+	if err != nil {
+		t.Fatalf("Could not insert merchant: %v", err)
+	}
+	found, err := store.GetByKeyHash(ctx, merchants.HashKey(key))
+	if err != nil {
+		t.Fatalf("Could not find merchant by key: %v", err)
+	}
+	if found.ID != *id || found.Name != "shop" || found.FindKey(apiKey.Hash) == nil {
+		t.Errorf("Stored merchant does not match the inserted one: %+v", found)
+	}
+
+	// Replacing the key removes the old one from the lookup
+	found.Keys = nil
+	newKey, _, err := found.NewKey(merchants.ModeLive, time.Now())
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	if err := store.Update(ctx, found); err != nil {
+		t.Fatalf("Could not update merchant: %v", err)
+	}
+	if _, err := store.GetByKeyHash(ctx, merchants.HashKey(key)); !errors.Is(err, merchants.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a removed key, got %v", err)
+	}
+	if _, err := store.GetByKeyHash(ctx, merchants.HashKey(newKey)); err != nil {
+		t.Errorf("Could not find merchant by the new key: %v", err)
+	}
+
+	// Writing a merchant that was read before the last update is a conflict
+	stale := *found
+	stale.Version--
+	if err := store.Update(ctx, &stale); !errors.Is(err, merchants.ErrConflict) {
+		t.Errorf("Expected ErrConflict for a stale merchant, got %v", err)
+	}
+
+	// Recording the use of a key only changes that key
+	usedAt := time.Now().Round(time.Millisecond).UTC()
+	if err := store.TouchKey(ctx, merchants.HashKey(newKey), usedAt); err != nil {
+		t.Fatalf("Could not record key use: %v", err)
+	}
+	touched, err := store.GetByID(ctx, *id)
+	if err != nil || touched.Keys[0].LastUsedAt == nil || !touched.Keys[0].LastUsedAt.Equal(usedAt) {
+		t.Errorf("Expected the key use to be recorded, got %+v (%v)", touched, err)
+	}
+
+	// Unknown merchants are reported as not found
+	if _, err := store.GetByID(ctx, merchants.Create("other").ID); !errors.Is(err, merchants.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// The merchant is listed
+	listed, err := store.List(ctx)
+	if err != nil || len(listed) != 1 {
+		t.Errorf("Expected one merchant, got %d (%v)", len(listed), err)
+	}
+}
+
+// TestKeys verifies the format of generated keys and that only their hash and hint are kept
+func TestKeys(t *testing.T) {
+	merchant := merchants.Create("shop")
+	for _, mode := range []merchants.Mode{merchants.ModeTest, merchants.ModeLive} {
+		key, apiKey, err := merchant.NewKey(mode, time.Now())
+		if err != nil {
+			t.Fatalf("Could not generate API key: %v", err)
+		}
+		if !strings.HasPrefix(key, mode.Prefix()) || merchants.ModeOf(key) != mode || apiKey.Mode != mode {
+			t.Errorf("Key %q does not match mode %s", key, mode)
+		}
+		if apiKey.Hash != merchants.HashKey(key) || strings.Contains(apiKey.Hint, key) {
+			t.Errorf("Stored key %+v leaks the key or has a wrong hash", apiKey)
+		}
+		if apiKey.Hint != mode.Prefix()+"..."+key[len(key)-4:] {
+			t.Errorf("Unexpected hint %q for key %q", apiKey.Hint, key)
+		}
+	}
+
+	// Unknown modes are rejected
+	if _, _, err := merchant.NewKey("staging", time.Now()); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+// TestRotate verifies the overlap of rotated keys and the transitions of their status
+func TestRotate(t *testing.T) {
+	now := time.Now()
+	merchant := merchants.Create("shop")
+	_, old, err := merchant.NewKey(merchants.ModeLive, now)
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	oldID := old.ID
+
+	// The new key has the same mode and the old key expires after the overlap
+	_, rotated, err := merchant.Rotate(oldID, time.Hour, now)
+	if err != nil {
+		t.Fatalf("Could not rotate key: %v", err)
+	}
+	old = merchant.FindKeyByID(oldID)
+	if rotated.Mode != merchants.ModeLive || old.ExpiresAt == nil || !old.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected rotation from %+v to %+v", old, rotated)
+	}
+	if old.Status(now) != merchants.KeyActive || old.Status(now.Add(time.Hour)) != merchants.KeyExpired {
+		t.Errorf("Expected the old key to be active during the overlap only")
+	}
+
+	// A later rotation never extends the lifetime of a key
+	if _, _, err := merchant.Rotate(oldID, 2*time.Hour, now); err != nil || !old.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected the expiry to stay, got %v (%v)", old.ExpiresAt, err)
+	}
+
+	// Inactive keys can not be rotated or expired
+	old = merchant.FindKeyByID(oldID)
+	if err := old.Revoke(now); err != nil || old.Status(now) != merchants.KeyRevoked {
+		t.Fatalf("Could not revoke key: %v", err)
+	}
+	if _, _, err := merchant.Rotate(oldID, 0, now); !errors.Is(err, merchants.ErrKeyInactive) {
+		t.Errorf("Expected ErrKeyInactive, got %v", err)
+	}
+	if err := old.Expire(now, now); !errors.Is(err, merchants.ErrKeyInactive) {
+		t.Errorf("Expected ErrKeyInactive, got %v", err)
+	}
+}
+
+// TestAuthenticate verifies key lookups and bootstrapping through the selected store
+func TestAuthenticate(t *testing.T) {
+	merchants.SetStore(merchants.NewMemoryStore())
+	ctx := context.TODO()
+
+	// Bootstrapping is idempotent
+	first, err := merchants.Bootstrap(ctx, "default", "sk_test_bootstrap", "whsec_bootstrap")
+	if err != nil {
+		t.Fatalf("Could not bootstrap merchant: %v", err)
+	}
+	if first.WebhookSecret != "whsec_bootstrap" {
+		t.Errorf("Expected the configured webhook secret, got %q", first.WebhookSecret)
+	}
+	second, err := merchants.Bootstrap(ctx, "default", "sk_test_bootstrap", "")
+	if err != nil || second.ID != first.ID {
+		t.Fatalf("Expected the bootstrapped merchant to be reused, got %v (%v)", second, err)
+	}
+
+	// Known keys resolve to their merchant, unknown keys are rejected
+	merchant, apiKey, err := merchants.Authenticate(ctx, "sk_test_bootstrap")
+	if err != nil || merchant.ID != first.ID || apiKey.Mode != merchants.ModeTest {
+		t.Errorf("Unexpected authentication result %v, %v, %v", merchant, apiKey, err)
+	}
+	if _, _, err := merchants.Authenticate(ctx, "sk_test_unknown"); !errors.Is(err, merchants.ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+
+	// Expired and revoked keys are rejected
+	expired, _, err := merchants.AddKey(ctx, first.ID, merchants.ModeTest, nil)
+	if err != nil {
+		t.Fatalf("Could not add key: %v", err)
+	}
+	_, expiredKey, _ := merchants.Authenticate(ctx, expired)
+	if expiredKey.LastUsedAt == nil {
+		t.Error("Expected the use of the key to be recorded")
+	}
+	if _, err := merchants.ExpireKey(ctx, first.ID, expiredKey.ID, time.Now()); err != nil {
+		t.Fatalf("Could not expire key: %v", err)
+	}
+	if _, _, err := merchants.Authenticate(ctx, expired); !errors.Is(err, merchants.ErrKeyExpired) || !errors.Is(err, merchants.ErrInvalidKey) {
+		t.Errorf("Expected ErrKeyExpired, got %v", err)
+	}
+	if _, err := merchants.RevokeKey(ctx, first.ID, apiKey.ID); err != nil {
+		t.Fatalf("Could not revoke key: %v", err)
+	}
+	if _, _, err := merchants.Authenticate(ctx, "sk_test_bootstrap"); !errors.Is(err, merchants.ErrKeyRevoked) {
+		t.Errorf("Expected ErrKeyRevoked, got %v", err)
+	}
+
+	// Bootstrapping does not bring a revoked key back
+	if _, err := merchants.Bootstrap(ctx, "default", "sk_test_bootstrap", ""); err != nil {
+		t.Fatalf("Could not bootstrap merchant: %v", err)
+	}
+	if _, _, err := merchants.Authenticate(ctx, "sk_test_bootstrap"); !errors.Is(err, merchants.ErrKeyRevoked) {
+		t.Errorf("Expected the bootstrapped key to stay revoked, got %v", err)
+	}
+
+	// Added keys authenticate as the same merchant
+	key, _, err := merchants.AddKey(ctx, first.ID, merchants.ModeLive, nil)
+	if err != nil {
+		t.Fatalf("Could not add key: %v", err)
+	}
+	if merchant, _, err := merchants.Authenticate(ctx, key); err != nil || merchant.ID != first.ID {
+		t.Errorf("Expected the added key to authenticate, got %v (%v)", merchant, err)
+	}
+}
+
+// TestConcurrentModify verifies that concurrent changes to a merchant do not overwrite each other
+func TestConcurrentModify(t *testing.T) {
+	merchants.SetStore(merchants.NewMemoryStore())
+	ctx := context.TODO()
+
+	merchant := merchants.Create("shop")
+	key, apiKey, err := merchant.NewKey(merchants.ModeTest, time.Now())
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	if _, err := merchants.Insert(ctx, &merchant); err != nil {
+		t.Fatalf("Could not insert merchant: %v", err)
+	}
+
+	// Revoke the key while other keys are added, every change that succeeded has to be kept
+	var wg sync.WaitGroup
+	var added atomic.Int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := merchants.AddKey(ctx, merchant.ID, merchants.ModeTest, nil); err == nil {
+				added.Add(1)
+			} else if !errors.Is(err, merchants.ErrConflict) {
+				t.Errorf("Could not add key: %v", err)
+			}
+		}()
+	}
+	if _, err := merchants.RevokeKey(ctx, merchant.ID, apiKey.ID); err != nil {
+		t.Fatalf("Could not revoke key: %v", err)
+	}
+	wg.Wait()
+
+	stored, err := merchants.GetByID(ctx, merchant.ID)
+	if err != nil {
+		t.Fatalf("Could not get merchant: %v", err)
+	}
+	if len(stored.Keys) != int(added.Load())+1 {
+		t.Errorf("Expected %d keys, got %d", added.Load()+1, len(stored.Keys))
+	}
+	if _, _, err := merchants.Authenticate(ctx, key); !errors.Is(err, merchants.ErrKeyRevoked) {
+		t.Errorf("Expected the key to stay revoked, got %v", err)
+	}
+}
+
+// TestCLI verifies the merchant commands
+func TestCLI(t *testing.T) {
+	merchants.SetStore(merchants.NewMemoryStore())
+	ctx := context.TODO()
+
+	// Create a merchant and use the printed key
+	var out bytes.Buffer
+	if err := cli.Run(ctx, []string{"merchant", "create", "-name", "shop", "-mode", "live"}, &out); err != nil {
+		t.Fatalf("Could not create merchant: %v", err)
+	}
+	key := regexp.MustCompile(`sk_live_[0-9a-f]+`).FindString(out.String())
+	merchant, _, err := merchants.Authenticate(ctx, key)
+	if err != nil {
+		t.Fatalf("Printed key %q does not authenticate: %v", key, err)
+	}
+	if merchant.WebhookSecret == "" || !strings.Contains(out.String(), merchant.WebhookSecret) {
+		t.Errorf("Expected the webhook secret to be printed, got:\n%s", out.String())
+	}
+
+	// Add a key and list the merchant with both key hints
+	out.Reset()
+	if err := cli.Run(ctx, []string{"merchant", "key", "-id", merchant.ID.Hex()}, &out); err != nil {
+		t.Fatalf("Could not add key: %v", err)
+	}
+	out.Reset()
+	if err := cli.Run(ctx, []string{"merchant", "list"}, &out); err != nil {
+		t.Fatalf("Could not list merchants: %v", err)
+	}
+	if !strings.Contains(out.String(), merchant.ID.Hex()) || strings.Count(out.String(), "...") != 2 || strings.Contains(out.String(), key) {
+		t.Errorf("Unexpected listing:\n%s", out.String())
+	}
+
+	// Invalid command lines report the usage
+	for _, args := range [][]string{nil, {"merchant"}, {"merchant", "create"}, {"merchant", "key", "-id", "nope"}} {
+		if err := cli.Run(ctx, args, &out); !errors.Is(err, cli.ErrUsage) {
+			t.Errorf("[%v] Expected ErrUsage, got %v", args, err)
+		}
+	}
+}
+
+// TestCLIStore verifies that the commands refuse to run on the in-memory store
+func TestCLIStore(t *testing.T) {
+	if err := cli.CheckStore("memory"); !errors.Is(err, cli.ErrMemoryStore) {
+		t.Errorf("Expected ErrMemoryStore, got %v", err)
+	}
+	for _, backend := range []string{"", "mongo", "bolt"} {
+		if err := cli.CheckStore(backend); err != nil {
+			t.Errorf("[%s] Expected the store to be accepted, got %v", backend, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package money_test
+
+import (
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestParse verifies the conversion of decimal strings into minor units
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		minor    int64
+		err      error
+	}{
+		{"4.95", "EUR", 495, nil},
+		{"4.9", "EUR", 490, nil},
+		{"4.950", "EUR", 495, nil},
+		{"10", "USD", 1000, nil},
+		{"-1.05", "EUR", -105, nil},
+		{"1000", "JPY", 1000, nil},
+		{"1.234", "KWD", 1234, nil},
+		{"4.955", "EUR", 0, money.ErrPrecision},
+		{"1.5", "JPY", 0, money.ErrPrecision},
+		{"4,95", "EUR", 0, money.ErrInvalidAmount},
+		{"4.", "EUR", 0, money.ErrInvalidAmount},
+		{".5", "EUR", 0, money.ErrInvalidAmount},
+		{"1e3", "EUR", 0, money.ErrInvalidAmount},
+		{"9999999999999999", "EUR", 0, money.ErrInvalidAmount},
+		{"4.95", "XXX", 0, money.ErrUnknownCurrency},
+		{"4.95", "eur", 0, money.ErrUnknownCurrency},
+	}
+
+	for _, test := range tests {
+		amount, err := money.Parse(test.value, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("[%s %s] Expected error %v, got: %v", test.value, test.currency, test.err, err)
+			continue
+		}
+		if err == nil && amount.Value != test.minor {
+			t.Errorf("[%s %s] Expected %d minor units, got %d", test.value, test.currency, test.minor, amount.Value)
+		}
+	}
+}
+
+// TestFormat verifies the currency aware formatting
+func TestFormat(t *testing.T) {
+	tests := map[money.Money]string{
+		money.New(495, "EUR"):  "€4.95",
+		money.New(5, "EUR"):    "€0.05",
+		money.New(-105, "USD"): "$-1.05",
+		money.New(1000, "JPY"): "¥1000",
+		money.New(1234, "KWD"): "KD 1.234",
+	}
+
+	for amount, expected := range tests {
+		if formatted := amount.Format(); formatted != expected {
+			t.Errorf("Expected %s, got %s", expected, formatted)
+		}
+	}
+}
+
+// TestJSON verifies that amounts survive a JSON round trip exactly
+func TestJSON(t *testing.T) {
+	// Objects carry their currency and may use a string or number value
+	for _, input := range []string{`{"currency": "EUR", "value": "4.95"}`, `{"currency": "EUR", "value": 4.95}`, `4.95`} {
+		var amount money.Money
+		if err := json.Unmarshal([]byte(input), &amount); err != nil {
+			t.Errorf("[%s] Could not decode amount: %v", input, err)
+			continue
+		}
+		if amount != money.New(495, "EUR") {
+			t.Errorf("[%s] Expected 495 EUR, got %d %s", input, amount.Value, amount.Currency)
+		}
+	}
+
+	// Amounts are encoded as decimal strings
+	data, err := json.Marshal(money.New(495, "EUR"))
+	if err != nil {
+		t.Fatalf("Could not encode amount: %v", err)
+	}
+	if string(data) != `{"currency":"EUR","value":"4.95"}` {
+		t.Errorf("Unexpected encoding: %s", data)
+	}
+
+	// Invalid amounts are rejected while decoding
+	var amount money.Money
+	if err := json.Unmarshal([]byte(`{"currency": "EUR", "value": "4.955"}`), &amount); !errors.Is(err, money.ErrPrecision) {
+		t.Errorf("Expected ErrPrecision, got: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package openapi_test
+
+import (
+	"dev-payment-gate/api/openapi"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// load parses the embedded OpenAPI document and fails the test when it cannot
+func load(t *testing.T) *openapi.Document {
+	t.Helper()
+
+	document, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("Could not load the OpenAPI document: %v", err)
+	}
+	return document
+}
+
+// TestReferences verifies that every $ref in the document points to something
+func TestReferences(t *testing.T) {
+	if broken := load(t).References(); len(broken) > 0 {
+		t.Errorf("Expected every reference to resolve, broken: %v", broken)
+	}
+}
+
+// TestValidateSchema verifies the keywords of the validator against the schemas of the document
+func TestValidateSchema(t *testing.T) {
+	document := load(t)
+	tests := []struct {
+		name   string
+		schema string
+		body   string
+		valid  bool
+	}{
+		{"money", "Money", `{"currency": "EUR", "value": "10.00"}`, true},
+		{"money without value", "Money", `{"currency": "EUR"}`, false},
+		{"money with unknown property", "Money", `{"currency": "EUR", "value": "10.00", "cents": 1000}`, false},
+		{"money with a number as value", "Money", `{"currency": "EUR", "value": 10}`, false},
+		{"money with a lowercase currency", "Money", `{"currency": "eur", "value": "10.00"}`, false},
+		{"money input as object", "MoneyInput", `{"currency": "EUR", "value": "10.00"}`, true},
+		{"money input as number", "MoneyInput", `10.5`, true},
+		{"money input as string", "MoneyInput", `"10.50"`, false},
+		{"status", "Status", `"paid"`, true},
+		{"unknown status", "Status", `"lost"`, false},
+		{"transaction input", "TransactionInput", `{"amount": 10, "webhook_url": "https://shop.test/webhook", "redirect_url": "https://shop.test"}`, true},
+		{"transaction input with a relative url", "TransactionInput", `{"amount": 10, "webhook_url": "/webhook", "redirect_url": "https://shop.test"}`, false},
+		{"transaction input with a bad date", "TransactionInput", `{"amount": 10, "webhook_url": "https://shop.test/webhook", "redirect_url": "https://shop.test", "expires_at": "tomorrow"}`, false},
+		{"error", "Error", `{"error": {"code": "not_found", "message": "Transaction not found"}}`, true},
+		{"error without message", "Error", `{"error": {"code": "not_found"}}`, false},
+	}
+
+	for _, test := range tests {
+		err := document.ValidateSchema(test.schema, []byte(test.body))
+		if test.valid && err != nil {
+			t.Errorf("[%s] Expected the value to be valid, got: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("[%s] Expected the value to be rejected", test.name)
+		}
+	}
+}
+
+// TestValidationErrorPaths verifies that validation errors name the offending properties
+func TestValidationErrorPaths(t *testing.T) {
+	err := load(t).ValidateSchema("Money", []byte(`{"currency": "EUR", "value": 10, "cents": 1000}`))
+
+	var validationErr openapi.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got: %v", err)
+	}
+	expected := []string{"$.cents: unknown property", "$.value: expected string, got integer"}
+	if len(validationErr) != len(expected) {
+		t.Fatalf("Expected errors %v, got %v", expected, validationErr)
+	}
+	for i := range expected {
+		if validationErr[i] != expected[i] {
+			t.Errorf("Expected error %q, got %q", expected[i], validationErr[i])
+		}
+	}
+}
+
+// TestValidateResponse verifies that operations, statuses and content types are looked up
+func TestValidateResponse(t *testing.T) {
+	document := load(t)
+	const notFound = `{"error": {"code": "not_found", "message": "Transaction not found"}}`
+
+	if err := document.ValidateResponse("GET", "/v1/transactions/6522c1b5e4b0a1b2c3d4e5f6", 404, "application/json", []byte(notFound)); err != nil {
+		t.Errorf("Expected the response to be valid, got: %v", err)
+	}
+	if err := document.ValidateResponse("GET", "/v1/unknown", 404, "application/json", []byte(notFound)); !errors.Is(err, openapi.ErrUnknownOperation) {
+		t.Errorf("Expected %v, got: %v", openapi.ErrUnknownOperation, err)
+	}
+	if err := document.ValidateResponse("GET", "/v1/transactions/6522c1b5e4b0a1b2c3d4e5f6", 418, "application/json", []byte(notFound)); !errors.Is(err, openapi.ErrUndocumentedStatus) {
+		t.Errorf("Expected %v, got: %v", openapi.ErrUndocumentedStatus, err)
+	}
+	if err := document.ValidateResponse("GET", "/v1/transactions/6522c1b5e4b0a1b2c3d4e5f6", 404, "text/plain", []byte("not found")); !errors.Is(err, openapi.ErrUndocumentedContentType) {
+		t.Errorf("Expected %v, got: %v", openapi.ErrUndocumentedContentType, err)
+	}
+}
+
+// TestWebhookPayload verifies that the payloads posted to webhooks match their schema
+func TestWebhookPayload(t *testing.T) {
+	document := load(t)
+	payloads := map[string]webhook.Payload{
+		"transaction": {
+			ID:            "6522c1b5e4b0a1b2c3d4e5f7",
+			Event:         "transaction.paid",
+			TransactionID: "6522c1b5e4b0a1b2c3d4e5f6",
+			Status:        "paid",
+			CreatedAt:     time.Now(),
+		},
+		"refund": {
+			ID:            "6522c1b5e4b0a1b2c3d4e5f8",
+			Event:         "refund.succeeded",
+			TransactionID: "6522c1b5e4b0a1b2c3d4e5f6",
+			Status:        "paid",
+			Refund:        &webhook.RefundPayload{ID: "6522c1b5e4b0a1b2c3d4e5f9", Amount: money.New(250, "EUR"), Status: "succeeded", Reason: "Damaged"},
+			CreatedAt:     time.Now(),
+		},
+	}
+
+	for name, payload := range payloads {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Error marshaling payload: %v", err)
+		}
+		if err := document.ValidateSchema("WebhookPayload", body); err != nil {
+			t.Errorf("[%s] Expected the payload to match WebhookPayload, got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package signature_test
+
+import (
+	"bytes"
+	"dev-payment-gate/pkg/signature"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var payload = []byte(`{"id":"6522c1b5e4b0a1b2c3d4e5f6","status":"paid"}`)
+
+// TestVerify verifies that a freshly signed payload is accepted
+func TestVerify(t *testing.T) {
+	header := signature.Sign("secret", time.Now(), payload)
+	if err := signature.Verify("secret", header, payload, signature.DefaultTolerance); err != nil {
+		t.Errorf("Expected a valid signature, got: %v", err)
+	}
+}
+
+// TestVerifyRejects verifies the reasons a signature is rejected for
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		header  string
+		payload []byte
+		err     error
+	}{
+		{"wrong secret", signature.Sign("other", now, payload), payload, signature.ErrNoValidSignature},
+		{"tampered body", signature.Sign("secret", now, payload), []byte(`{"status":"failed"}`), signature.ErrNoValidSignature},
+		{"too old", signature.Sign("secret", now.Add(-time.Hour), payload), payload, signature.ErrTooOld},
+		{"no timestamp", fmt.Sprintf("v1=%s", signature.Compute("secret", now, payload)), payload, signature.ErrInvalidHeader},
+		{"no signature", fmt.Sprintf("t=%d", now.Unix()), payload, signature.ErrInvalidHeader},
+		{"garbage", "garbage", payload, signature.ErrInvalidHeader},
+	}
+
+	for _, test := range tests {
+		if err := signature.Verify("secret", test.header, test.payload, signature.DefaultTolerance); !errors.Is(err, test.err) {
+			t.Errorf("[%s] Expected %v, got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+// TestVerifyRotation verifies that any of multiple signatures may match
+func TestVerifyRotation(t *testing.T) {
+	now := time.Now()
+	header := fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), signature.Compute("old", now, payload), signature.Compute("new", now, payload))
+
+	for _, secret := range []string{"old", "new"} {
+		if err := signature.Verify(secret, header, payload, signature.DefaultTolerance); err != nil {
+			t.Errorf("Expected secret %s to be accepted, got: %v", secret, err)
+		}
+	}
+}
+
+// TestVerifyRequest verifies a request and makes sure its body can still be read afterwards
+func TestVerifyRequest(t *testing.T) {
+	request := httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	request.Header.Set(signature.Header, signature.Sign("secret", time.Now(), payload))
+
+	body, err := signature.VerifyRequest("secret", request)
+	if err != nil {
+		t.Fatalf("Expected a valid request, got: %v", err)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Expected the raw payload to be returned, got: %s", body)
+	}
+	if restored, _ := io.ReadAll(request.Body); !bytes.Equal(restored, payload) {
+		t.Errorf("Expected the request body to be restored, got: %s", restored)
+	}
+
+	// A request without a header is rejected
+	request = httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	if _, err := signature.VerifyRequest("secret", request); !errors.Is(err, signature.ErrMissingHeader) {
+		t.Errorf("Expected ErrMissingHeader, got: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package tlsserver_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"dev-payment-gate/internal/tlsserver"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// leaf parses the certificate served by the reloader
+func leaf(t *testing.T, reloader *tlsserver.Reloader) *x509.Certificate {
+	t.Helper()
+
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil || certificate == nil {
+		t.Fatalf("Could not get certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	return parsed
+}
+
+// TestSelfSigned verifies that a generated certificate covers its hosts and is kept on the next start
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "certs", "gate.crt"), filepath.Join(dir, "certs", "gate.key")
+
+	generated, err := tlsserver.EnsureSelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}, time.Now())
+	if err != nil || !generated {
+		t.Fatalf("Expected a generated certificate, got %t: %v", generated, err)
+	}
+	reloader, err := tlsserver.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Could not load the generated certificate: %v", err)
+	}
+	certificate := leaf(t, reloader)
+	if err := certificate.VerifyHostname("localhost"); err != nil {
+		t.Errorf("Expected the certificate to cover localhost: %v", err)
+	}
+	if len(certificate.IPAddresses) != 1 || !certificate.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Unexpected IP addresses: %v", certificate.IPAddresses)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private key only the owner can read, got: %v %v", info.Mode(), err)
+	}
+
+	// An existing certificate is not replaced
+	generated, err = tlsserver.EnsureSelfSigned(certFile, keyFile, []string{"localhost"}, time.Now())
+	if err != nil || generated {
+		t.Errorf("Expected the existing certificate to be kept, got %t: %v", generated, err)
+	}
+}
+
+// TestReload verifies that a replaced certificate is served without a restart
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "gate.crt"), filepath.Join(dir, "gate.key")
+	if err := tlsserver.GenerateSelfSigned(certFile, keyFile, []string{"old.test"}, time.Now()); err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+	reloader, err := tlsserver.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Could not load certificate: %v", err)
+	}
+
+	// Nothing changed yet
+	if reloaded, err := reloader.ReloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Expected no reload, got %t: %v", reloaded, err)
+	}
+
+	// Replace the certificate with a newer one
+	if err := tlsserver.GenerateSelfSigned(certFile, keyFile, []string{"new.test"}, time.Now()); err != nil {
+		t.Fatalf("Could not generate certificate: %v", err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	if reloaded, err := reloader.ReloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("Expected a reload, got %t: %v", reloaded, err)
+	}
+	if names := leaf(t, reloader).DNSNames; len(names) != 1 || names[0] != "new.test" {
+		t.Errorf("Expected the new certificate, got %v", names)
+	}
+
+	// A broken file keeps the last certificate in use
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatalf("Could not write key: %v", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Errorf("Expected an error for a broken key")
+	}
+	if names := leaf(t, reloader).DNSNames; len(names) != 1 || names[0] != "new.test" {
+		t.Errorf("Expected the last certificate to stay in use, got %v", names)
+	}
+}
+
+// TestStart verifies that a self-signed certificate is served over a TLS connection
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	config := tlsserver.Config{
+		CertFile:       filepath.Join(dir, "gate.crt"),
+		KeyFile:        filepath.Join(dir, "gate.key"),
+		SelfSigned:     true,
+		Hosts:          []string{"127.0.0.1"},
+		ReloadInterval: time.Hour,
+	}
+	tlsConfig, err := tlsserver.Start(config)
+	if err != nil {
+		t.Fatalf("Could not start: %v", err)
+	}
+	defer tlsserver.Stop()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	// Trust the generated certificate and connect
+	pem, err := os.ReadFile(config.CertFile)
+	if err != nil {
+		t.Fatalf("Could not read certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	conn.Close()
+
+	// Missing halves are rejected
+	if _, err := tlsserver.Start(tlsserver.Config{CertFile: config.CertFile}); err == nil {
+		t.Errorf("Expected an error without a key file")
+	}
+}
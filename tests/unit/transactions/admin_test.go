@@ -0,0 +1,119 @@
+package transactions_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminKey is the response of the admin API for an API key
+type adminKey struct {
+	ID         string     `json:"id"`
+	Key        string     `json:"key"`
+	Status     string     `json:"status"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// adminMerchant is the response of the admin API for a merchant
+type adminMerchant struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	WebhookSecret   string     `json:"webhook_secret"`
+	WebhookInsecure bool       `json:"webhook_insecure"`
+	Keys            []adminKey `json:"keys"`
+}
+
+// TestAdminDisabled verifies that the admin API is closed without ADMIN_KEY and checks the key
+func TestAdminDisabled(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "")
+	expectJSON(t, "", "GET", "/v1/admin/merchants", "", http.StatusUnauthorized, nil)
+
+	t.Setenv("ADMIN_KEY", "admin-secret")
+	expectJSON(t, "admin-secre", "GET", "/v1/admin/merchants", "", http.StatusUnauthorized, nil)
+	expectJSON(t, "admin-secret", "GET", "/v1/admin/merchants", "", http.StatusOK, nil)
+}
+
+// TestKeyLifecycle creates, uses, rotates, expires and revokes API keys through the admin API
+func TestKeyLifecycle(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "admin-secret")
+	const admin = "admin-secret"
+
+	// Create a merchant, its key works right away and records its use
+	var merchant adminMerchant
+	expectJSON(t, admin, "POST", "/v1/admin/merchants", `{"name": "shop", "mode": "live"}`, http.StatusCreated, &merchant)
+	if !strings.HasPrefix(merchant.WebhookSecret, "whsec_") {
+		t.Errorf("Expected a generated webhook secret, got %q", merchant.WebhookSecret)
+	}
+	first := merchant.Keys[0]
+	expectJSON(t, first.Key, "GET", "/v1/transactions", "", http.StatusOK, nil)
+	var listed []adminMerchant
+	expectJSON(t, admin, "GET", "/v1/admin/merchants", "", http.StatusOK, &listed)
+	for _, m := range listed {
+		if m.ID == merchant.ID && (m.Keys[0].LastUsedAt == nil || m.Keys[0].Key != "") {
+			t.Errorf("Expected a recorded use and no plain key, got %+v", m.Keys[0])
+		}
+	}
+	keys := fmt.Sprintf("/v1/admin/merchants/%s/keys", merchant.ID)
+
+	// Rotating keeps the old key working during the overlap
+	var second adminKey
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/rotate", keys, first.ID), `{"overlap": "1h"}`, http.StatusCreated, &second)
+	expectJSON(t, first.Key, "GET", "/v1/transactions", "", http.StatusOK, nil)
+	expectJSON(t, second.Key, "GET", "/v1/transactions", "", http.StatusOK, nil)
+
+	// Expiring the old key now ends the overlap early
+	body := fmt.Sprintf(`{"expires_at": %q}`, time.Now().Add(-time.Second).Format(time.RFC3339))
+	var expired adminKey
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/expire", keys, first.ID), body, http.StatusOK, &expired)
+	if expired.Status != "expired" {
+		t.Errorf("Expected the key to be expired, got %s", expired.Status)
+	}
+	expectJSON(t, first.Key, "GET", "/v1/transactions", "", http.StatusUnauthorized, nil)
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/rotate", keys, first.ID), "", http.StatusConflict, nil)
+
+	// Revoked keys stop working and can not be revoked again
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/revoke", keys, second.ID), "", http.StatusOK, nil)
+	expectJSON(t, second.Key, "GET", "/v1/transactions", "", http.StatusUnauthorized, nil)
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/revoke", keys, second.ID), "", http.StatusConflict, nil)
+
+	// New keys can be added with an expiry, invalid input is rejected
+	var third adminKey
+	expectJSON(t, admin, "POST", keys, fmt.Sprintf(`{"expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339)), http.StatusCreated, &third)
+	expectJSON(t, third.Key, "GET", "/v1/transactions", "", http.StatusOK, nil)
+	expectJSON(t, admin, "POST", keys, `{"mode": "staging"}`, http.StatusBadRequest, nil)
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/rotate", keys, third.ID), `{"overlap": "soon"}`, http.StatusBadRequest, nil)
+	expectJSON(t, admin, "POST", fmt.Sprintf("%s/%s/revoke", keys, merchant.ID), "", http.StatusNotFound, nil)
+}
+
+// TestMerchantSettings verifies that merchants can be renamed and opt out of webhook certificate verification
+func TestMerchantSettings(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "admin-secret")
+	const admin = "admin-secret"
+
+	// Certificates are verified unless the merchant opts out
+	var merchant adminMerchant
+	expectJSON(t, admin, "POST", "/v1/admin/merchants", `{"name": "shop"}`, http.StatusCreated, &merchant)
+	if merchant.WebhookInsecure {
+		t.Errorf("Expected webhook certificates to be verified by default")
+	}
+	uri := fmt.Sprintf("/v1/admin/merchants/%s", merchant.ID)
+
+	// Fields that are left out keep their value
+	var updated adminMerchant
+	expectJSON(t, admin, "PATCH", uri, `{"webhook_insecure": true}`, http.StatusOK, &updated)
+	if !updated.WebhookInsecure || updated.Name != "shop" {
+		t.Errorf("Unexpected merchant: %+v", updated)
+	}
+	expectJSON(t, admin, "PATCH", uri, `{"name": "renamed"}`, http.StatusOK, &updated)
+	if !updated.WebhookInsecure || updated.Name != "renamed" {
+		t.Errorf("Unexpected merchant: %+v", updated)
+	}
+
+	// Invalid settings and unknown merchants are rejected
+	expectJSON(t, admin, "PATCH", uri, `{"name": " "}`, http.StatusBadRequest, nil)
+	expectJSON(t, admin, "PATCH", "/v1/admin/merchants/000000000000000000000000", `{"name": "x"}`, http.StatusNotFound, nil)
+	expectJSON(t, "", "PATCH", uri, `{"name": "x"}`, http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,183 @@
+package transactions_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// transactionResponse holds the fields of the JSON representation of a transaction checked by the tests
+type transactionResponse struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CheckoutURL string `json:"checkout_url"`
+	RedirectURL string `json:"redirect_url"`
+	History     []struct {
+		To string    `json:"to"`
+		At time.Time `json:"at"`
+	} `json:"history"`
+	Webhooks []struct {
+		Event  string `json:"event"`
+		Status string `json:"status"`
+	} `json:"webhooks"`
+}
+
+// TestGetTransaction verifies the status query endpoint
+func TestGetTransaction(t *testing.T) {
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(300, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "failed"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+
+	// Fetch the transaction
+	var transaction transactionResponse
+	expectJSON(t, os.Getenv("API_KEY"), "GET", fmt.Sprintf("/v1/transactions/%s", id.Hex()), "", http.StatusOK, &transaction)
+
+	// Verify the returned state
+	if transaction.ID != id.Hex() || transaction.Status != "failed" || transaction.RedirectURL != "https://test.nl" {
+		t.Errorf("Unexpected transaction: %+v", transaction)
+	}
+	if len(transaction.History) != 2 || transaction.History[1].To != "failed" || transaction.History[1].At.IsZero() {
+		t.Errorf("Unexpected history: %+v", transaction.History)
+	}
+	if len(transaction.Webhooks) != 1 || transaction.Webhooks[0].Event != "transaction.failed" {
+		t.Errorf("Unexpected webhooks: %+v", transaction.Webhooks)
+	}
+}
+
+// TestGetTransactionErrors verifies authentication and unknown transactions
+func TestGetTransactionErrors(t *testing.T) {
+	// Requests without the API key are rejected
+	if recorder := requestAs("wrong", "GET", fmt.Sprintf("/v1/transactions/%s", transactionID.Hex()), ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+
+	// Unknown transactions are not found
+	if recorder := requestAs(os.Getenv("API_KEY"), "GET", fmt.Sprintf("/v1/transactions/%s", primitive.NewObjectID().Hex()), ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+// TestListTransactions verifies filtering and pagination of the listing endpoint
+func TestListTransactions(t *testing.T) {
+	reference := primitive.NewObjectID().Hex()
+	var ids []primitive.ObjectID
+	for i := 0; i < 3; i++ {
+		ids = append(ids, createTransaction(t, transactions.TransactionInput{Amount: money.New(500, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl", Reference: reference}))
+	}
+	postOutcome(ids[0], "paid")
+
+	// list fetches a single page
+	list := func(query string) (page struct {
+		Data       []transactionResponse `json:"data"`
+		HasMore    bool                  `json:"has_more"`
+		NextCursor string                `json:"next_cursor"`
+	}) {
+		expectJSON(t, os.Getenv("API_KEY"), "GET", "/v1/transactions?"+query, "", http.StatusOK, &page)
+		return page
+	}
+
+	// Page through the transactions with the reference
+	first := list(fmt.Sprintf("reference=%s&limit=2", reference))
+	if len(first.Data) != 2 || !first.HasMore || first.Data[0].ID != ids[2].Hex() {
+		t.Fatalf("Unexpected first page: %+v", first)
+	}
+	second := list(fmt.Sprintf("reference=%s&limit=2&cursor=%s", reference, first.NextCursor))
+	if len(second.Data) != 1 || second.HasMore || second.Data[0].ID != ids[0].Hex() {
+		t.Fatalf("Unexpected second page: %+v", second)
+	}
+
+	// Only the paid transaction has a webhook delivery, listed with it
+	if len(first.Data[0].Webhooks) != 0 || len(second.Data[0].Webhooks) != 1 || second.Data[0].Webhooks[0].Event != "transaction.paid" {
+		t.Errorf("Unexpected webhooks: %+v, %+v", first.Data[0].Webhooks, second.Data[0].Webhooks)
+	}
+
+	// Filter on status
+	open := list(fmt.Sprintf("reference=%s&status=open,failed", reference))
+	if len(open.Data) != 2 {
+		t.Errorf("Expected 2 open transactions, got %d", len(open.Data))
+	}
+
+	// Reject invalid filters
+	for _, query := range []string{"status=unknown", "amount_min=a", "created_from=yesterday", "limit=1000", "cursor=nope"} {
+		if recorder := requestAs(os.Getenv("API_KEY"), "GET", "/v1/transactions?"+query, ""); recorder.Code != http.StatusBadRequest {
+			t.Errorf("[%s] Expected status code %d, got %d", query, http.StatusBadRequest, recorder.Code)
+		}
+	}
+}
+
+// TestCheckoutScheme verifies that checkout URLs use the scheme the client connected with
+func TestCheckoutScheme(t *testing.T) {
+	checkoutURL := func(secure bool, forwarded string) string {
+		request := httptest.NewRequest("POST", "/transaction", bytes.NewBufferString(`{"amount": 5, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`))
+		request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("API_KEY")))
+		request.Header.Add("Content-Type", "application/json")
+		if secure {
+			request.TLS = &tls.ConnectionState{}
+		}
+		if forwarded != "" {
+			request.Header.Add("X-Forwarded-Proto", forwarded)
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		var redirection redirectResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&redirection); err != nil {
+			t.Fatalf("Error parsing JSON response: %v", err)
+		}
+		return redirection.URL
+	}
+
+	tests := []struct {
+		name       string
+		secure     bool
+		forwarded  string
+		trustProxy string
+		scheme     string
+	}{
+		{"plain", false, "", "", "http://"},
+		{"tls", true, "", "", "https://"},
+		{"untrusted proxy", false, "https", "", "http://"},
+		{"trusted proxy", false, "https, http", "true", "https://"},
+		{"unknown proto", false, "gopher", "true", "http://"},
+	}
+	for _, test := range tests {
+		t.Setenv("TRUST_PROXY", test.trustProxy)
+		if url := checkoutURL(test.secure, test.forwarded); !strings.HasPrefix(url, test.scheme) {
+			t.Errorf("%s: expected a URL starting with %s, got %s", test.name, test.scheme, url)
+		}
+	}
+}
+
+// TestCancel verifies that open transactions can be canceled through the API and are then closed
+func TestCancel(t *testing.T) {
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(500, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/cancel", id.Hex()), ""); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder := postOutcome(id, "paid"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/cancel", id.Hex()), ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+	paid := paidTransaction(t, money.New(500, "EUR"))
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/cancel", paid.Hex()), ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+
+	if events := webhookEvents(t, id); len(events) != 1 || events[0] != "transaction.canceled" {
+		t.Errorf("Unexpected webhook events: %v", events)
+	}
+}
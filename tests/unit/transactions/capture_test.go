@@ -0,0 +1,142 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// authorizedTransaction creates a transaction with manual capture and authorizes it on the checkout page
+func authorizedTransaction(t *testing.T, amount money.Money) primitive.ObjectID {
+	t.Helper()
+
+	id := createTransaction(t, transactions.TransactionInput{Amount: amount, WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl", CaptureMethod: transactions.CaptureManual})
+	if recorder := postOutcome(id, "paid"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	return id
+}
+
+// webhookEvents returns the events queued for the webhook of a transaction
+func webhookEvents(t *testing.T, id primitive.ObjectID) []string {
+	t.Helper()
+
+	webhooks, err := deliveries.ListByTransaction(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not list webhook deliveries: %v", err)
+	}
+	events := []string{}
+	for _, delivery := range webhooks {
+		events = append(events, delivery.Event)
+	}
+	return events
+}
+
+// TestPartialCapture verifies that an authorization is captured for a lower amount and refunded within that amount
+func TestPartialCapture(t *testing.T) {
+	id := authorizedTransaction(t, money.New(1000, "EUR"))
+
+	// The checkout page only authorizes the payment, which gets its own expiry time
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if transaction.Status != transactions.StatusAuthorized {
+		t.Fatalf("Expected status %s, got %s", transactions.StatusAuthorized, transaction.Status)
+	}
+	if expected := transaction.ChangedAt(transactions.StatusAuthorized).Add(expiry.AuthorizationExpiry()); !transaction.ExpiresAt.Equal(expected) {
+		t.Errorf("Expected the authorization to expire at %v, got %v", expected, transaction.ExpiresAt)
+	}
+
+	// More than the authorized amount can not be captured
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/capture", id.Hex()), `{"amount": 10.01}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	// Capture the actual amount
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/capture", id.Hex()), `{"amount": 6.5}`); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/capture", id.Hex()), `{}`); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a second capture, got %d", http.StatusConflict, recorder.Code)
+	}
+
+	// Refunds are bounded by the captured amount
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{"amount": 6.51}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{}`); recorder.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, recorder.Code)
+	}
+	transaction, err = transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if transaction.Status != transactions.StatusPaid || transaction.Captured != money.New(650, "EUR") || transaction.Refunds[0].Amount != money.New(650, "EUR") {
+		t.Errorf("Unexpected capture: %s captured %s, refunded %+v", transaction.Status, transaction.Captured, transaction.Refunds)
+	}
+
+	// The authorization and capture were sent to the webhook
+	if events := webhookEvents(t, id); len(events) < 2 || events[0] != "transaction.authorized" || events[1] != "transaction.paid" {
+		t.Errorf("Unexpected webhook events: %v", events)
+	}
+}
+
+// TestVoid verifies that an authorization can be voided instead of captured
+func TestVoid(t *testing.T) {
+	id := authorizedTransaction(t, money.New(500, "EUR"))
+
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/void", id.Hex()), ""); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/capture", id.Hex()), ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/void", id.Hex()), ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+
+	if events := webhookEvents(t, id); len(events) != 2 || events[1] != "transaction.canceled" {
+		t.Errorf("Unexpected webhook events: %v", events)
+	}
+}
+
+// TestCaptureAutomatic verifies that transactions with automatic capture can not be captured or voided
+func TestCaptureAutomatic(t *testing.T) {
+	id := paidTransaction(t, money.New(500, "EUR"))
+
+	for _, action := range []string{"capture", "void"} {
+		if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/%s", id.Hex(), action), ""); recorder.Code != http.StatusConflict {
+			t.Errorf("[%s] Expected status code %d, got %d", action, http.StatusConflict, recorder.Code)
+		}
+	}
+}
+
+// TestAuthorizationExpiry verifies that authorizations that are not captured in time expire
+func TestAuthorizationExpiry(t *testing.T) {
+	id := authorizedTransaction(t, money.New(500, "EUR"))
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+
+	// Sweep as if the authorization expiry time passed
+	if _, err := expiry.Sweep(context.TODO(), transaction.ExpiresAt.Add(time.Second)); err != nil {
+		t.Fatalf("Could not sweep: %v", err)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/capture", id.Hex()), ""); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+	if events := webhookEvents(t, id); len(events) != 2 || events[1] != "transaction.expired" {
+		t.Errorf("Unexpected webhook events: %v", events)
+	}
+}
@@ -0,0 +1,149 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/pkg/client"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/money"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestClient runs the Go client against the gate through a payment, refund, capture and cancel, and
+// receives the signed webhooks with its webhook handler
+func TestClient(t *testing.T) {
+	gate := httptest.NewServer(r)
+	defer gate.Close()
+	c := client.New(gate.URL, os.Getenv("API_KEY"))
+	ctx := context.Background()
+
+	// Settle refunds right away
+	t.Setenv("PENDING_DELAY", "10ms")
+
+	// The webhooks are signed with the secret of the merchant, which the handler of the client verifies
+	merchant, _, err := merchants.Authenticate(ctx, os.Getenv("API_KEY"))
+	if err != nil {
+		t.Fatalf("Failed to find the merchant: %v", err)
+	}
+	secret := merchant.WebhookSecret
+	if !strings.HasPrefix(secret, merchants.WebhookSecretPrefix) {
+		t.Fatalf("Expected the merchant to have a webhook secret, got %q", secret)
+	}
+
+	// Collect the events received by the webhook handler of the client
+	var mu sync.Mutex
+	var events []*client.Event
+	receiver := httptest.NewServer(client.WebhookHandler(secret, func(ctx context.Context, event *client.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+		return nil
+	}))
+	defer receiver.Close()
+
+	// Create a transaction and pay it on the checkout page
+	created, err := c.CreateTransaction(ctx, client.CreateParams{Amount: money.New(1000, "EUR"), WebhookURL: receiver.URL, RedirectURL: "https://test.nl", Reference: "client-order"})
+	if err != nil {
+		t.Fatalf("Could not create transaction: %v", err)
+	}
+	if created.URL != gate.URL+"/transaction/"+created.ID {
+		t.Errorf("Unexpected checkout URL %s", created.URL)
+	}
+	id, _ := primitive.ObjectIDFromHex(created.ID)
+	postOutcome(id, "paid")
+
+	// Fetch it alone and in the list
+	transaction, err := c.GetTransaction(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Could not get transaction: %v", err)
+	}
+	if transaction.Status != client.StatusPaid || transaction.Amount != money.New(1000, "EUR") || transaction.AmountCaptured == nil || transaction.Reference != "client-order" {
+		t.Errorf("Unexpected transaction: %+v", transaction)
+	}
+	list, err := c.ListTransactions(ctx, client.ListParams{Reference: "client-order", Statuses: []client.Status{client.StatusPaid}, Limit: 5})
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(list.Data) != 1 || list.Data[0].ID != created.ID || list.HasMore {
+		t.Errorf("Unexpected list: %+v", list)
+	}
+
+	// Refund part of it, refunding too much is a validation error
+	refund, err := c.RefundTransaction(ctx, created.ID, client.RefundParams{Amount: &money.Money{Value: 250, Currency: "EUR"}, Reason: "Damaged"})
+	if err != nil {
+		t.Fatalf("Could not refund transaction: %v", err)
+	}
+	if refund.TransactionID != created.ID || refund.Amount != money.New(250, "EUR") || refund.Status != client.RefundPending {
+		t.Errorf("Unexpected refund: %+v", refund)
+	}
+	_, err = c.RefundTransaction(ctx, created.ID, client.RefundParams{Amount: &money.Money{Value: 5000, Currency: "EUR"}})
+	var apiErr *client.Error
+	if !errors.Is(err, client.ErrValidation) || !errors.As(err, &apiErr) || len(apiErr.Details) != 1 || apiErr.Details[0].Field != "amount.value" {
+		t.Errorf("Expected a validation error on amount.value, got: %v", err)
+	}
+
+	// Paid transactions can not be canceled, unknown ones are not found
+	if _, err := c.CancelTransaction(ctx, created.ID); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("Expected %v, got: %v", client.ErrConflict, err)
+	}
+	if _, err := c.GetTransaction(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected %v, got: %v", client.ErrNotFound, err)
+	}
+	if _, err := client.New(gate.URL, "sk_test_unknown").GetTransaction(ctx, created.ID); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Expected %v, got: %v", client.ErrUnauthorized, err)
+	}
+
+	// Capture part of an authorization, then cancel an open transaction
+	authorized := authorizedTransaction(t, money.New(1000, "EUR"))
+	captured, err := c.CaptureTransaction(ctx, authorized.Hex(), &money.Money{Value: 600, Currency: "EUR"})
+	if err != nil || captured.Status != client.StatusPaid || *captured.AmountCaptured != money.New(600, "EUR") {
+		t.Errorf("Unexpected capture: %+v, %v", captured, err)
+	}
+	open, err := c.CreateTransaction(ctx, client.CreateParams{Amount: money.New(1000, "EUR"), WebhookURL: receiver.URL, RedirectURL: "https://test.nl"})
+	if err != nil {
+		t.Fatalf("Could not create transaction: %v", err)
+	}
+	canceled, err := c.CancelTransaction(ctx, open.ID)
+	if err != nil || canceled.Status != client.StatusCanceled {
+		t.Errorf("Unexpected cancel: %+v, %v", canceled, err)
+	}
+
+	// Creating with the same idempotency key returns the same transaction
+	keyed := client.WithIdempotencyKey(ctx, "client-test-"+primitive.NewObjectID().Hex())
+	first, err := c.CreateTransaction(keyed, client.CreateParams{Amount: money.New(100, "EUR"), WebhookURL: receiver.URL, RedirectURL: "https://test.nl"})
+	if err != nil {
+		t.Fatalf("Could not create transaction: %v", err)
+	}
+	second, err := c.CreateTransaction(keyed, client.CreateParams{Amount: money.New(100, "EUR"), WebhookURL: receiver.URL, RedirectURL: "https://test.nl"})
+	if err != nil || second.ID != first.ID {
+		t.Errorf("Expected the same transaction %s, got %+v, %v", first.ID, second, err)
+	}
+
+	// The handler receives the payment, the refund and the cancellation as typed events
+	expected := map[string]bool{"transaction.paid": false, "refund.succeeded": false, "transaction.canceled": false}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		for _, event := range events {
+			if _, ok := expected[event.Type]; ok {
+				expected[event.Type] = true
+			}
+			if event.IsRefund() && (event.Refund == nil || event.Refund.ID != refund.ID || event.TransactionID != created.ID) {
+				t.Errorf("Unexpected refund event: %+v", event)
+			}
+		}
+		mu.Unlock()
+		if expected["transaction.paid"] && expected["refund.succeeded"] && expected["transaction.canceled"] {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected every event to be received, got %v", expected)
+}
@@ -0,0 +1,106 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dashboardRequest serves a dashboard request with the given admin password and cookies
+func dashboardRequest(method, uri, password string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, uri, strings.NewReader(form.Encode()))
+	if password != "" {
+		request.SetBasicAuth("admin", password)
+	}
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestDashboard verifies that the dashboard shows transactions and can change them
+func TestDashboard(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "admin-secret")
+
+	// Store a failed transaction with a webhook delivery
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(1250, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl", Reference: "dashboard"})
+	transaction.Transition(transactions.StatusFailed, transaction.Timestamp)
+	id, err := transactions.Insert(context.TODO(), &transaction)
+	if err != nil {
+		t.Fatalf("Could not insert transaction: %v", err)
+	}
+	delivery := deliveries.Create(*id, "transaction.failed", transaction.WebhookURL, "", []byte(`{"event":"transaction.failed"}`))
+	delivery.Status = deliveries.StatusDead
+	delivery.Attempts = []deliveries.Attempt{{At: transaction.Timestamp, StatusCode: 500, Error: "webhook responded with status 500", ResponseBody: "boom"}}
+	if _, err := deliveries.Insert(context.TODO(), &delivery); err != nil {
+		t.Fatalf("Could not insert delivery: %v", err)
+	}
+	page := fmt.Sprintf("/admin/transactions/%s", id.Hex())
+
+	// The dashboard asks for credentials
+	recorder := dashboardRequest("GET", "/admin/transactions", "", nil)
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected a credentials challenge, got %d", recorder.Code)
+	}
+	if recorder := dashboardRequest("GET", "/admin/transactions", "wrong", nil); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+
+	// The list links to the transaction
+	recorder = dashboardRequest("GET", "/admin/transactions?status=failed", "admin-secret", nil)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), page) {
+		t.Fatalf("Expected the transaction to be listed, got %d", recorder.Code)
+	}
+
+	// The transaction page shows the timeline and the webhook attempt
+	recorder = dashboardRequest("GET", page, "admin-secret", nil)
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.Contains(body, "Status changed from open to failed") || !strings.Contains(body, "boom") {
+		t.Fatalf("Expected the timeline and webhook attempt, got %d:\n%s", recorder.Code, body)
+	}
+	cookies := recorder.Result().Cookies()
+	match := regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`).FindStringSubmatch(body)
+	if len(cookies) != 1 || match == nil || cookies[0].Value != match[1] {
+		t.Fatalf("Expected the forms to carry the CSRF cookie")
+	}
+
+	// Forms without the CSRF token are rejected
+	form := url.Values{"status": {"paid"}}
+	if recorder := dashboardRequest("POST", page+"/status", "admin-secret", form, cookies...); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d without a CSRF token, got %d", http.StatusForbidden, recorder.Code)
+	}
+
+	// Forcing a status bypasses the lifecycle and is recorded as forced
+	form.Set("csrf_token", match[1])
+	if recorder := dashboardRequest("POST", page+"/status", "admin-secret", form, cookies...); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusSeeOther, recorder.Code, recorder.Body.String())
+	}
+	forced, err := transactions.GetByID(context.TODO(), *id)
+	if err != nil || forced.Status != transactions.StatusPaid || !forced.History[len(forced.History)-1].Forced {
+		t.Errorf("Expected a forced change to paid, got %+v (%v)", forced, err)
+	}
+
+	// Resending queues the dead delivery again
+	form = url.Values{"csrf_token": {match[1]}}
+	uri := fmt.Sprintf("%s/webhooks/%s/resend", page, delivery.ID.Hex())
+	if recorder := dashboardRequest("POST", uri, "admin-secret", form, cookies...); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusSeeOther, recorder.Code, recorder.Body.String())
+	}
+	resent, err := deliveries.GetByID(context.TODO(), delivery.ID)
+	if err != nil || resent.Status == deliveries.StatusDead {
+		t.Errorf("Expected the delivery to be queued again, got %+v (%v)", resent, err)
+	}
+}
@@ -0,0 +1,147 @@
+package transactions_test
+
+import (
+	"bufio"
+	"context"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statusEvent is the data of a status event on the event stream of a transaction
+type statusEvent struct {
+	Status      string `json:"status"`
+	Done        bool   `json:"done"`
+	RedirectURL string `json:"redirect_url"`
+}
+
+// nextEvent reads the next status event from an event stream, skipping comments and other fields
+func nextEvent(t *testing.T, scanner *bufio.Scanner) *statusEvent {
+	t.Helper()
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event statusEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Error parsing event %q: %v", data, err)
+		}
+		return &event
+	}
+	return nil
+}
+
+// TestTransactionEvents follows a pending payment on the event stream until it is settled
+func TestTransactionEvents(t *testing.T) {
+	os.Setenv("PENDING_DELAY", "50ms")
+	defer os.Unsetenv("PENDING_DELAY")
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// Open the stream of a new transaction, it starts with the current status
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(300, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	response, err := client.Get(fmt.Sprintf("%s/transaction/%s/events", server.URL, id.Hex()))
+	if err != nil {
+		t.Fatalf("Could not open the event stream: %v", err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got Content-Type: %s", contentType)
+	}
+	scanner := bufio.NewScanner(response.Body)
+	if event := nextEvent(t, scanner); event == nil || event.Status != "open" || event.Done {
+		t.Fatalf("Expected the open status, got %+v", event)
+	}
+
+	// Every change is pushed until the payment is settled
+	if recorder := postOutcome(id, "pending"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	if event := nextEvent(t, scanner); event == nil || event.Status != "pending" || event.Done {
+		t.Fatalf("Expected the pending status, got %+v", event)
+	}
+	event := nextEvent(t, scanner)
+	if event == nil || event.Status != "paid" || !event.Done || event.RedirectURL != "https://test.nl?status=paid" {
+		t.Fatalf("Expected the final paid status, got %+v", event)
+	}
+
+	// The stream ends with the checkout
+	if event := nextEvent(t, scanner); event != nil {
+		t.Errorf("Expected the stream to end, got %+v", event)
+	}
+}
+
+// TestTransactionEventsFinished verifies that the stream of a finished transaction only sends its status
+func TestTransactionEventsFinished(t *testing.T) {
+	server := httptest.NewServer(r)
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(300, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "failed"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	response, err := client.Get(fmt.Sprintf("%s/transaction/%s/events", server.URL, id.Hex()))
+	if err != nil {
+		t.Fatalf("Could not open the event stream: %v", err)
+	}
+	defer response.Body.Close()
+	scanner := bufio.NewScanner(response.Body)
+	if event := nextEvent(t, scanner); event == nil || event.Status != "failed" || !event.Done {
+		t.Fatalf("Expected the final failed status, got %+v", event)
+	}
+	if event := nextEvent(t, scanner); event != nil {
+		t.Errorf("Expected the stream to end, got %+v", event)
+	}
+
+	// Unknown transactions have no stream
+	response, err = client.Get(fmt.Sprintf("%s/transaction/%s/events", server.URL, "000000000000000000000000"))
+	if err != nil {
+		t.Fatalf("Could not request the event stream: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, response.StatusCode)
+	}
+}
+
+// TestTransactionEventsShutdown verifies that open streams end when the server shuts down
+func TestTransactionEventsShutdown(t *testing.T) {
+	server := httptest.NewServer(r)
+	defer server.Close()
+	server.Config.RegisterOnShutdown(transactions.CloseSubscriptions)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	// Open the stream of a transaction that stays open
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(300, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	response, err := client.Get(fmt.Sprintf("%s/transaction/%s/events", server.URL, id.Hex()))
+	if err != nil {
+		t.Fatalf("Could not open the event stream: %v", err)
+	}
+	defer response.Body.Close()
+	scanner := bufio.NewScanner(response.Body)
+	if event := nextEvent(t, scanner); event == nil || event.Status != "open" {
+		t.Fatalf("Expected the open status, got %+v", event)
+	}
+
+	// Shutting down does not wait for the stream to time out
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the server to shut down, got: %v", err)
+	}
+	if event := nextEvent(t, scanner); event != nil {
+		t.Errorf("Expected the stream to end, got %+v", event)
+	}
+}
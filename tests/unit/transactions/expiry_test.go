@@ -0,0 +1,127 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDefaultExpiry verifies that transactions without an expiry time get the default one
+func TestDefaultExpiry(t *testing.T) {
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if expected := transaction.Timestamp.Add(expiry.DefaultExpiry()); !transaction.ExpiresAt.Equal(expected) {
+		t.Errorf("Expected expiry at %v, got %v", expected, transaction.ExpiresAt)
+	}
+}
+
+// TestExpiresAtInPast verifies that transactions can not be created already expired
+func TestExpiresAtInPast(t *testing.T) {
+	body := fmt.Sprintf(`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl", "expires_at": %q}`, time.Now().Add(-time.Minute).Format(time.RFC3339))
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", body); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestSweep verifies that the sweeper expires abandoned transactions and notifies their webhook
+func TestSweep(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl", ExpiresAt: &expiresAt})
+
+	// Nothing happens before the expiry time
+	if _, err := expiry.Sweep(context.TODO(), time.Now()); err != nil {
+		t.Fatalf("Could not sweep: %v", err)
+	}
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if transaction.Status != transactions.StatusOpen {
+		t.Fatalf("Expected status %s before expiry, got %s", transactions.StatusOpen, transaction.Status)
+	}
+
+	// Sweep as if the expiry time passed
+	if _, err := expiry.Sweep(context.TODO(), expiresAt.Add(time.Second)); err != nil {
+		t.Fatalf("Could not sweep: %v", err)
+	}
+	transaction, err = transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if transaction.Status != transactions.StatusExpired {
+		t.Errorf("Expected status %s after expiry, got %s", transactions.StatusExpired, transaction.Status)
+	}
+
+	// An expired webhook was queued
+	webhooks, err := deliveries.ListByTransaction(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not list deliveries: %v", err)
+	}
+	if len(webhooks) != 1 || webhooks[0].Event != "transaction.expired" {
+		t.Errorf("Expected a single expired webhook, got: %+v", webhooks)
+	}
+}
+
+// TestCheckoutExpired verifies that the checkout page shows the expired state as soon as the expiry time passes
+func TestCheckoutExpired(t *testing.T) {
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl", ExpiresAt: &expiresAt})
+	time.Sleep(100 * time.Millisecond)
+
+	// Request the checkout page
+	request := httptest.NewRequest("GET", fmt.Sprintf("/transaction/%s", id.Hex()), nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), "has expired") {
+		t.Error("Checkout page does not show the expired state")
+	}
+
+	// Paying is no longer possible
+	if recorder := postOutcome(id, "paid"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
+	}
+}
+
+// TestSweepPending verifies that the sweeper settles payments that stayed pending, as after a restart
+func TestSweepPending(t *testing.T) {
+	t.Setenv("PENDING_DELAY", "1h")
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "pending"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+
+	// Nothing happens before the pending delay passed
+	if settled, err := expiry.SweepPending(context.TODO(), time.Now()); err != nil || settled != 0 {
+		t.Fatalf("Expected nothing to settle, got %d (%v)", settled, err)
+	}
+
+	// Sweep as if the pending delay passed
+	if _, err := expiry.SweepPending(context.TODO(), time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("Could not sweep: %v", err)
+	}
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if transaction.Status != transactions.StatusPaid {
+		t.Errorf("Expected status %s after the pending delay, got %s", transactions.StatusPaid, transaction.Status)
+	}
+	if events := webhookEvents(t, id); strings.Join(events, ",") != "transaction.pending,transaction.paid" {
+		t.Errorf("Expected a pending and a paid webhook, got: %v", events)
+	}
+}
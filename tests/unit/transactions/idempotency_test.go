@@ -0,0 +1,170 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/api/handler"
+	"dev-payment-gate/utils/model/idempotency"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestIdempotentCreate verifies that retries with the same key do not create duplicate transactions
+func TestIdempotentCreate(t *testing.T) {
+	key := primitive.NewObjectID().Hex()
+	reference := primitive.NewObjectID().Hex()
+	body := fmt.Sprintf(`{"amount": 1.25, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl", "reference": %q}`, reference)
+
+	// The first request creates the transaction
+	first := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", body, "Idempotency-Key", key)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, first.Code)
+	}
+
+	// The retry gets the same response
+	retry := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", body, "Idempotency-Key", key)
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the original response %s, got %s", first.Body.String(), retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Missing Idempotent-Replayed header on the replayed response")
+	}
+
+	// Only a single transaction exists
+	found, err := transactions.List(context.TODO(), transactions.Filter{Reference: reference}, primitive.NilObjectID, 10)
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(found) != 1 {
+		t.Errorf("Expected a single transaction, got %d", len(found))
+	}
+
+	// Reusing the key for a different request is rejected
+	if mismatch := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", `{"amount": 9.99, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "Idempotency-Key", key); mismatch.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnprocessableEntity, mismatch.Code)
+	}
+
+	// A different key creates a new transaction
+	if other := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", body, "Idempotency-Key", primitive.NewObjectID().Hex()); other.Code != http.StatusCreated || other.Body.String() == first.Body.String() {
+		t.Errorf("Expected a new transaction for a new key, got %d: %s", other.Code, other.Body.String())
+	}
+}
+
+// TestIdempotencyScope verifies that idempotency keys are shared by the API keys of a merchant and
+// kept apart between merchants
+func TestIdempotencyScope(t *testing.T) {
+	key := primitive.NewObjectID().Hex()
+	body := `{"amount": 1.25, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`
+
+	// Give a merchant a second API key
+	first := newMerchant(t, merchants.ModeTest)
+	merchant, _, err := merchants.Authenticate(context.TODO(), first)
+	if err != nil {
+		t.Fatalf("Could not find merchant: %v", err)
+	}
+	second, _, err := merchants.AddKey(context.TODO(), merchant.ID, merchants.ModeTest, nil)
+	if err != nil {
+		t.Fatalf("Could not add API key: %v", err)
+	}
+
+	// A retry with another key of the merchant is replayed
+	original := requestAs(first, "POST", "/transaction", body, "Idempotency-Key", key)
+	if original.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, original.Code)
+	}
+	retry := requestAs(second, "POST", "/transaction", body, "Idempotency-Key", key)
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != original.Body.String() {
+		t.Errorf("Expected the original response to be replayed, got %d: %s", retry.Code, retry.Body.String())
+	}
+
+	// Another merchant using the same key creates its own transaction
+	other := requestAs(newMerchant(t, merchants.ModeTest), "POST", "/transaction", body, "Idempotency-Key", key)
+	if other.Code != http.StatusCreated || other.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected a new transaction for another merchant, got %d: %s", other.Code, other.Body.String())
+	}
+
+	// Requests with an unknown API key are rejected without using the key
+	if recorder := requestAs("sk_test_unknown", "POST", "/transaction", body, "Idempotency-Key", key); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+// TestIdempotentPanic verifies that the key of a request whose handler panicked can be retried
+func TestIdempotentPanic(t *testing.T) {
+	key := primitive.NewObjectID().Hex()
+
+	// serve handles a request with the key through the given handler
+	serve := func(next http.HandlerFunc) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/transaction", strings.NewReader(`{"amount": 1.25}`))
+		request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("API_KEY")))
+		request.Header.Add("Idempotency-Key", key)
+		recorder := httptest.NewRecorder()
+		handler.Idempotent(handler.NativeErrors, next)(recorder, request)
+		return recorder
+	}
+
+	// The panic is passed on after the key is released
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the panic to be passed on")
+			}
+		}()
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+	}()
+
+	// The retry is handled instead of being answered with a conflict
+	retry := serve(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	if retry.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusCreated, retry.Code, retry.Body.String())
+	}
+}
+
+// TestIdempotencyLease verifies that a key left pending, as by a crash, can be reserved again once
+// its lease ends while completed keys stay reserved
+func TestIdempotencyLease(t *testing.T) {
+	lease := 20 * time.Millisecond
+	record := idempotency.Create(primitive.NewObjectID().Hex(), "fingerprint", time.Hour, lease)
+	if err := idempotency.Reserve(context.TODO(), &record); err != nil {
+		t.Fatalf("Could not reserve key: %v", err)
+	}
+
+	// The key is held during the lease
+	retry := idempotency.Create(record.Key, "fingerprint", time.Hour, lease)
+	if err := idempotency.Reserve(context.TODO(), &retry); !errors.Is(err, idempotency.ErrExists) {
+		t.Fatalf("Expected %v during the lease, got %v", idempotency.ErrExists, err)
+	}
+
+	// The abandoned key is taken over after the lease
+	time.Sleep(2 * lease)
+	retry = idempotency.Create(record.Key, "fingerprint", time.Hour, lease)
+	if err := idempotency.Reserve(context.TODO(), &retry); err != nil {
+		t.Fatalf("Expected the abandoned key to be reserved again, got %v", err)
+	}
+
+	// A completed key stays reserved after its lease
+	if err := idempotency.Complete(context.TODO(), record.Key, http.StatusCreated, "application/json", []byte("{}")); err != nil {
+		t.Fatalf("Could not complete key: %v", err)
+	}
+	time.Sleep(2 * lease)
+	retry = idempotency.Create(record.Key, "fingerprint", time.Hour, lease)
+	if err := idempotency.Reserve(context.TODO(), &retry); !errors.Is(err, idempotency.ErrExists) {
+		t.Errorf("Expected %v for a completed key, got %v", idempotency.ErrExists, err)
+	}
+}
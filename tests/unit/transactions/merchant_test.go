@@ -0,0 +1,100 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newMerchant stores a merchant with a single API key and returns the key
+func newMerchant(t *testing.T, mode merchants.Mode) string {
+	t.Helper()
+
+	merchant := merchants.Create("test")
+	key, _, err := merchant.NewKey(mode, merchant.CreatedAt)
+	if err != nil {
+		t.Fatalf("Could not generate API key: %v", err)
+	}
+	if _, err := merchants.Insert(context.TODO(), &merchant); err != nil {
+		t.Fatalf("Could not store merchant: %v", err)
+	}
+	return key
+}
+
+// TestMerchantScoping verifies that merchants only see and change their own transactions
+func TestMerchantScoping(t *testing.T) {
+	owner, other := newMerchant(t, merchants.ModeLive), newMerchant(t, merchants.ModeTest)
+
+	// Create and pay a transaction as the owner
+	var redirection redirectResponse
+	expectJSON(t, owner, "POST", "/transaction", `{"amount": 5, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, http.StatusCreated, &redirection)
+	uri := fmt.Sprintf("/v1/transactions/%s", path.Base(redirection.URL))
+
+	// The owner can read it, the other merchant can not
+	if recorder := requestAs(owner, "GET", uri, ""); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d for the owner, got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder := requestAs(other, "GET", uri, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for another merchant, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	// Listings only contain the transactions of the caller
+	for key, expected := range map[string]int{owner: 1, other: 0} {
+		var page struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		expectJSON(t, key, "GET", "/v1/transactions", "", http.StatusOK, &page)
+		if len(page.Data) != expected {
+			t.Errorf("Expected %d transactions, got %d", expected, len(page.Data))
+		}
+	}
+
+	// Only the owner can refund
+	if recorder := requestAs(other, "POST", uri+"/refunds", `{}`); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for another merchant, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+// TestUnknownKey verifies that only stored API keys are accepted
+func TestUnknownKey(t *testing.T) {
+	for _, header := range []string{"", "Bearer ", "Bearer sk_test_unknown", "sk_test_transactions"} {
+		request := httptest.NewRequest("GET", "/v1/transactions", nil)
+		request.Header.Add("Authorization", header)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("[%q] Expected status code %d, got %d", header, http.StatusUnauthorized, recorder.Code)
+		}
+	}
+}
+
+// TestTransactionOwner verifies that created transactions record their merchant and mode
+func TestTransactionOwner(t *testing.T) {
+	key := newMerchant(t, merchants.ModeLive)
+	merchant, _, err := merchants.Authenticate(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("Could not authenticate: %v", err)
+	}
+
+	recorder := requestAs(key, "POST", "/transaction", `{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, recorder.Code)
+	}
+	found, err := transactions.List(context.TODO(), transactions.Filter{MerchantID: merchant.ID}, primitive.NilObjectID, 10)
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(found) != 1 || found[0].Mode != string(merchants.ModeLive) || found[0].Amount != money.New(100, "EUR") {
+		t.Errorf("Unexpected transactions for merchant: %+v", found)
+	}
+}
@@ -0,0 +1,272 @@
+package transactions_test
+
+import (
+	"dev-payment-gate/api/router"
+	"dev-payment-gate/utils/model/merchants"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// molliePayment holds the fields of a Mollie payment checked by the tests
+type molliePayment struct {
+	Resource string `json:"resource"`
+	ID       string `json:"id"`
+	Mode     string `json:"mode"`
+	Amount   struct {
+		Currency string `json:"currency"`
+		Value    string `json:"value"`
+	} `json:"amount"`
+	Description  string          `json:"description"`
+	Metadata     json.RawMessage `json:"metadata"`
+	Status       string          `json:"status"`
+	IsCancelable bool            `json:"isCancelable"`
+	PaidAt       *time.Time      `json:"paidAt"`
+	Links        struct {
+		Self     *struct{ Href string } `json:"self"`
+		Checkout *struct{ Href string } `json:"checkout"`
+	} `json:"_links"`
+}
+
+// mollieError holds the fields of a Mollie error checked by the tests
+type mollieError struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Field  string `json:"field"`
+}
+
+// mollieRouter creates a router that emulates the Mollie API
+func mollieRouter(t *testing.T) *mux.Router {
+	t.Setenv("EMULATE", "mollie")
+	return router.Router()
+}
+
+// mollieRequest serves a Mollie API request with the given header name and value pairs and
+// decodes the response into v
+func mollieRequest(t *testing.T, handler http.Handler, key, method, uri, body string, status int, v interface{}, headers ...string) {
+	t.Helper()
+
+	recorder := serveJSON(t, handler, newRequest(key, method, uri, body, headers...), status, v)
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/hal+json" {
+		t.Errorf("Expected a HAL response, got Content-Type: %s", contentType)
+	}
+}
+
+// paymentTransaction returns the ID of the transaction behind a Mollie payment
+func paymentTransaction(t *testing.T, paymentID string) primitive.ObjectID {
+	t.Helper()
+	return providerTransaction(t, "tr_", paymentID)
+}
+
+// TestMolliePayment creates, pays and fetches a payment through the Mollie API
+func TestMolliePayment(t *testing.T) {
+	handler := mollieRouter(t)
+	key := strings.TrimPrefix(newMerchant(t, merchants.ModeTest), "sk_")
+
+	// Collect the webhook calls
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, r.Header.Get("Content-Type")+" "+string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	// Create a payment the way a Mollie client does
+	body := fmt.Sprintf(`{"amount": {"currency": "EUR", "value": "10.00"}, "description": "Order 12", "redirectUrl": "https://shop.test/return?order=12",
+		"webhookUrl": %q, "metadata": {"order_id": "12"}, "method": "ideal", "locale": "nl_NL"}`, server.URL)
+	var created molliePayment
+	mollieRequest(t, handler, key, "POST", "/v2/payments", body, http.StatusCreated, &created)
+	if created.Resource != "payment" || !strings.HasPrefix(created.ID, "tr_") || created.Status != "open" || created.Mode != "test" || !created.IsCancelable {
+		t.Errorf("Unexpected payment: %+v", created)
+	}
+	if created.Amount.Value != "10.00" || created.Amount.Currency != "EUR" || created.Description != "Order 12" || string(created.Metadata) != `{"order_id":"12"}` {
+		t.Errorf("Unexpected payment details: %+v", created)
+	}
+	id := paymentTransaction(t, created.ID)
+	if created.Links.Checkout == nil || !strings.HasSuffix(created.Links.Checkout.Href, "/transaction/"+id.Hex()) {
+		t.Fatalf("Expected a checkout link, got %+v", created.Links.Checkout)
+	}
+
+	// Pay on the checkout page, which redirects like Mollie without changing the URL
+	recorder := postOutcome(id, "paid")
+	var redirection redirectResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&redirection); err != nil {
+		t.Fatalf("Error parsing JSON response: %v", err)
+	}
+	if redirection.URL != "https://shop.test/return?order=12" {
+		t.Errorf("Expected the unchanged redirect URL, got %s", redirection.URL)
+	}
+
+	// The webhook only receives the payment ID as a form
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		count := len(calls)
+		mu.Unlock()
+		if count > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	if len(calls) != 1 || calls[0] != "application/x-www-form-urlencoded id="+created.ID {
+		t.Errorf("Expected a single form with the payment ID, got %q", calls)
+	}
+	mu.Unlock()
+
+	// The payment is fetched to learn the new status
+	var fetched molliePayment
+	mollieRequest(t, handler, key, "GET", "/v2/payments/"+created.ID, "", http.StatusOK, &fetched)
+	if fetched.Status != "paid" || fetched.PaidAt == nil || fetched.IsCancelable || fetched.Links.Checkout != nil {
+		t.Errorf("Unexpected paid payment: %+v", fetched)
+	}
+
+	// Paid payments can not be canceled
+	var e mollieError
+	mollieRequest(t, handler, key, "DELETE", "/v2/payments/"+created.ID, "", http.StatusUnprocessableEntity, &e)
+
+	// Other merchants do not see the payment
+	other := strings.TrimPrefix(newMerchant(t, merchants.ModeTest), "sk_")
+	mollieRequest(t, handler, other, "GET", "/v2/payments/"+created.ID, "", http.StatusNotFound, nil)
+}
+
+// TestMollieErrors verifies that rejected requests are answered with Mollie errors
+func TestMollieErrors(t *testing.T) {
+	handler := mollieRouter(t)
+	key := newMerchant(t, merchants.ModeLive)
+
+	tests := []struct {
+		name   string
+		key    string
+		body   string
+		status int
+		field  string
+	}{
+		{"no key", "", `{}`, http.StatusUnauthorized, ""},
+		{"unknown key", "live_unknown", `{}`, http.StatusUnauthorized, ""},
+		{"malformed JSON", key, `{`, http.StatusBadRequest, ""},
+		{"missing description", key, `{"amount": {"currency": "EUR", "value": "1.00"}, "redirectUrl": "https://shop.test"}`, http.StatusUnprocessableEntity, "description"},
+		{"too many decimals", key, `{"amount": {"currency": "EUR", "value": "1.001"}, "description": "x", "redirectUrl": "https://shop.test"}`, http.StatusUnprocessableEntity, "amount.value"},
+		{"unknown currency", key, `{"amount": {"currency": "XXX", "value": "1.00"}, "description": "x", "redirectUrl": "https://shop.test"}`, http.StatusUnprocessableEntity, "amount.currency"},
+		{"missing redirect", key, `{"amount": {"currency": "EUR", "value": "1.00"}, "description": "x"}`, http.StatusUnprocessableEntity, "redirectUrl"},
+	}
+	for _, test := range tests {
+		var e mollieError
+		mollieRequest(t, handler, test.key, "POST", "/v2/payments", test.body, test.status, &e)
+		if e.Status != test.status || e.Title != http.StatusText(test.status) || e.Field != test.field {
+			t.Errorf("%s: unexpected error %+v", test.name, e)
+		}
+	}
+
+	mollieRequest(t, handler, key, "GET", "/v2/payments/tr_unknown", "", http.StatusNotFound, nil)
+
+	// The Mollie API is only served when it is emulated
+	t.Setenv("EMULATE", "")
+	if recorder := requestAs(key, "GET", "/v2/payments", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d without emulation, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+// TestMollieIdempotency verifies that errors of the Idempotency-Key handling are Mollie errors
+func TestMollieIdempotency(t *testing.T) {
+	handler := mollieRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+	idempotencyKey := primitive.NewObjectID().Hex()
+	body := `{"amount": {"currency": "EUR", "value": "1.00"}, "description": "Order 13", "redirectUrl": "https://shop.test"}`
+
+	// A retry gets the same payment
+	var created, retried molliePayment
+	mollieRequest(t, handler, key, "POST", "/v2/payments", body, http.StatusCreated, &created, "Idempotency-Key", idempotencyKey)
+	mollieRequest(t, handler, key, "POST", "/v2/payments", body, http.StatusCreated, &retried, "Idempotency-Key", idempotencyKey)
+	if retried.ID != created.ID {
+		t.Errorf("Expected payment %s to be replayed, got %s", created.ID, retried.ID)
+	}
+
+	tests := []struct {
+		name           string
+		idempotencyKey string
+		body           string
+		status         int
+	}{
+		{"reused key", idempotencyKey, strings.Replace(body, "Order 13", "Order 14", 1), http.StatusUnprocessableEntity},
+		{"oversized key", strings.Repeat("k", 256), body, http.StatusBadRequest},
+		{"oversized body", primitive.NewObjectID().Hex(), strings.Repeat(" ", 64<<10+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		var e mollieError
+		mollieRequest(t, handler, key, "POST", "/v2/payments", test.body, test.status, &e, "Idempotency-Key", test.idempotencyKey)
+		if e.Status != test.status || e.Title != http.StatusText(test.status) {
+			t.Errorf("%s: unexpected error %+v", test.name, e)
+		}
+	}
+}
+
+// TestMollieListAndCancel pages through payments and cancels one
+func TestMollieListAndCancel(t *testing.T) {
+	handler := mollieRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+
+	// Create three payments, one of them with a cancel URL
+	var ids []string
+	for i := 0; i < 3; i++ {
+		var created molliePayment
+		body := fmt.Sprintf(`{"amount": {"currency": "EUR", "value": "%d.00"}, "description": "Order %d", "redirectUrl": "https://shop.test/return", "cancelUrl": "https://shop.test/cancel"}`, i+1, i)
+		mollieRequest(t, handler, key, "POST", "/v2/payments", body, http.StatusCreated, &created)
+		ids = append(ids, created.ID)
+	}
+
+	// The newest payments come first, the next link continues where the page ended
+	var list struct {
+		Count    int `json:"count"`
+		Embedded struct {
+			Payments []molliePayment `json:"payments"`
+		} `json:"_embedded"`
+		Links struct {
+			Next *struct{ Href string } `json:"next"`
+		} `json:"_links"`
+	}
+	mollieRequest(t, handler, key, "GET", "/v2/payments?limit=2", "", http.StatusOK, &list)
+	if list.Count != 2 || list.Embedded.Payments[0].ID != ids[2] || list.Embedded.Payments[1].ID != ids[1] || list.Links.Next == nil {
+		t.Fatalf("Unexpected first page: %+v", list)
+	}
+	next, err := url.Parse(list.Links.Next.Href)
+	if err != nil {
+		t.Fatalf("Invalid next link: %v", err)
+	}
+	list.Links.Next = nil
+	mollieRequest(t, handler, key, "GET", next.RequestURI(), "", http.StatusOK, &list)
+	if list.Count != 1 || list.Embedded.Payments[0].ID != ids[0] || list.Links.Next != nil {
+		t.Errorf("Unexpected last page: %+v", list)
+	}
+	mollieRequest(t, handler, key, "GET", "/v2/payments?limit=0", "", http.StatusBadRequest, nil)
+
+	// Canceling through the API ends the payment
+	var canceled molliePayment
+	mollieRequest(t, handler, key, "DELETE", "/v2/payments/"+ids[0], "", http.StatusOK, &canceled)
+	if canceled.Status != "canceled" || canceled.IsCancelable {
+		t.Errorf("Unexpected canceled payment: %+v", canceled)
+	}
+
+	// Canceling on the checkout page goes to the cancel URL
+	recorder := postOutcome(paymentTransaction(t, ids[1]), "canceled")
+	var redirection redirectResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&redirection); err != nil {
+		t.Fatalf("Error parsing JSON response: %v", err)
+	}
+	if redirection.URL != "https://shop.test/cancel" {
+		t.Errorf("Expected the cancel URL, got %s", redirection.URL)
+	}
+}
@@ -0,0 +1,214 @@
+package transactions_test
+
+import (
+	"dev-payment-gate/api/openapi"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// contractRequest serves a request and fails the test when the response does not match the OpenAPI
+// document or has an unexpected status code. Request bodies are validated as well unless the request
+// is expected to be rejected.
+func contractRequest(t *testing.T, document *openapi.Document, key, method, uri, body string, status int, v interface{}) {
+	t.Helper()
+
+	path := strings.SplitN(uri, "?", 2)[0]
+	if body != "" && status < http.StatusBadRequest {
+		if err := document.ValidateRequest(method, path, "application/json", []byte(body)); err != nil {
+			t.Fatalf("[%s %s] Request does not match the OpenAPI document: %v", method, uri, err)
+		}
+	}
+
+	recorder := expectJSON(t, key, method, uri, body, status, v)
+	if err := document.ValidateResponse(method, path, recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes()); err != nil {
+		t.Fatalf("[%s %s] Response does not match the OpenAPI document: %v\n%s", method, uri, err, recorder.Body.String())
+	}
+}
+
+// loadDocument parses the OpenAPI document served by the gate
+func loadDocument(t *testing.T) *openapi.Document {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected content type application/json, got %s", contentType)
+	}
+
+	document, err := openapi.Parse(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Could not parse the served OpenAPI document: %v", err)
+	}
+	return document
+}
+
+// TestOpenAPIRoutes verifies that every route of the native API is in the OpenAPI document
+func TestOpenAPIRoutes(t *testing.T) {
+	document := loadDocument(t)
+
+	// Pages, static files and the dashboard are not part of the API
+	undocumented := []string{"/static/", "/admin", "/transaction/js/", "/docs"}
+
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		for _, prefix := range undocumented {
+			if strings.HasPrefix(template, prefix) {
+				return nil
+			}
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			// Fill in the variables so the path matches the templates of the document
+			path := template
+			for strings.Contains(path, "{") {
+				path = path[:strings.Index(path, "{")] + "x" + path[strings.Index(path, "}")+1:]
+			}
+			if err := document.ValidateResponse(method, path, http.StatusOK, "", nil); errors.Is(err, openapi.ErrUnknownOperation) {
+				t.Errorf("Route %s %s is not in the OpenAPI document", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not walk the routes: %v", err)
+	}
+}
+
+// TestOpenAPIExamples verifies that the example request bodies of the document match their schemas
+func TestOpenAPIExamples(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Spec, &spec); err != nil {
+		t.Fatalf("Could not parse the OpenAPI document: %v", err)
+	}
+	document := loadDocument(t)
+
+	examples := 0
+	for path, item := range spec.Paths {
+		for method, raw := range item {
+			var operation struct {
+				RequestBody struct {
+					Content map[string]struct {
+						Example json.RawMessage `json:"example"`
+					} `json:"content"`
+				} `json:"requestBody"`
+			}
+			if method == "parameters" || json.Unmarshal(raw, &operation) != nil {
+				continue
+			}
+			media, ok := operation.RequestBody.Content["application/json"]
+			if !ok || media.Example == nil {
+				continue
+			}
+			examples++
+			if err := document.ValidateRequest(strings.ToUpper(method), path, "application/json", media.Example); err != nil {
+				t.Errorf("[%s %s] Example does not match the schema: %v", method, path, err)
+			}
+		}
+	}
+	if examples == 0 {
+		t.Errorf("Expected the document to have example request bodies")
+	}
+}
+
+// TestOpenAPIContract runs the native API through a payment, capture, void, cancel, refund and webhook replay
+// and validates every response against the OpenAPI document
+func TestOpenAPIContract(t *testing.T) {
+	document := loadDocument(t)
+	key := os.Getenv("API_KEY")
+
+	// Create a transaction and reject invalid input
+	var created redirectResponse
+	contractRequest(t, document, key, "POST", "/transaction", `{"amount": {"currency": "EUR", "value": "10.00"}, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, http.StatusCreated, &created)
+	contractRequest(t, document, key, "POST", "/transaction", `{"amount": -1}`, http.StatusBadRequest, nil)
+	contractRequest(t, document, "", "POST", "/transaction", `{"amount": 10, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, http.StatusUnauthorized, nil)
+	id := created.URL[strings.LastIndex(created.URL, "/")+1:]
+
+	// Pay on the checkout page
+	contractRequest(t, document, "", "POST", "/transaction/"+id, `{"outcome": "paid"}`, http.StatusSeeOther, nil)
+	contractRequest(t, document, "", "POST", "/transaction/"+id, `{"outcome": "paid"}`, http.StatusConflict, nil)
+	contractRequest(t, document, "", "POST", "/transaction/"+primitive.NewObjectID().Hex(), `{"outcome": "paid"}`, http.StatusNotFound, nil)
+
+	// Read the transaction back, alone and in the list
+	contractRequest(t, document, key, "GET", "/v1/transactions/"+id, "", http.StatusOK, nil)
+	contractRequest(t, document, key, "GET", "/v1/transactions/"+primitive.NewObjectID().Hex(), "", http.StatusNotFound, nil)
+	contractRequest(t, document, key, "GET", "/v1/transactions/not-an-id", "", http.StatusBadRequest, nil)
+	contractRequest(t, document, key, "GET", "/v1/transactions?status=paid&limit=5", "", http.StatusOK, nil)
+	contractRequest(t, document, key, "GET", "/v1/transactions?limit=-1", "", http.StatusBadRequest, nil)
+
+	// Refund part of the payment and replay its webhook
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+id+"/refunds", `{"amount": {"currency": "EUR", "value": "2.50"}, "reason": "Damaged"}`, http.StatusCreated, nil)
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+id+"/refunds", `{"amount": {"currency": "EUR", "value": "20.00"}}`, http.StatusBadRequest, nil)
+	var webhooks []struct {
+		ID string `json:"id"`
+	}
+	contractRequest(t, document, key, "GET", "/v1/transactions/"+id+"/webhooks", "", http.StatusOK, &webhooks)
+	if len(webhooks) == 0 {
+		t.Fatalf("Expected webhook deliveries for the transaction")
+	}
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+id+"/webhooks/"+webhooks[0].ID+"/replay", "", http.StatusAccepted, nil)
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+id+"/webhooks/"+primitive.NewObjectID().Hex()+"/replay", "", http.StatusNotFound, nil)
+
+	// Capture part of one authorization and void another
+	captured := authorizedTransaction(t, money.New(1000, "EUR")).Hex()
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+captured+"/capture", `{"amount": {"currency": "EUR", "value": "5.00"}}`, http.StatusOK, nil)
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+captured+"/void", "", http.StatusConflict, nil)
+	voided := authorizedTransaction(t, money.New(1000, "EUR")).Hex()
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+voided+"/void", "", http.StatusOK, nil)
+	contractRequest(t, document, key, "GET", "/v1/transactions/"+voided, "", http.StatusOK, nil)
+
+	// Cancel a transaction before it is paid
+	var open redirectResponse
+	contractRequest(t, document, key, "POST", "/transaction", `{"amount": 10, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, http.StatusCreated, &open)
+	canceled := open.URL[strings.LastIndex(open.URL, "/")+1:]
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+canceled+"/cancel", "", http.StatusOK, nil)
+	contractRequest(t, document, key, "POST", "/v1/transactions/"+canceled+"/cancel", "", http.StatusConflict, nil)
+
+	// The checkout page and the status stream are documented by content type
+	contractRequest(t, document, "", "GET", "/transaction/"+voided, "", http.StatusOK, nil)
+	contractRequest(t, document, "", "GET", "/transaction/"+voided+"/events", "", http.StatusOK, nil)
+}
+
+// TestOpenAPIAdminContract runs the admin API through the lifecycle of a merchant and its keys and
+// validates every response against the OpenAPI document
+func TestOpenAPIAdminContract(t *testing.T) {
+	t.Setenv("ADMIN_KEY", "admin-secret")
+	const admin = "admin-secret"
+	document := loadDocument(t)
+
+	contractRequest(t, document, "", "GET", "/v1/admin/merchants", "", http.StatusUnauthorized, nil)
+	contractRequest(t, document, admin, "GET", "/v1/admin/merchants", "", http.StatusOK, nil)
+
+	// Create a merchant and change its settings
+	var merchant adminMerchant
+	contractRequest(t, document, admin, "POST", "/v1/admin/merchants", `{"name": "Shop", "mode": "test"}`, http.StatusCreated, &merchant)
+	contractRequest(t, document, admin, "POST", "/v1/admin/merchants", `{"mode": "test"}`, http.StatusBadRequest, nil)
+	contractRequest(t, document, admin, "PATCH", "/v1/admin/merchants/"+merchant.ID, `{"webhook_insecure": true}`, http.StatusOK, nil)
+	contractRequest(t, document, admin, "PATCH", "/v1/admin/merchants/"+primitive.NewObjectID().Hex(), `{"webhook_insecure": true}`, http.StatusNotFound, nil)
+
+	// Create, expire, rotate and revoke keys
+	var created adminKey
+	contractRequest(t, document, admin, "POST", "/v1/admin/merchants/"+merchant.ID+"/keys", `{"mode": "live"}`, http.StatusCreated, &created)
+	keyURI := "/v1/admin/merchants/" + merchant.ID + "/keys/" + created.ID
+	contractRequest(t, document, admin, "POST", keyURI+"/expire", `{"expires_at": "2030-01-01T00:00:00Z"}`, http.StatusOK, nil)
+	var rotated adminKey
+	contractRequest(t, document, admin, "POST", keyURI+"/rotate", `{"overlap": "1h"}`, http.StatusCreated, &rotated)
+	contractRequest(t, document, admin, "POST", keyURI+"/revoke", "", http.StatusOK, nil)
+	contractRequest(t, document, admin, "POST", keyURI+"/revoke", "", http.StatusConflict, nil)
+}
@@ -0,0 +1,128 @@
+package transactions_test
+
+import (
+	"bytes"
+	"context"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createTransaction creates a transaction through the API and returns its ID
+func createTransaction(t *testing.T, input transactions.TransactionInput) primitive.ObjectID {
+	t.Helper()
+
+	// Marshal the input into JSON
+	body, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Error marshaling transactionInput: %v", err)
+	}
+
+	// Serve the request using the router
+	request := httptest.NewRequest("POST", "/transaction", bytes.NewBuffer(body))
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("API_KEY")))
+	request.Header.Add("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, recorder.Code)
+	}
+
+	// Take the ID from the end of the transaction URL
+	var redirection redirectResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&redirection); err != nil {
+		t.Fatalf("Error parsing JSON response: %v", err)
+	}
+	id, err := primitive.ObjectIDFromHex(redirection.URL[strings.LastIndex(redirection.URL, "/")+1:])
+	if err != nil {
+		t.Fatalf("Invalid ObjectID in URL: %s", redirection.URL)
+	}
+	return id
+}
+
+// postOutcome submits a payment outcome like the checkout page does
+func postOutcome(id primitive.ObjectID, outcome string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"outcome": %q}`, outcome)
+	request := httptest.NewRequest("POST", fmt.Sprintf("/transaction/%s", id.Hex()), strings.NewReader(body))
+	request.Header.Add("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestOutcomes verifies that every outcome on the checkout page results in the matching status
+func TestOutcomes(t *testing.T) {
+	for _, outcome := range []string{"paid", "failed", "canceled", "expired"} {
+		id := createTransaction(t, transactions.TransactionInput{Amount: money.New(250, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl/done"})
+
+		// Submit the outcome
+		recorder := postOutcome(id, outcome)
+		if recorder.Code != http.StatusSeeOther {
+			t.Errorf("[%s] Expected status code %d, got %d", outcome, http.StatusSeeOther, recorder.Code)
+			continue
+		}
+
+		// Verify that the redirect carries the status
+		var redirection redirectResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &redirection); err != nil {
+			t.Errorf("[%s] Error parsing JSON response: %v", outcome, err)
+		}
+		if expectedURL := fmt.Sprintf("https://test.nl/done?status=%s", outcome); redirection.URL != expectedURL {
+			t.Errorf("[%s] Expected %s, but got: %s", outcome, expectedURL, redirection.URL)
+		}
+
+		// Verify the stored status
+		transaction, err := transactions.GetByID(context.TODO(), id)
+		if err != nil {
+			t.Fatalf("[%s] Could not fetch transaction: %v", outcome, err)
+		}
+		if string(transaction.Status) != outcome {
+			t.Errorf("[%s] Expected status %s, got %s", outcome, outcome, transaction.Status)
+		}
+	}
+}
+
+// TestUnknownOutcome verifies that outcomes outside of the checkout page are rejected
+func TestUnknownOutcome(t *testing.T) {
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(250, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "refunded"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestPendingThenPaid verifies that a pending payment settles as paid in the background
+func TestPendingThenPaid(t *testing.T) {
+	os.Setenv("PENDING_DELAY", "10ms")
+	defer os.Unsetenv("PENDING_DELAY")
+
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(250, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "pending"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+
+	// Wait for the transaction to settle
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		transaction, err := transactions.GetByID(context.TODO(), id)
+		if err != nil {
+			t.Fatalf("Could not fetch transaction: %v", err)
+		}
+		if transaction.Status == transactions.StatusPaid {
+			if transaction.ChangedAt(transactions.StatusPending).IsZero() {
+				t.Error("Missing timestamp for the pending transition")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Pending transaction was not settled as paid")
+}
@@ -0,0 +1,209 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/internal/expiry"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// paidTransaction creates a transaction through the API and pays it
+func paidTransaction(t *testing.T, amount money.Money) primitive.ObjectID {
+	t.Helper()
+
+	id := createTransaction(t, transactions.TransactionInput{Amount: amount, WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "paid"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	return id
+}
+
+// waitForRefunds waits until none of the refunds of a transaction are pending
+func waitForRefunds(t *testing.T, id primitive.ObjectID) *transactions.Transaction {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		transaction, err := transactions.GetByID(context.TODO(), id)
+		if err != nil {
+			t.Fatalf("Could not fetch transaction: %v", err)
+		}
+		pending := false
+		for _, refund := range transaction.Refunds {
+			pending = pending || refund.Status == transactions.RefundPending
+		}
+		if !pending {
+			return transaction
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Refunds were not settled")
+	return nil
+}
+
+// TestPartialRefunds verifies that several partial refunds are bounded by the paid amount
+func TestPartialRefunds(t *testing.T) {
+	os.Setenv("PENDING_DELAY", "10ms")
+	defer os.Unsetenv("PENDING_DELAY")
+	id := paidTransaction(t, money.New(250, "EUR"))
+
+	// Two partial refunds add up to the paid amount, a failed refund does not count
+	for _, body := range []string{`{"amount": 1, "reason": "damaged"}`, `{"amount": 1.5, "outcome": "failed"}`, `{"amount": 1.5}`} {
+		if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), body); recorder.Code != http.StatusCreated {
+			t.Fatalf("[%s] Expected status code %d, got %d: %s", body, http.StatusCreated, recorder.Code, recorder.Body.String())
+		}
+		waitForRefunds(t, id)
+	}
+
+	// Nothing is left to refund
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{"amount": 0.01}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	// Verify the stored refunds
+	transaction := waitForRefunds(t, id)
+	if len(transaction.Refunds) != 3 {
+		t.Fatalf("Expected 3 refunds, got %d", len(transaction.Refunds))
+	}
+	for i, status := range []transactions.RefundStatus{transactions.RefundSucceeded, transactions.RefundFailed, transactions.RefundSucceeded} {
+		if transaction.Refunds[i].Status != status {
+			t.Errorf("Expected refund %d to be %s, got %s", i, status, transaction.Refunds[i].Status)
+		}
+	}
+	if refunded := transaction.RefundedAmount(); refunded != money.New(250, "EUR") {
+		t.Errorf("Expected 2.50 to be refunded, got %s", refunded)
+	}
+
+	// Every refund was announced and settled through the webhook queue
+	webhooks, err := deliveries.ListByTransaction(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not list webhook deliveries: %v", err)
+	}
+	events := map[string]int{}
+	for _, delivery := range webhooks {
+		events[delivery.Event]++
+	}
+	if events["refund.pending"] != 3 || events["refund.succeeded"] != 2 || events["refund.failed"] != 1 {
+		t.Errorf("Unexpected webhook events: %v", events)
+	}
+}
+
+// TestFullRefund verifies that a refund without an amount refunds everything that is left
+func TestFullRefund(t *testing.T) {
+	id := paidTransaction(t, money.New(995, "EUR"))
+
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{"amount": 2.5}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, recorder.Code)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, recorder.Code)
+	}
+
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if len(transaction.Refunds) != 2 || transaction.Refunds[1].Amount != money.New(745, "EUR") {
+		t.Errorf("Expected a second refund of 7.45, got %+v", transaction.Refunds)
+	}
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestRefundRejected verifies the refunds that are not allowed
+func TestRefundRejected(t *testing.T) {
+	open := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: "http://127.0.0.1:1", RedirectURL: "https://test.nl"})
+	paid := paidTransaction(t, money.New(100, "EUR"))
+
+	tests := map[string]struct {
+		id     primitive.ObjectID
+		body   string
+		status int
+	}{
+		"unpaid":   {open, `{}`, http.StatusConflict},
+		"missing":  {primitive.NewObjectID(), `{}`, http.StatusNotFound},
+		"exceeded": {paid, `{"amount": 1.01}`, http.StatusBadRequest},
+		"negative": {paid, `{"amount": -1}`, http.StatusBadRequest},
+		"currency": {paid, `{"amount": {"currency": "USD", "value": "1"}}`, http.StatusBadRequest},
+		"outcome":  {paid, `{"outcome": "pending"}`, http.StatusBadRequest},
+	}
+
+	for name, test := range tests {
+		if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", test.id.Hex()), test.body); recorder.Code != test.status {
+			t.Errorf("[%s] Expected status code %d, got %d", name, test.status, recorder.Code)
+		}
+	}
+}
+
+// TestAddRefund verifies the refund bounds on the transaction itself
+func TestAddRefund(t *testing.T) {
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(500, "EUR")})
+	if _, err := transaction.AddRefund(nil, "", time.Now()); !errors.Is(err, transactions.ErrNotRefundable) {
+		t.Errorf("Expected ErrNotRefundable, got: %v", err)
+	}
+
+	transaction.Transition(transactions.StatusPaid, time.Now())
+	usd := money.New(100, "USD")
+	if _, err := transaction.AddRefund(&usd, "", time.Now()); !errors.Is(err, transactions.ErrCurrencyMismatch) {
+		t.Errorf("Expected ErrCurrencyMismatch, got: %v", err)
+	}
+
+	// A failed refund frees its amount again
+	refund, err := transaction.AddRefund(nil, "", time.Now())
+	if err != nil {
+		t.Fatalf("Could not refund: %v", err)
+	}
+	if transaction.RefundableAmount().Value != 0 {
+		t.Errorf("Expected nothing to be refundable, got %s", transaction.RefundableAmount())
+	}
+	if err := refund.Settle(transactions.RefundFailed, time.Now()); err != nil {
+		t.Fatalf("Could not fail the refund: %v", err)
+	}
+	if transaction.RefundableAmount().Value != 500 {
+		t.Errorf("Expected 5.00 to be refundable, got %s", transaction.RefundableAmount())
+	}
+	if err := refund.Settle(transactions.RefundSucceeded, time.Now()); !errors.Is(err, transactions.ErrIllegalTransition) {
+		t.Errorf("Expected ErrIllegalTransition, got: %v", err)
+	}
+}
+
+// TestSweepPendingRefunds verifies that the sweeper settles refunds that stayed pending to their
+// chosen outcome, as after a restart
+func TestSweepPendingRefunds(t *testing.T) {
+	id := paidTransaction(t, money.New(250, "EUR"))
+	t.Setenv("PENDING_DELAY", "1h")
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/refunds", id.Hex()), `{"amount": 1, "outcome": "failed"}`); recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+
+	// Nothing happens before the pending delay passed
+	if settled, err := expiry.SweepPending(context.TODO(), time.Now()); err != nil || settled != 0 {
+		t.Fatalf("Expected nothing to settle, got %d (%v)", settled, err)
+	}
+
+	// Sweep as if the pending delay passed, the refund settles to its outcome
+	if _, err := expiry.SweepPending(context.TODO(), time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("Could not sweep: %v", err)
+	}
+	transaction, err := transactions.GetByID(context.TODO(), id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if len(transaction.Refunds) != 1 || transaction.Refunds[0].Status != transactions.RefundFailed {
+		t.Fatalf("Expected a failed refund, got %+v", transaction.Refunds)
+	}
+	if events := webhookEvents(t, id); strings.Join(events, ",") != "transaction.paid,refund.pending,refund.failed" {
+		t.Errorf("Expected the refund to be settled through the webhook queue, got: %v", events)
+	}
+}
@@ -0,0 +1,68 @@
+package transactions_test
+
+import (
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTransitions verifies which status changes the lifecycle allows
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		from  transactions.Status
+		to    transactions.Status
+		legal bool
+	}{
+		{transactions.StatusOpen, transactions.StatusPending, true},
+		{transactions.StatusOpen, transactions.StatusPaid, true},
+		{transactions.StatusOpen, transactions.StatusExpired, true},
+		{transactions.StatusPending, transactions.StatusPaid, true},
+		{transactions.StatusPending, transactions.StatusFailed, true},
+		{transactions.StatusPending, transactions.StatusOpen, false},
+		{transactions.StatusPaid, transactions.StatusCanceled, false},
+		{transactions.StatusFailed, transactions.StatusPaid, false},
+		{transactions.StatusExpired, transactions.StatusOpen, false},
+		{transactions.StatusOpen, transactions.StatusOpen, false},
+	}
+
+	for _, test := range tests {
+		transaction := transactions.Transaction{Status: test.from}
+		err := transaction.Transition(test.to, time.Now())
+
+		if test.legal && err != nil {
+			t.Errorf("Expected %s -> %s to be legal, got: %v", test.from, test.to, err)
+		}
+		if !test.legal && !errors.Is(err, transactions.ErrIllegalTransition) {
+			t.Errorf("Expected %s -> %s to be illegal, got: %v", test.from, test.to, err)
+		}
+	}
+}
+
+// TestHistory verifies that every transition is recorded with its timestamp
+func TestHistory(t *testing.T) {
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(100, "EUR")})
+	pendingAt := time.Now().Add(time.Second)
+	paidAt := pendingAt.Add(time.Second)
+
+	if err := transaction.Transition(transactions.StatusPending, pendingAt); err != nil {
+		t.Fatalf("Could not move to pending: %v", err)
+	}
+	if err := transaction.Transition(transactions.StatusPaid, paidAt); err != nil {
+		t.Fatalf("Could not move to paid: %v", err)
+	}
+
+	if len(transaction.History) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(transaction.History))
+	}
+	if !transaction.ChangedAt(transactions.StatusPending).Equal(pendingAt) {
+		t.Errorf("Wrong pending timestamp: %v", transaction.ChangedAt(transactions.StatusPending))
+	}
+	if !transaction.ChangedAt(transactions.StatusPaid).Equal(paidAt) {
+		t.Errorf("Wrong paid timestamp: %v", transaction.ChangedAt(transactions.StatusPaid))
+	}
+	if !transaction.Status.Final() {
+		t.Error("Expected paid to be a final status")
+	}
+}
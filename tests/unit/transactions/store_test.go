@@ -0,0 +1,200 @@
+package transactions_test
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMemoryStore verifies the basic operations of the in-memory store
+func TestMemoryStore(t *testing.T) {
+	testStore(t, transactions.NewMemoryStore())
+	testList(t, transactions.NewMemoryStore())
+}
+
+// TestBoltStore verifies the basic operations of the embedded file-backed store
+func TestBoltStore(t *testing.T) {
+	// Open a fresh database file unless the suite itself already runs on bolt
+	if database.GetBolt() == nil {
+		if err := database.OpenBolt(filepath.Join(t.TempDir(), "test.db")); err != nil {
+			t.Fatalf("Could not open embedded database: %v", err)
+		}
+		defer database.CloseBolt()
+	}
+
+	store, err := transactions.NewBoltStore()
+	if err != nil {
+		t.Fatalf("Could not create bolt store: %v", err)
+	}
+	testStore(t, store)
+	testList(t, store)
+}
+
+// testStore runs the operations every TransactionStore has to support
+func testStore(t *testing.T, store transactions.TransactionStore) {
+	ctx := context.TODO()
+
+	// Insert a transaction and read it back
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(150, "EUR"), RedirectURL: "https://test.nl"})
+	id, err := store.Insert(ctx, &transaction)
+	if err != nil {
+		t.Fatalf("Could not insert transaction: %v", err)
+	}
+	stored, err := store.GetByID(ctx, *id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if stored.ID != *id || stored.RedirectURL != transaction.RedirectURL {
+		t.Errorf("Stored transaction does not match the inserted one: %+v", stored)
+	}
+
+	// Update the transaction and make sure stale copies are rejected
+	stale := *stored
+	if err := stored.Transition(transactions.StatusPaid, time.Now()); err != nil {
+		t.Fatalf("Could not transition transaction: %v", err)
+	}
+	if err := store.Update(ctx, stored); err != nil {
+		t.Fatalf("Could not update transaction: %v", err)
+	}
+	if err := store.Update(ctx, &stale); !errors.Is(err, transactions.ErrConflict) {
+		t.Errorf("Expected ErrConflict when updating a stale copy, got: %v", err)
+	}
+	updated, err := store.GetByID(ctx, *id)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction: %v", err)
+	}
+	if updated.Status != transactions.StatusPaid || updated.Version != stored.Version {
+		t.Errorf("Update was not stored: %+v", updated)
+	}
+
+	// Delete the transaction and make sure it is gone
+	if err := store.Delete(ctx, *id); err != nil {
+		t.Fatalf("Could not delete transaction: %v", err)
+	}
+	if _, err := store.GetByID(ctx, *id); !errors.Is(err, transactions.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after deletion, got: %v", err)
+	}
+	if err := store.Delete(ctx, *id); !errors.Is(err, transactions.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when deleting twice, got: %v", err)
+	}
+}
+
+// TestMemoryStoreConcurrency inserts and reads transactions from many goroutines at once
+func TestMemoryStoreConcurrency(t *testing.T) {
+	store := transactions.NewMemoryStore()
+	ctx := context.TODO()
+
+	var wg sync.WaitGroup
+	ids := make(chan primitive.ObjectID, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(100, "EUR")})
+			id, err := store.Insert(ctx, &transaction)
+			if err != nil {
+				t.Errorf("Could not insert transaction: %v", err)
+				return
+			}
+			if _, err := store.GetByID(ctx, *id); err != nil {
+				t.Errorf("Could not fetch transaction: %v", err)
+			}
+			ids <- *id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	// Every insert should have produced a unique ID
+	seen := make(map[primitive.ObjectID]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("Duplicate ID generated: %s", id.Hex())
+		}
+		seen[id] = true
+	}
+}
+
+// testList verifies filtering and cursor pagination of a TransactionStore
+func testList(t *testing.T, store transactions.TransactionStore) {
+	ctx := context.TODO()
+
+	// Insert transactions with alternating references and increasing amounts. The references and
+	// creation time keep them apart from transactions stored by other tests.
+	start := time.Now().Truncate(time.Millisecond)
+	even, odd := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	var ids []primitive.ObjectID
+	for i := 0; i < 6; i++ {
+		reference := even
+		if i%2 == 1 {
+			reference = odd
+		}
+		transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(int64(i)*100, "EUR"), Reference: reference})
+		id, err := store.Insert(ctx, &transaction)
+		if err != nil {
+			t.Fatalf("Could not insert transaction: %v", err)
+		}
+		ids = append(ids, *id)
+	}
+
+	// Walk all transactions two at a time, newest first
+	var cursor primitive.ObjectID
+	var listed []primitive.ObjectID
+	for {
+		page, err := store.List(ctx, transactions.Filter{CreatedFrom: &start}, cursor, 2)
+		if err != nil {
+			t.Fatalf("Could not list transactions: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, transaction := range page {
+			listed = append(listed, transaction.ID)
+		}
+		cursor = page[len(page)-1].ID
+	}
+	if len(listed) != len(ids) {
+		t.Fatalf("Expected %d transactions, got %d", len(ids), len(listed))
+	}
+	for i := range listed {
+		if listed[i] != ids[len(ids)-1-i] {
+			t.Errorf("Expected newest first, got %s at position %d", listed[i].Hex(), i)
+		}
+	}
+
+	// Filter on reference and amount range
+	min, max := int64(100), int64(400)
+	found, err := store.List(ctx, transactions.Filter{Reference: odd, MinAmount: &min, MaxAmount: &max}, primitive.NilObjectID, 10)
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(found) != 2 || found[0].ID != ids[3] || found[1].ID != ids[1] {
+		t.Errorf("Unexpected transactions for reference and amount filter: %+v", found)
+	}
+
+	// Continue a reference listing from a cursor
+	found, err = store.List(ctx, transactions.Filter{Reference: even}, ids[4], 10)
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(found) != 2 || found[0].ID != ids[2] || found[1].ID != ids[0] {
+		t.Errorf("Unexpected transactions for reference after cursor: %+v", found)
+	}
+
+	// Filter on status
+	found, err = store.List(ctx, transactions.Filter{Statuses: []transactions.Status{transactions.StatusPaid}, CreatedFrom: &start}, primitive.NilObjectID, 10)
+	if err != nil {
+		t.Fatalf("Could not list transactions: %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("Expected no paid transactions, got %d", len(found))
+	}
+}
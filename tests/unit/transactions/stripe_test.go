@@ -0,0 +1,424 @@
+package transactions_test
+
+import (
+	"dev-payment-gate/api/router"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/pkg/signature"
+	"dev-payment-gate/utils/model/merchants"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stripePaymentIntent holds the fields of a Stripe payment intent checked by the tests
+type stripePaymentIntent struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Amount           int64             `json:"amount"`
+	AmountCapturable int64             `json:"amount_capturable"`
+	AmountReceived   int64             `json:"amount_received"`
+	Currency         string            `json:"currency"`
+	Description      *string           `json:"description"`
+	Livemode         bool              `json:"livemode"`
+	Metadata         map[string]string `json:"metadata"`
+	Status           string            `json:"status"`
+	NextAction       *struct {
+		RedirectToURL struct {
+			URL string `json:"url"`
+		} `json:"redirect_to_url"`
+	} `json:"next_action"`
+}
+
+// stripeSession holds the fields of a Stripe checkout session checked by the tests
+type stripeSession struct {
+	ID                string  `json:"id"`
+	Object            string  `json:"object"`
+	AmountTotal       int64   `json:"amount_total"`
+	Currency          string  `json:"currency"`
+	ClientReferenceID *string `json:"client_reference_id"`
+	PaymentIntent     string  `json:"payment_intent"`
+	PaymentStatus     string  `json:"payment_status"`
+	Status            string  `json:"status"`
+	URL               *string `json:"url"`
+}
+
+// stripeError holds the fields of a Stripe error checked by the tests
+type stripeError struct {
+	Error struct {
+		Type  string `json:"type"`
+		Code  string `json:"code"`
+		Param string `json:"param"`
+	} `json:"error"`
+}
+
+// stripeRouter creates a router that emulates the Stripe API
+func stripeRouter(t *testing.T) *mux.Router {
+	t.Setenv("EMULATE", "mollie, stripe")
+	return router.Router()
+}
+
+// stripeRequest serves a form encoded Stripe API request with the given header name and value
+// pairs and decodes the response into v
+func stripeRequest(t *testing.T, handler http.Handler, key, method, uri string, form url.Values, status int, v interface{}, headers ...string) {
+	t.Helper()
+
+	// Authenticate with basic authentication like the Stripe libraries do by default
+	request := newRequest("", method, uri, form.Encode(), headers...)
+	if key != "" {
+		request.SetBasicAuth(key, "")
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	serveJSON(t, handler, request, status, v)
+}
+
+// intentTransaction returns the ID of the transaction behind a Stripe payment intent
+func intentTransaction(t *testing.T, paymentIntentID string) primitive.ObjectID {
+	t.Helper()
+	return providerTransaction(t, "pi_", paymentIntentID)
+}
+
+// checkoutRedirect pays a transaction on the checkout page and returns where the customer is sent
+func checkoutRedirect(t *testing.T, id primitive.ObjectID, outcome string) string {
+	t.Helper()
+
+	var redirection redirectResponse
+	if err := json.NewDecoder(postOutcome(id, outcome).Body).Decode(&redirection); err != nil {
+		t.Fatalf("Error parsing JSON response: %v", err)
+	}
+	return redirection.URL
+}
+
+// TestStripePaymentIntent creates, pays and fetches a payment intent, and verifies the signed event
+func TestStripePaymentIntent(t *testing.T) {
+	handler := stripeRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+
+	// Sign the webhooks with a Stripe style secret
+	const secret = "whsec_stripe_test"
+	config := webhook.ConfigFromEnv()
+	config.Secret = secret
+	if err := webhook.Start(config); err != nil {
+		t.Fatalf("Failed to start the webhook worker: %v", err)
+	}
+	t.Cleanup(func() { webhook.Start(webhook.ConfigFromEnv()) })
+
+	// Collect the webhook calls
+	var mu sync.Mutex
+	var events []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := signature.Verify(secret, r.Header.Get("Stripe-Signature"), body, time.Minute); err != nil {
+			t.Errorf("Invalid Stripe-Signature: %v", err)
+		}
+		var event map[string]interface{}
+		json.Unmarshal(body, &event)
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	t.Setenv("STRIPE_WEBHOOK_URL", server.URL)
+
+	// Create a payment intent the way a Stripe client does
+	form := url.Values{
+		"amount":                 {"1250"},
+		"currency":               {"eur"},
+		"description":            {"Order 7"},
+		"return_url":             {"https://shop.test/return?order=7"},
+		"metadata[order_id]":     {"7"},
+		"payment_method_types[]": {"card"},
+	}
+	var created stripePaymentIntent
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents", form, http.StatusOK, &created)
+	if created.Object != "payment_intent" || created.Status != "requires_action" || created.Amount != 1250 || created.Currency != "eur" || created.Livemode {
+		t.Errorf("Unexpected payment intent: %+v", created)
+	}
+	if created.Description == nil || *created.Description != "Order 7" || created.Metadata["order_id"] != "7" {
+		t.Errorf("Unexpected payment intent details: %+v", created)
+	}
+	id := intentTransaction(t, created.ID)
+	if created.NextAction == nil || !strings.HasSuffix(created.NextAction.RedirectToURL.URL, "/transaction/"+id.Hex()) {
+		t.Fatalf("Expected a redirect to the checkout page, got %+v", created.NextAction)
+	}
+
+	// Pay on the checkout page, which returns like Stripe with the payment intent in the query
+	redirect, err := url.Parse(checkoutRedirect(t, id, "paid"))
+	if err != nil {
+		t.Fatalf("Invalid redirect URL: %v", err)
+	}
+	query := redirect.Query()
+	if redirect.Path != "/return" || query.Get("order") != "7" || query.Get("payment_intent") != created.ID || query.Get("redirect_status") != "succeeded" {
+		t.Errorf("Unexpected redirect URL %s", redirect)
+	}
+
+	// The webhook receives a signed payment_intent.succeeded event
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		count := len(events)
+		mu.Unlock()
+		if count > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	if len(events) != 1 || events[0]["object"] != "event" || events[0]["type"] != "payment_intent.succeeded" || !strings.HasPrefix(events[0]["id"].(string), "evt_") {
+		t.Errorf("Expected a single payment_intent.succeeded event, got %v", events)
+	} else if object := events[0]["data"].(map[string]interface{})["object"].(map[string]interface{}); object["id"] != created.ID || object["status"] != "succeeded" {
+		t.Errorf("Unexpected event object: %v", object)
+	}
+	mu.Unlock()
+
+	// The payment intent is fetched with a bearer token like the Stripe libraries do
+	var fetched stripePaymentIntent
+	serveJSON(t, handler, newRequest(key, "GET", "/v1/payment_intents/"+created.ID, ""), http.StatusOK, &fetched)
+	if fetched.Status != "succeeded" || fetched.AmountReceived != 1250 || fetched.NextAction != nil {
+		t.Errorf("Unexpected paid payment intent: %+v", fetched)
+	}
+
+	// Paid payment intents can not be canceled
+	var e stripeError
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents/"+created.ID+"/cancel", nil, http.StatusBadRequest, &e)
+	if e.Error.Code != "payment_intent_unexpected_state" {
+		t.Errorf("Unexpected error: %+v", e)
+	}
+
+	// Other merchants do not see the payment intent
+	other := newMerchant(t, merchants.ModeTest)
+	stripeRequest(t, handler, other, "GET", "/v1/payment_intents/"+created.ID, nil, http.StatusNotFound, &e)
+	if e.Error.Type != "invalid_request_error" || e.Error.Code != "resource_missing" {
+		t.Errorf("Unexpected error: %+v", e)
+	}
+}
+
+// TestStripeCheckoutSession creates checkout sessions and pays, cancels and expires them
+func TestStripeCheckoutSession(t *testing.T) {
+	handler := stripeRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+
+	// Two line items in the same currency add up to the amount of the session
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"success_url":                            {"https://shop.test/success?session={CHECKOUT_SESSION_ID}"},
+		"cancel_url":                             {"https://shop.test/cart"},
+		"client_reference_id":                    {"cart-3"},
+		"line_items[0][price_data][currency]":    {"eur"},
+		"line_items[0][price_data][unit_amount]": {"500"},
+		"line_items[0][price_data][product_data][name]": {"Mug"},
+		"line_items[0][quantity]":                       {"2"},
+		"line_items[1][price_data][currency]":           {"eur"},
+		"line_items[1][price_data][unit_amount]":        {"250"},
+		"line_items[1][price_data][product_data][name]": {"Coaster"},
+		"line_items[1][quantity]":                       {"1"},
+	}
+	var created stripeSession
+	stripeRequest(t, handler, key, "POST", "/v1/checkout/sessions", form, http.StatusOK, &created)
+	if created.Object != "checkout.session" || !strings.HasPrefix(created.ID, "cs_test_") || created.Status != "open" || created.PaymentStatus != "unpaid" {
+		t.Errorf("Unexpected session: %+v", created)
+	}
+	if created.AmountTotal != 1250 || created.Currency != "eur" || created.ClientReferenceID == nil || *created.ClientReferenceID != "cart-3" {
+		t.Errorf("Unexpected session details: %+v", created)
+	}
+	id := intentTransaction(t, created.PaymentIntent)
+	if created.URL == nil || !strings.HasSuffix(*created.URL, "/transaction/"+id.Hex()) {
+		t.Fatalf("Expected the checkout page URL, got %v", created.URL)
+	}
+
+	// Paying goes to the success URL with the session ID filled in
+	if redirect := checkoutRedirect(t, id, "paid"); redirect != "https://shop.test/success?session="+created.ID {
+		t.Errorf("Expected the success URL, got %s", redirect)
+	}
+	var fetched stripeSession
+	stripeRequest(t, handler, key, "GET", "/v1/checkout/sessions/"+created.ID, nil, http.StatusOK, &fetched)
+	if fetched.Status != "complete" || fetched.PaymentStatus != "paid" || fetched.URL != nil {
+		t.Errorf("Unexpected paid session: %+v", fetched)
+	}
+
+	// Completed sessions can not be expired
+	var e stripeError
+	stripeRequest(t, handler, key, "POST", "/v1/checkout/sessions/"+created.ID+"/expire", nil, http.StatusBadRequest, &e)
+
+	// Canceling on the checkout page goes to the cancel URL
+	stripeRequest(t, handler, key, "POST", "/v1/checkout/sessions", form, http.StatusOK, &created)
+	if redirect := checkoutRedirect(t, intentTransaction(t, created.PaymentIntent), "canceled"); redirect != "https://shop.test/cart" {
+		t.Errorf("Expected the cancel URL, got %s", redirect)
+	}
+
+	// Open sessions can be expired through the API
+	stripeRequest(t, handler, key, "POST", "/v1/checkout/sessions", form, http.StatusOK, &created)
+	var expired stripeSession
+	stripeRequest(t, handler, key, "POST", "/v1/checkout/sessions/"+created.ID+"/expire", nil, http.StatusOK, &expired)
+	if expired.Status != "expired" || expired.URL != nil {
+		t.Errorf("Unexpected expired session: %+v", expired)
+	}
+
+	// Payment intents are not checkout sessions
+	stripeRequest(t, handler, key, "GET", "/v1/checkout/sessions/"+created.PaymentIntent, nil, http.StatusNotFound, nil)
+}
+
+// TestStripeErrors verifies that rejected requests are answered with Stripe errors
+func TestStripeErrors(t *testing.T) {
+	handler := stripeRouter(t)
+	key := newMerchant(t, merchants.ModeLive)
+
+	intent := url.Values{"amount": {"100"}, "currency": {"usd"}}
+	with := func(form url.Values, key, value string) url.Values {
+		changed := url.Values{}
+		for name, values := range form {
+			changed[name] = values
+		}
+		changed.Set(key, value)
+		return changed
+	}
+	session := url.Values{
+		"mode":                                   {"payment"},
+		"success_url":                            {"https://shop.test/success"},
+		"line_items[0][price_data][currency]":    {"usd"},
+		"line_items[0][price_data][unit_amount]": {"100"},
+		"line_items[0][price_data][product_data][name]": {"Mug"},
+		"line_items[0][quantity]":                       {"1"},
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		uri    string
+		form   url.Values
+		status int
+		code   string
+		param  string
+	}{
+		{"no key", "", "/v1/payment_intents", intent, http.StatusUnauthorized, "", ""},
+		{"unknown key", "sk_live_unknown", "/v1/payment_intents", intent, http.StatusUnauthorized, "", ""},
+		{"missing amount", key, "/v1/payment_intents", url.Values{"currency": {"usd"}}, http.StatusBadRequest, "parameter_missing", "amount"},
+		{"amount not an integer", key, "/v1/payment_intents", with(intent, "amount", "1.00"), http.StatusBadRequest, "parameter_invalid_integer", "amount"},
+		{"amount too small", key, "/v1/payment_intents", with(intent, "amount", "0"), http.StatusBadRequest, "amount_too_small", "amount"},
+		{"unknown currency", key, "/v1/payment_intents", with(intent, "currency", "xxx"), http.StatusBadRequest, "parameter_invalid_string", "currency"},
+		{"invalid return URL", key, "/v1/payment_intents", with(intent, "return_url", "shop"), http.StatusBadRequest, "url_invalid", "return_url"},
+		{"missing mode", key, "/v1/checkout/sessions", with(session, "mode", ""), http.StatusBadRequest, "parameter_missing", "mode"},
+		{"subscription", key, "/v1/checkout/sessions", with(session, "mode", "subscription"), http.StatusBadRequest, "parameter_invalid_string", "mode"},
+		{"missing success URL", key, "/v1/checkout/sessions", with(session, "success_url", ""), http.StatusBadRequest, "parameter_missing", "success_url"},
+		{"stored price", key, "/v1/checkout/sessions", with(session, "line_items[0][price]", "price_123"), http.StatusBadRequest, "resource_missing", "line_items[0][price]"},
+		{"missing quantity", key, "/v1/checkout/sessions", with(session, "line_items[0][quantity]", ""), http.StatusBadRequest, "parameter_missing", "line_items[0][quantity]"},
+		{"mixed currencies", key, "/v1/checkout/sessions", with(with(with(with(session,
+			"line_items[1][price_data][currency]", "eur"), "line_items[1][price_data][unit_amount]", "100"),
+			"line_items[1][price_data][product_data][name]", "Mug"), "line_items[1][quantity]", "1"),
+			http.StatusBadRequest, "parameter_invalid_string", "line_items[1][price_data][currency]"},
+	}
+	for _, test := range tests {
+		var e stripeError
+		stripeRequest(t, handler, test.key, "POST", test.uri, test.form, test.status, &e)
+		if e.Error.Type != "invalid_request_error" || e.Error.Code != test.code || e.Error.Param != test.param {
+			t.Errorf("%s: unexpected error %+v", test.name, e)
+		}
+	}
+
+	stripeRequest(t, handler, key, "GET", "/v1/payment_intents/pi_unknown", nil, http.StatusNotFound, nil)
+	stripeRequest(t, handler, key, "GET", "/v1/checkout/sessions/cs_live_unknown", nil, http.StatusNotFound, nil)
+
+	// The Stripe API is only served when it is emulated
+	t.Setenv("EMULATE", "mollie")
+	if recorder := requestAs(key, "GET", "/v1/payment_intents", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d without emulation, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+// TestStripeIdempotency verifies that errors of the Idempotency-Key handling are Stripe errors
+func TestStripeIdempotency(t *testing.T) {
+	handler := stripeRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+	idempotencyKey := primitive.NewObjectID().Hex()
+	intent := url.Values{"amount": {"100"}, "currency": {"usd"}}
+
+	// A retry gets the same payment intent
+	var created, retried stripePaymentIntent
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents", intent, http.StatusOK, &created, "Idempotency-Key", idempotencyKey)
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents", intent, http.StatusOK, &retried, "Idempotency-Key", idempotencyKey)
+	if retried.ID != created.ID {
+		t.Errorf("Expected payment intent %s to be replayed, got %s", created.ID, retried.ID)
+	}
+
+	// Reusing the key with a different body is an idempotency error
+	var e stripeError
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents", url.Values{"amount": {"200"}, "currency": {"usd"}}, http.StatusBadRequest, &e, "Idempotency-Key", idempotencyKey)
+	if e.Error.Type != "idempotency_error" {
+		t.Errorf("Unexpected error for a reused key: %+v", e)
+	}
+
+	// Oversized keys are invalid requests
+	e = stripeError{}
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents", intent, http.StatusBadRequest, &e, "Idempotency-Key", strings.Repeat("k", 256))
+	if e.Error.Type != "invalid_request_error" {
+		t.Errorf("Unexpected error for an oversized key: %+v", e)
+	}
+}
+
+// TestStripeListCaptureAndCancel pages through payment intents, captures one and cancels another
+func TestStripeListCaptureAndCancel(t *testing.T) {
+	handler := stripeRouter(t)
+	key := newMerchant(t, merchants.ModeTest)
+
+	// Create three payment intents with manual capture
+	var ids []string
+	for i := 0; i < 3; i++ {
+		var created stripePaymentIntent
+		form := url.Values{"amount": {"1000"}, "currency": {"eur"}, "capture_method": {"manual"}}
+		stripeRequest(t, handler, key, "POST", "/v1/payment_intents", form, http.StatusOK, &created)
+		ids = append(ids, created.ID)
+	}
+
+	// The newest payment intents come first, starting_after continues after the last one
+	var list struct {
+		Object  string                `json:"object"`
+		Data    []stripePaymentIntent `json:"data"`
+		HasMore bool                  `json:"has_more"`
+	}
+	stripeRequest(t, handler, key, "GET", "/v1/payment_intents?limit=2", nil, http.StatusOK, &list)
+	if list.Object != "list" || len(list.Data) != 2 || list.Data[0].ID != ids[2] || list.Data[1].ID != ids[1] || !list.HasMore {
+		t.Fatalf("Unexpected first page: %+v", list)
+	}
+	stripeRequest(t, handler, key, "GET", "/v1/payment_intents?limit=2&starting_after="+ids[1], nil, http.StatusOK, &list)
+	if len(list.Data) != 1 || list.Data[0].ID != ids[0] || list.HasMore {
+		t.Errorf("Unexpected last page: %+v", list)
+	}
+	stripeRequest(t, handler, key, "GET", "/v1/payment_intents?limit=0", nil, http.StatusBadRequest, nil)
+
+	// An authorized payment intent waits for its capture, which may be partial
+	if redirect := checkoutRedirect(t, intentTransaction(t, ids[0]), "paid"); !strings.HasSuffix(redirect, "/transaction/"+intentTransaction(t, ids[0]).Hex()) {
+		t.Errorf("Expected the checkout page without a return URL, got %s", redirect)
+	}
+	var intent stripePaymentIntent
+	stripeRequest(t, handler, key, "GET", "/v1/payment_intents/"+ids[0], nil, http.StatusOK, &intent)
+	if intent.Status != "requires_capture" || intent.AmountCapturable != 1000 {
+		t.Errorf("Unexpected authorized payment intent: %+v", intent)
+	}
+	var e stripeError
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents/"+ids[0]+"/capture", url.Values{"amount_to_capture": {"2000"}}, http.StatusBadRequest, &e)
+	if e.Error.Code != "amount_too_large" || e.Error.Param != "amount_to_capture" {
+		t.Errorf("Unexpected error: %+v", e)
+	}
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents/"+ids[0]+"/capture", url.Values{"amount_to_capture": {"600"}}, http.StatusOK, &intent)
+	if intent.Status != "succeeded" || intent.AmountReceived != 600 || intent.AmountCapturable != 0 {
+		t.Errorf("Unexpected captured payment intent: %+v", intent)
+	}
+
+	// Open payment intents can not be captured but can be canceled
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents/"+ids[1]+"/capture", nil, http.StatusBadRequest, &e)
+	if e.Error.Code != "payment_intent_unexpected_state" {
+		t.Errorf("Unexpected error: %+v", e)
+	}
+	stripeRequest(t, handler, key, "POST", "/v1/payment_intents/"+ids[1]+"/cancel", nil, http.StatusOK, &intent)
+	if intent.Status != "canceled" {
+		t.Errorf("Unexpected canceled payment intent: %+v", intent)
+	}
+}
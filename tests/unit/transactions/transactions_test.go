@@ -7,6 +7,7 @@ import (
 	"dev-payment-gate/api/router"
 	"dev-payment-gate/internal/app"
 	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
 	"fmt"
 	"log"
 	"net/http"
@@ -32,6 +33,16 @@ type redirectResponse struct {
 
 // TestMain functions as the entry point for our unit tests
 func TestMain(m *testing.M) {
+    // Run against the in-memory store unless a backend was chosen explicitly
+    if _, ok := os.LookupEnv("STORE"); !ok {
+        os.Setenv("STORE", "memory")
+    }
+
+    // Authenticate as the merchant bootstrapped from API_KEY
+    if os.Getenv("API_KEY") == "" {
+        os.Setenv("API_KEY", "sk_test_transactions")
+    }
+
     // Setup the test server before running tests
     if err := app.Initialize("../../../"); err != nil {
         log.Fatalf("Initialization error: %v", err)
@@ -47,6 +58,67 @@ func TestMain(m *testing.M) {
     os.Exit(exitCode)
 }
 
+// requestAs serves an API request authenticated with the given key, or without authentication when
+// the key is empty. Extra headers are given as name and value pairs.
+func requestAs(key, method, uri, body string, headers ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, newRequest(key, method, uri, body, headers...))
+	return recorder
+}
+
+// newRequest builds the JSON request served by requestAs
+func newRequest(key, method, uri, body string, headers ...string) *http.Request {
+	request := httptest.NewRequest(method, uri, strings.NewReader(body))
+	if key != "" {
+		request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", key))
+	}
+	request.Header.Add("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Add(headers[i], headers[i+1])
+	}
+	return request
+}
+
+// expectJSON serves an API request like requestAs, fails the test when the response has another
+// status code and decodes the JSON response into v unless it is nil
+func expectJSON(t *testing.T, key, method, uri, body string, status int, v interface{}, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveJSON(t, r, newRequest(key, method, uri, body, headers...), status, v)
+}
+
+// serveJSON serves a request with the given handler for expectJSON and its variants for the
+// emulated APIs. The body is left in the recorder for further checks.
+func serveJSON(t *testing.T, handler http.Handler, request *http.Request, status int, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != status {
+		t.Fatalf("[%s %s] Expected status code %d, got %d: %s", request.Method, request.URL.RequestURI(), status, recorder.Code, recorder.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+			t.Fatalf("Error parsing JSON response: %v", err)
+		}
+	}
+	return recorder
+}
+
+// providerTransaction returns the ID of the stored transaction behind an object of an emulated API,
+// whose ID is the transaction ID after the prefix of the provider
+func providerTransaction(t *testing.T, prefix, providerID string) primitive.ObjectID {
+	t.Helper()
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(providerID, prefix))
+	if err != nil {
+		t.Fatalf("Unexpected ID %s: %v", providerID, err)
+	}
+	if _, err := transactions.GetByID(context.TODO(), id); err != nil {
+		t.Fatalf("Could not find the transaction behind %s: %v", providerID, err)
+	}
+	return id
+}
+
 // TestNotFound tests behavior for invalid routes
 func TestNotFound(t *testing.T) {
     // Create a request with a specific URI
@@ -67,8 +139,8 @@ func TestNotFound(t *testing.T) {
 // TestCreate tests transaction creation for our API
 func TestCreate(t *testing.T) {
 	transactionInput = transactions.TransactionInput{
-		Amount: 4.95,
-		WebhookURL: "url",
+		Amount: money.New(495, "EUR"),
+		WebhookURL: "http://127.0.0.1:1",
 		WebhookKey: "key",
 		RedirectURL: "https://test.nl",
 	}
@@ -131,7 +203,7 @@ func TestTransactionDatabaseEntry(t *testing.T) {
 		t.Fatalf("Expected ID: %s, Got: %s", transactionID, transaction.ID)
 	}
 	if transaction.Amount != transactionInput.Amount {
-		t.Errorf("Expected Amount: %f, Got: %f", transactionInput.Amount, transaction.Amount)
+		t.Errorf("Expected Amount: %s, Got: %s", transactionInput.Amount, transaction.Amount)
 	}
 	if transaction.WebhookURL != transactionInput.WebhookURL {
 		t.Errorf("Expected WebhookURL: %s, Got: %s", transactionInput.WebhookURL, transaction.WebhookURL)
@@ -231,16 +303,42 @@ func TestProcess(t *testing.T) {
 	}
 
 	// Verify that the redirection URL is correct
-	if redirection.URL != transactionInput.RedirectURL {
-		t.Errorf("Expected %s, but got: %s", transactionInput.RedirectURL, redirection.URL)
+	expectedURL := fmt.Sprintf("%s?status=paid", transactionInput.RedirectURL)
+	if redirection.URL != expectedURL {
+		t.Errorf("Expected %s, but got: %s", expectedURL, redirection.URL)
 	}
 }
 
-// TestDeleted checks if the transaction was deleted correctly
-func TestDeleted(t *testing.T) {
+// TestRetained checks if the transaction was kept and marked as paid
+func TestRetained(t *testing.T) {
 	// Fetch the transaction from the database to test if it still exists
-	_, err := transactions.GetByID(context.TODO(), transactionID)
-	if err == nil {
-		t.Error("Successfully fetched transaction after deletion")
+	transaction, err := transactions.GetByID(context.TODO(), transactionID)
+	if err != nil {
+		t.Fatalf("Could not fetch transaction after payment: %v", err)
+	}
+
+	// Verify the lifecycle of the transaction
+	if transaction.Status != transactions.StatusPaid {
+		t.Errorf("Expected status %s, got %s", transactions.StatusPaid, transaction.Status)
+	}
+	if transaction.ChangedAt(transactions.StatusPaid).IsZero() {
+		t.Error("Missing timestamp for the paid transition")
+	}
+}
+
+// TestProcessTwice verifies that a finished transaction can not be paid again
+func TestProcessTwice(t *testing.T) {
+	// Create a request with a specific URI
+	request := httptest.NewRequest("POST", fmt.Sprintf("/transaction/%s", transactionID.Hex()), nil)
+
+	// Create a response recorder to capture the response
+	recorder := httptest.NewRecorder()
+
+	// Serve the request using the router
+	r.ServeHTTP(recorder, request)
+
+	// Validate the response
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, recorder.Code)
 	}
 }
@@ -0,0 +1,128 @@
+package transactions_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errorResponse holds the JSON error envelope returned by the API
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details []struct {
+			Field string `json:"field"`
+			Code  string `json:"code"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// decodeError parses the error envelope of a response
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Expected a JSON error, got Content-Type %q: %s", contentType, recorder.Body.String())
+	}
+	var response errorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatalf("Error parsing JSON error: %v", err)
+	}
+	return response
+}
+
+// TestCreateValidation verifies that invalid transaction input is rejected with the failing fields
+func TestCreateValidation(t *testing.T) {
+	tests := []struct {
+		body  string
+		field string
+		code  string
+	}{
+		{`{"webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount", "required"},
+		{`{"amount": -1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount.value", "out_of_range"},
+		{`{"amount": 0, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount.value", "out_of_range"},
+		{`{"amount": 100000000, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount.value", "out_of_range"},
+		{`{"amount": 1.005, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount", "invalid"},
+		{`{"amount": {"currency": "XXX", "value": "1"}, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"}`, "amount", "invalid"},
+		{`{"amount": 1, "redirect_url": "https://test.nl"}`, "webhook_url", "required"},
+		{`{"amount": 1, "webhook_url": "url", "redirect_url": "https://test.nl"}`, "webhook_url", "invalid_url"},
+		{`{"amount": 1, "webhook_url": "ftp://test.nl", "redirect_url": "https://test.nl"}`, "webhook_url", "invalid_url"},
+		{`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "/done"}`, "redirect_url", "invalid_url"},
+		{`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl", "reference": 5}`, "reference", "invalid_type"},
+		{`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl", "amuont": 1}`, "amuont", "unknown_field"},
+		{fmt.Sprintf(`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl", "reference": %q}`, strings.Repeat("a", 256)), "reference", "too_long"},
+	}
+
+	for _, test := range tests {
+		recorder := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", test.body)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("[%s] Expected status code %d, got %d", test.body, http.StatusBadRequest, recorder.Code)
+			continue
+		}
+
+		// The failing field is reported in the details
+		response := decodeError(t, recorder)
+		if response.Error.Code != "validation_failed" || len(response.Error.Details) != 1 {
+			t.Errorf("[%s] Expected a single validation error, got %+v", test.body, response.Error)
+			continue
+		}
+		if detail := response.Error.Details[0]; detail.Field != test.field || detail.Code != test.code {
+			t.Errorf("[%s] Expected %s %s, got %s %s", test.body, test.field, test.code, detail.Field, detail.Code)
+		}
+	}
+}
+
+// TestMalformedBody verifies the errors for bodies that are not a single JSON object
+func TestMalformedBody(t *testing.T) {
+	tests := map[string]struct {
+		body   string
+		status int
+		code   string
+	}{
+		"syntax":   {`{"amount": `, http.StatusBadRequest, "invalid_json"},
+		"trailing": {`{"amount": 1, "webhook_url": "http://127.0.0.1:1", "redirect_url": "https://test.nl"} {}`, http.StatusBadRequest, "invalid_json"},
+		"size":     {fmt.Sprintf(`{"reference": %q}`, strings.Repeat("a", 128<<10)), http.StatusRequestEntityTooLarge, "body_too_large"},
+	}
+
+	for name, test := range tests {
+		recorder := requestAs(os.Getenv("API_KEY"), "POST", "/transaction", test.body)
+		if recorder.Code != test.status {
+			t.Errorf("[%s] Expected status code %d, got %d", name, test.status, recorder.Code)
+			continue
+		}
+		if response := decodeError(t, recorder); response.Error.Code != test.code {
+			t.Errorf("[%s] Expected error code %s, got %s", name, test.code, response.Error.Code)
+		}
+	}
+}
+
+// TestErrorEnvelope verifies that errors outside of validation use the same JSON envelope
+func TestErrorEnvelope(t *testing.T) {
+	tests := map[string]struct {
+		recorder *httptest.ResponseRecorder
+		status   int
+		code     string
+	}{
+		"route":    {requestAs(os.Getenv("API_KEY"), "GET", "/unknown", ""), http.StatusNotFound, "not_found"},
+		"uri":      {requestAs(os.Getenv("API_KEY"), "GET", "/v1/transactions/invalid", ""), http.StatusBadRequest, "invalid_request"},
+		"query":    {requestAs(os.Getenv("API_KEY"), "GET", "/v1/transactions?limit=0", ""), http.StatusBadRequest, "validation_failed"},
+		"checkout": {postOutcome(primitive.NewObjectID(), "paid"), http.StatusNotFound, "not_found"},
+	}
+
+	for name, test := range tests {
+		if test.recorder.Code != test.status {
+			t.Errorf("[%s] Expected status code %d, got %d", name, test.status, test.recorder.Code)
+			continue
+		}
+		if response := decodeError(t, test.recorder); response.Error.Code != test.code || response.Error.Message == "" {
+			t.Errorf("[%s] Expected error code %s with a message, got %+v", name, test.code, response.Error)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package transactions_test
+
+import (
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookLog is the response of the webhook log endpoint
+type webhookLog struct {
+	ID       string          `json:"id"`
+	Event    string          `json:"event"`
+	Status   string          `json:"status"`
+	ReplayOf string          `json:"replay_of"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts []struct {
+		URL            string            `json:"url"`
+		RequestHeaders map[string]string `json:"request_headers"`
+		RequestBody    string            `json:"request_body"`
+		StatusCode     int               `json:"status_code"`
+		ResponseBody   string            `json:"response_body"`
+		LatencyMS      int64             `json:"latency_ms"`
+	} `json:"attempts"`
+}
+
+// listWebhooks waits until every webhook of a transaction was attempted and returns the log
+func listWebhooks(t *testing.T, uri string) []webhookLog {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var webhooks []webhookLog
+		expectJSON(t, os.Getenv("API_KEY"), "GET", uri, "", http.StatusOK, &webhooks)
+		attempted := true
+		for _, webhook := range webhooks {
+			attempted = attempted && len(webhook.Attempts) > 0
+		}
+		if attempted || time.Now().After(deadline) {
+			return webhooks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWebhookLog verifies that attempts are logged in full and past events can be replayed
+func TestWebhookLog(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	// Pay a transaction, which sends a single webhook
+	id := createTransaction(t, transactions.TransactionInput{Amount: money.New(700, "EUR"), WebhookURL: server.URL, WebhookKey: "secret-key", RedirectURL: "https://test.nl"})
+	if recorder := postOutcome(id, "paid"); recorder.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	uri := fmt.Sprintf("/v1/transactions/%s/webhooks", id.Hex())
+
+	// The attempt holds the request and the response
+	webhooks := listWebhooks(t, uri)
+	if len(webhooks) != 1 || len(webhooks[0].Attempts) != 1 {
+		t.Fatalf("Expected a single attempted webhook, got %+v", webhooks)
+	}
+	attempt := webhooks[0].Attempts[0]
+	if attempt.URL != server.URL || attempt.StatusCode != http.StatusOK || attempt.ResponseBody != `{"ok":true}` || attempt.RequestBody != string(webhooks[0].Payload) {
+		t.Errorf("Unexpected attempt: %+v", attempt)
+	}
+	if attempt.RequestHeaders["Content-Type"] != "application/json" || attempt.RequestHeaders["Authorization"] != "Bearer [redacted]" {
+		t.Errorf("Unexpected request headers: %v", attempt.RequestHeaders)
+	}
+
+	// Replaying sends the same payload again as a new delivery
+	recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("%s/%s/replay", uri, webhooks[0].ID), "")
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, got %d", http.StatusAccepted, recorder.Code)
+	}
+	webhooks = listWebhooks(t, uri)
+	if len(webhooks) != 2 || webhooks[1].ReplayOf != webhooks[0].ID || string(webhooks[1].Payload) != string(webhooks[0].Payload) || webhooks[1].Event != webhooks[0].Event {
+		t.Fatalf("Expected a replay of the first webhook, got %+v", webhooks)
+	}
+	if count := atomic.LoadInt32(&received); count != 2 {
+		t.Errorf("Expected the webhook to be called twice, got %d", count)
+	}
+
+	// Webhooks of other transactions and other merchants can not be replayed
+	other := createTransaction(t, transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: server.URL, RedirectURL: "https://test.nl"})
+	if recorder := requestAs(os.Getenv("API_KEY"), "POST", fmt.Sprintf("/v1/transactions/%s/webhooks/%s/replay", other.Hex(), webhooks[0].ID), ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+	key := newMerchant(t, merchants.ModeTest)
+	if recorder := requestAs(key, "GET", uri, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restartWorker runs the worker with the given config until the end of the test
+func restartWorker(t *testing.T, config webhook.Config) {
+	t.Helper()
+
+	if err := webhook.Start(config); err != nil {
+		t.Fatalf("Could not start the webhook worker: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := webhook.Start(testConfig); err != nil {
+			t.Fatalf("Could not restart the webhook worker: %v", err)
+		}
+	})
+}
+
+// writePEM writes a PEM block to a file in the temporary directory of the test
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Could not write %s: %v", name, err)
+	}
+	return path
+}
+
+// serverCAFile writes the certificate of a TLS test server to a CA bundle
+func serverCAFile(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	return writePEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+// clientCertificate generates a self-signed client certificate and returns it with the paths of
+// its PEM files
+func clientCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dev-payment-gate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Could not marshal key: %v", err)
+	}
+	return certificate, writePEM(t, "client.pem", "CERTIFICATE", der), writePEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// TestTLSVerified verifies that webhooks with an untrusted certificate are not delivered
+func TestTLSVerified(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+
+	if delivery.Status != deliveries.StatusDead {
+		t.Fatalf("Expected status %s, got %s", deliveries.StatusDead, delivery.Status)
+	}
+	if !strings.Contains(delivery.Attempts[0].Error, "certificate") {
+		t.Errorf("Expected a certificate error, got: %s", delivery.Attempts[0].Error)
+	}
+}
+
+// TestTLSCAFile verifies that webhooks signed by the configured CA are delivered
+func TestTLSCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config := testConfig
+	config.CAFile = serverCAFile(t, server)
+	restartWorker(t, config)
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+
+	if delivery.Status != deliveries.StatusDelivered {
+		t.Fatalf("Expected status %s, got %s: %+v", deliveries.StatusDelivered, delivery.Status, delivery.Attempts)
+	}
+}
+
+// TestTLSClientCertificate verifies that the client certificate is presented for mTLS
+func TestTLSClientCertificate(t *testing.T) {
+	certificate, certFile, keyFile := clientCertificate(t)
+	var commonName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	config := testConfig
+	config.CAFile = serverCAFile(t, server)
+	config.ClientCertFile = certFile
+	config.ClientKeyFile = keyFile
+	restartWorker(t, config)
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+
+	if delivery.Status != deliveries.StatusDelivered {
+		t.Fatalf("Expected status %s, got %s: %+v", deliveries.StatusDelivered, delivery.Status, delivery.Attempts)
+	}
+	if commonName != "dev-payment-gate" {
+		t.Errorf("Expected the client certificate, got: %s", commonName)
+	}
+}
+
+// TestTLSInsecureMerchant verifies that merchants can opt out of certificate verification, also
+// for deliveries queued before they did
+func TestTLSInsecureMerchant(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Store a merchant that did not opt out yet
+	merchant := merchants.Create("insecure")
+	merchantID, err := merchants.Insert(context.TODO(), &merchant)
+	if err != nil {
+		t.Fatalf("Could not insert merchant: %v", err)
+	}
+
+	// Store a paid transaction of the merchant
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: server.URL, WebhookKey: "key"})
+	transaction.MerchantID = *merchantID
+	if err := transaction.Transition(transactions.StatusPaid, time.Now()); err != nil {
+		t.Fatalf("Could not transition transaction: %v", err)
+	}
+	id, err := transactions.Insert(context.TODO(), &transaction)
+	if err != nil {
+		t.Fatalf("Could not insert transaction: %v", err)
+	}
+	transaction.ID = *id
+
+	// The self-signed certificate is rejected while the merchant verifies certificates
+	delivery := waitForDelivery(t, &transaction)
+	if delivery.Status != deliveries.StatusDead {
+		t.Fatalf("Expected status %s, got %s", deliveries.StatusDead, delivery.Status)
+	}
+
+	// Opt out and resend the delivery that was queued before
+	if _, err := merchants.Modify(context.TODO(), *merchantID, func(merchant *merchants.Merchant) error {
+		merchant.WebhookInsecure = true
+		return nil
+	}); err != nil {
+		t.Fatalf("Could not update merchant: %v", err)
+	}
+	if _, err := webhook.Resend(context.TODO(), delivery.ID); err != nil {
+		t.Fatalf("Could not resend webhook: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for delivery.Status != deliveries.StatusDelivered && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		delivery, _ = deliveries.GetByID(context.TODO(), delivery.ID)
+	}
+	if delivery.Status != deliveries.StatusDelivered {
+		t.Fatalf("Expected the resend to skip verification, got status %s: %+v", delivery.Status, delivery.Attempts)
+	}
+}
+
+// TestTLSConfigErrors verifies that invalid TLS settings keep the worker from starting
+func TestTLSConfigErrors(t *testing.T) {
+	_, certFile, keyFile := clientCertificate(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+
+	tests := map[string]webhook.Config{
+		"missing CA file":     {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"CA file without PEM": {CAFile: notPEM},
+		"certificate only":    {ClientCertFile: certFile},
+		"key only":            {ClientKeyFile: keyFile},
+		"swapped key pair":    {ClientCertFile: keyFile, ClientKeyFile: certFile},
+	}
+	for name, config := range tests {
+		if _, err := config.TLSConfig(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
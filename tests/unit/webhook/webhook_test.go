@@ -0,0 +1,293 @@
+package webhook_test
+
+import (
+	"context"
+	"dev-payment-gate/internal/webhook"
+	"dev-payment-gate/pkg/signature"
+	"dev-payment-gate/utils/model/deliveries"
+	"dev-payment-gate/utils/model/merchants"
+	"dev-payment-gate/utils/model/transactions"
+	"dev-payment-gate/utils/money"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig retries quickly so the tests do not have to wait for real backoff delays
+var testConfig = webhook.Config{
+	MaxAttempts:  3,
+	BaseBackoff:  10 * time.Millisecond,
+	MaxBackoff:   50 * time.Millisecond,
+	Timeout:      time.Second,
+	PollInterval: 5 * time.Millisecond,
+	Secret:       "secret",
+}
+
+// TestMain functions as the entry point for our unit tests
+func TestMain(m *testing.M) {
+	// Run the worker on top of in-memory stores
+	transactions.SetStore(transactions.NewMemoryStore())
+	deliveries.SetStore(deliveries.NewMemoryStore())
+	merchants.SetStore(merchants.NewMemoryStore())
+	if err := webhook.Start(testConfig); err != nil {
+		log.Fatalf("Could not start the webhook worker: %v", err)
+	}
+
+	// Run the tests
+	exitCode := m.Run()
+
+	// Stop the worker and exit with the status code from tests
+	webhook.Stop()
+	os.Exit(exitCode)
+}
+
+// paidTransaction stores a paid transaction that notifies the given webhook
+func paidTransaction(t *testing.T, webhookURL string) *transactions.Transaction {
+	t.Helper()
+
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: webhookURL, WebhookKey: "key"})
+	if err := transaction.Transition(transactions.StatusPaid, time.Now()); err != nil {
+		t.Fatalf("Could not transition transaction: %v", err)
+	}
+	id, err := transactions.Insert(context.TODO(), &transaction)
+	if err != nil {
+		t.Fatalf("Could not insert transaction: %v", err)
+	}
+	transaction.ID = *id
+	return &transaction
+}
+
+// waitForDelivery enqueues a notification and waits until the delivery is no longer queued
+func waitForDelivery(t *testing.T, transaction *transactions.Transaction) *deliveries.Delivery {
+	t.Helper()
+
+	queued, err := webhook.Enqueue(context.TODO(), transaction)
+	if err != nil {
+		t.Fatalf("Could not enqueue webhook: %v", err)
+	}
+
+	// Wait for the worker to finish with it
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		delivery, err := deliveries.GetByID(context.TODO(), queued.ID)
+		if err != nil {
+			t.Fatalf("Could not fetch delivery: %v", err)
+		}
+		if delivery.Status != deliveries.StatusQueued {
+			return delivery
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Delivery was not finished in time")
+	return nil
+}
+
+// TestDelivered verifies the payload and headers of a successful delivery
+func TestDelivered(t *testing.T) {
+	var payload webhook.Payload
+	var authorization string
+	var verifyErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, verifyErr = signature.VerifyRequest("secret", r)
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	transaction := paidTransaction(t, server.URL)
+	delivery := waitForDelivery(t, transaction)
+
+	if delivery.Status != deliveries.StatusDelivered || len(delivery.Attempts) != 1 {
+		t.Fatalf("Expected a single successful attempt, got status %s after %d attempts", delivery.Status, len(delivery.Attempts))
+	}
+	if verifyErr != nil {
+		t.Errorf("Expected a valid signature, got: %v", verifyErr)
+	}
+	if authorization != "Bearer key" {
+		t.Errorf("Expected the webhook key as bearer token, got: %s", authorization)
+	}
+	if payload.ID != delivery.ID.Hex() || payload.Status != "paid" || payload.Event != "transaction.paid" || payload.TransactionID != transaction.ID.Hex() {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+
+	// The attempt records the request without the webhook key
+	attempt := delivery.Attempts[0]
+	if attempt.URL != server.URL || string(attempt.RequestBody) != string(delivery.Payload) || attempt.RequestHeaders[signature.Header] == "" {
+		t.Errorf("Unexpected attempt: %+v", attempt)
+	}
+	if attempt.RequestHeaders["Authorization"] != "Bearer [redacted]" {
+		t.Errorf("Expected the webhook key to be redacted, got: %s", attempt.RequestHeaders["Authorization"])
+	}
+}
+
+// TestMerchantSecret verifies that deliveries are signed with the webhook secret of the merchant
+// owning the transaction instead of the configured secret
+func TestMerchantSecret(t *testing.T) {
+	var verifyErr, fallbackErr error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = signature.VerifyRequest("whsec_merchant", r)
+		_, fallbackErr = signature.VerifyRequest("secret", r)
+	}))
+	defer server.Close()
+
+	// Store a merchant with a secret of its own
+	merchant := merchants.Create("signed")
+	merchant.WebhookSecret = "whsec_merchant"
+	merchantID, err := merchants.Insert(context.TODO(), &merchant)
+	if err != nil {
+		t.Fatalf("Could not insert merchant: %v", err)
+	}
+
+	// Store a paid transaction of the merchant
+	transaction := transactions.Create(transactions.TransactionInput{Amount: money.New(100, "EUR"), WebhookURL: server.URL, WebhookKey: "key"})
+	transaction.MerchantID = *merchantID
+	if err := transaction.Transition(transactions.StatusPaid, time.Now()); err != nil {
+		t.Fatalf("Could not transition transaction: %v", err)
+	}
+	id, err := transactions.Insert(context.TODO(), &transaction)
+	if err != nil {
+		t.Fatalf("Could not insert transaction: %v", err)
+	}
+	transaction.ID = *id
+
+	delivery := waitForDelivery(t, &transaction)
+
+	if delivery.Status != deliveries.StatusDelivered {
+		t.Fatalf("Expected a delivered webhook, got status %s: %+v", delivery.Status, delivery.Attempts)
+	}
+	if verifyErr != nil {
+		t.Errorf("Expected a signature with the secret of the merchant, got: %v", verifyErr)
+	}
+	if fallbackErr == nil {
+		t.Error("Expected the configured secret not to verify the signature")
+	}
+}
+
+// TestReplay verifies that a replay delivers the same event again as a new delivery
+func TestReplay(t *testing.T) {
+	var ids []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		ids = append(ids, payload.ID)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+	replay, err := webhook.Replay(context.TODO(), delivery.ID)
+	if err != nil {
+		t.Fatalf("Could not replay webhook: %v", err)
+	}
+	if replay.ID == delivery.ID || replay.ReplayOf != delivery.ID || string(replay.Payload) != string(delivery.Payload) {
+		t.Errorf("Unexpected replay: %+v", replay)
+	}
+
+	// Wait for the replay to arrive with the event ID of the original
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		count := len(ids)
+		mu.Unlock()
+		if count == 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Errorf("Expected the same event twice, got %v", ids)
+	}
+}
+
+// TestRetried verifies that a briefly unavailable webhook still receives the notification
+func TestRetried(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+
+	if delivery.Status != deliveries.StatusDelivered {
+		t.Fatalf("Expected status %s, got %s", deliveries.StatusDelivered, delivery.Status)
+	}
+	if len(delivery.Attempts) != 3 || delivery.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected attempts: %+v", delivery.Attempts)
+	}
+}
+
+// TestDeadLetter verifies that a delivery is given up on after the maximum number of attempts
+func TestDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	}))
+	defer server.Close()
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+
+	if delivery.Status != deliveries.StatusDead {
+		t.Fatalf("Expected status %s, got %s", deliveries.StatusDead, delivery.Status)
+	}
+	if len(delivery.Attempts) != testConfig.MaxAttempts {
+		t.Errorf("Expected %d attempts, got %d", testConfig.MaxAttempts, len(delivery.Attempts))
+	}
+	if delivery.Attempts[0].ResponseBody != "boom" {
+		t.Errorf("Expected the response body to be kept, got %q", delivery.Attempts[0].ResponseBody)
+	}
+}
+
+// TestResend verifies that a dead-lettered delivery is attempted again after a resend
+func TestResend(t *testing.T) {
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	delivery := waitForDelivery(t, paidTransaction(t, server.URL))
+	if delivery.Status != deliveries.StatusDead {
+		t.Fatalf("Expected status %s, got %s", deliveries.StatusDead, delivery.Status)
+	}
+
+	// Resend once the webhook recovered
+	atomic.StoreInt32(&healthy, 1)
+	if _, err := webhook.Resend(context.TODO(), delivery.ID); err != nil {
+		t.Fatalf("Could not resend webhook: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for delivery.Status != deliveries.StatusDelivered && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		delivery, _ = deliveries.GetByID(context.TODO(), delivery.ID)
+	}
+	if delivery.Status != deliveries.StatusDelivered || len(delivery.Attempts) != testConfig.MaxAttempts+1 {
+		t.Errorf("Expected the resend to be delivered, got status %s after %d attempts", delivery.Status, len(delivery.Attempts))
+	}
+}
+
+// TestBackoff verifies that the delay doubles after every failure up to the maximum
+func TestBackoff(t *testing.T) {
+	config := webhook.Config{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+
+	for i, delay := range expected {
+		if backoff := config.Backoff(i + 1); backoff != delay {
+			t.Errorf("Expected backoff %s after %d attempts, got %s", delay, i+1, backoff)
+		}
+	}
+}
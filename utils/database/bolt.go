@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	boltDB   *bbolt.DB
+	boltLock sync.Mutex
+)
+
+// OpenBolt opens the single-file embedded database at path, creating it when it does not exist yet
+func OpenBolt(path string) error {
+	// Check if a database is already opened
+	if boltDB != nil {
+		// Close the previous database
+		if err := CloseBolt(); err != nil {
+			return err
+		}
+	}
+
+	// Open the file, waiting a short while when another process holds the lock
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return fmt.Errorf("%s is locked by another process, such as a running gate: %w", path, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Lock the mutex to safely set the database
+	boltLock.Lock()
+	defer boltLock.Unlock()
+
+	// Set the database
+	boltDB = db
+
+	// Log the initialization
+	fmt.Printf("Opened embedded database %s\n", path)
+
+	return nil
+}
+
+// CloseBolt flushes and closes the embedded database
+func CloseBolt() error {
+	// Check if the database is already closed
+	if boltDB == nil {
+		return nil
+	}
+
+	// Lock the mutex to safely clear the database
+	boltLock.Lock()
+	defer boltLock.Unlock()
+
+	// Close the database file
+	if err := boltDB.Close(); err != nil {
+		return err
+	}
+
+	// Clear the database
+	boltDB = nil
+
+	return nil
+}
+
+// GetBolt returns the opened embedded database
+func GetBolt() *bbolt.DB {
+	// Lock the mutex to safely get the database
+	boltLock.Lock()
+	defer boltLock.Unlock()
+
+	// Return the database
+	return boltDB
+}
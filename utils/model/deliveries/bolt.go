@@ -0,0 +1,171 @@
+package deliveries
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// boltBucket is the name of the bucket holding the webhook queue
+var boltBucket = []byte("deliveries")
+
+// BoltStore persists the webhook queue in the embedded database opened by database.OpenBolt
+type BoltStore struct{}
+
+// NewBoltStore creates a delivery store and makes sure its bucket exists
+func NewBoltStore() (*BoltStore, error) {
+	err := database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the deliveries bucket: %v", err)
+	}
+	return &BoltStore{}, nil
+}
+
+// Insert stores a delivery into the embedded database and returns its object id
+func (s *BoltStore) Insert(ctx context.Context, delivery *Delivery) (*primitive.ObjectID, error) {
+	// Generate an ID the same way MongoDB would when none is set
+	stored := *delivery
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+
+	// Encode the delivery
+	data, err := bson.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the delivery unless the ID is already taken
+	err = database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket.Get(stored.ID[:]) != nil {
+			return fmt.Errorf("a delivery with ID %s already exists", stored.ID.Hex())
+		}
+		return bucket.Put(stored.ID[:], data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID retrieves a delivery from the embedded database using the ID
+func (s *BoltStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Delivery, error) {
+	var delivery Delivery
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get(id[:])
+		if data == nil {
+			return ErrNotFound
+		}
+		return bson.Unmarshal(data, &delivery)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Update replaces the stored delivery
+func (s *BoltStore) Update(ctx context.Context, delivery *Delivery) error {
+	data, err := bson.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket.Get(delivery.ID[:]) == nil {
+			return ErrNotFound
+		}
+		return bucket.Put(delivery.ID[:], data)
+	})
+}
+
+// ListDue returns the queued deliveries that are due, oldest first
+func (s *BoltStore) ListDue(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	var due []Delivery
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(key, data []byte) error {
+			var delivery Delivery
+			if err := bson.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			if delivery.due(now) {
+				due = append(due, delivery)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// ListByTransaction returns the deliveries made for a transaction, oldest first
+func (s *BoltStore) ListByTransaction(ctx context.Context, transactionID primitive.ObjectID) ([]Delivery, error) {
+	found := []Delivery{}
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		// Keys are object ids, so iterating the bucket already yields the oldest first
+		return tx.Bucket(boltBucket).ForEach(func(key, data []byte) error {
+			var delivery Delivery
+			if err := bson.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			if delivery.TransactionID == transactionID {
+				found = append(found, delivery)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// ListByTransactions returns the deliveries made for the transactions in a single pass over the
+// bucket, grouped by transaction and oldest first
+func (s *BoltStore) ListByTransactions(ctx context.Context, transactionIDs []primitive.ObjectID) (map[primitive.ObjectID][]Delivery, error) {
+	wanted := make(map[primitive.ObjectID]bool, len(transactionIDs))
+	for _, id := range transactionIDs {
+		wanted[id] = true
+	}
+
+	all := []Delivery{}
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		// Keys are object ids, so iterating the bucket already yields the oldest first
+		return tx.Bucket(boltBucket).ForEach(func(key, data []byte) error {
+			var delivery Delivery
+			if err := bson.Unmarshal(data, &delivery); err != nil {
+				return err
+			}
+			if wanted[delivery.TransactionID] {
+				all = append(all, delivery)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupByTransaction(transactionIDs, all), nil
+}
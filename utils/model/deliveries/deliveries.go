@@ -0,0 +1,227 @@
+package deliveries
+
+import (
+	"context"
+	"dev-payment-gate/pkg/signature"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by a DeliveryStore when the requested delivery does not exist
+var ErrNotFound = errors.New("delivery not found")
+
+// Status describes where a webhook delivery is in the delivery queue
+type Status string
+
+const (
+	// StatusQueued deliveries are waiting for their next attempt
+	StatusQueued Status = "queued"
+
+	// StatusDelivered deliveries were accepted by the webhook
+	StatusDelivered Status = "delivered"
+
+	// StatusDead deliveries ran out of attempts and will not be retried
+	StatusDead Status = "dead"
+)
+
+// Attempt records a single attempt to deliver a webhook: the request as it was sent and what the
+// webhook answered. ResponseBody holds the start of the response.
+type Attempt struct {
+	At             time.Time         `bson:"at"`
+	URL            string            `bson:"url,omitempty"`
+	RequestHeaders map[string]string `bson:"request_headers,omitempty"`
+	RequestBody    []byte            `bson:"request_body,omitempty"`
+	StatusCode     int               `bson:"status_code,omitempty"`
+	Error          string            `bson:"error,omitempty"`
+	Duration       time.Duration     `bson:"duration"`
+	ResponseBody   string            `bson:"response_body,omitempty"`
+}
+
+// Delivery represents the BSON data stored for a single webhook notification. Payloads are JSON
+// unless ContentType says otherwise, and signed in the header named by SignatureHeader.
+type Delivery struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	TransactionID   primitive.ObjectID `bson:"transaction_id"`
+	Event           string             `bson:"event"`
+	URL             string             `bson:"url"`
+	Key             string             `bson:"key"`
+	Payload         []byte             `bson:"payload"`
+	ContentType     string             `bson:"content_type,omitempty"`
+	SignatureHeader string             `bson:"signature_header,omitempty"`
+	ReplayOf        primitive.ObjectID `bson:"replay_of,omitempty"`
+	Status          Status             `bson:"status"`
+	Attempts        []Attempt          `bson:"attempts"`
+	NextAttemptAt   time.Time          `bson:"next_attempt_at"`
+	CreatedAt       time.Time          `bson:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at"`
+}
+
+// PayloadType returns the content type of the payload
+func (d *Delivery) PayloadType() string {
+	if d.ContentType == "" {
+		return "application/json"
+	}
+	return d.ContentType
+}
+
+// SignatureHeaderName returns the HTTP header the signature of the payload is sent in
+func (d *Delivery) SignatureHeaderName() string {
+	if d.SignatureHeader == "" {
+		return signature.Header
+	}
+	return d.SignatureHeader
+}
+
+// DeliveryStore is implemented by every backend that is able to persist the webhook queue
+type DeliveryStore interface {
+	// Insert stores a delivery and returns its object id
+	Insert(ctx context.Context, delivery *Delivery) (*primitive.ObjectID, error)
+
+	// GetByID retrieves a delivery using its ID
+	GetByID(ctx context.Context, id primitive.ObjectID) (*Delivery, error)
+
+	// Update replaces a stored delivery
+	Update(ctx context.Context, delivery *Delivery) error
+
+	// ListDue returns up to limit queued deliveries whose next attempt is due at the given time, oldest first
+	ListDue(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+
+	// ListByTransaction returns every delivery made for a transaction, oldest first
+	ListByTransaction(ctx context.Context, transactionID primitive.ObjectID) ([]Delivery, error)
+
+	// ListByTransactions returns every delivery made for any of the transactions in one lookup,
+	// grouped by transaction and oldest first
+	ListByTransactions(ctx context.Context, transactionIDs []primitive.ObjectID) (map[primitive.ObjectID][]Delivery, error)
+}
+
+var (
+	store     DeliveryStore
+	storeLock sync.RWMutex
+)
+
+// SetStore selects the backend used by the package level delivery operations
+func SetStore(s DeliveryStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	store = s
+}
+
+// getStore returns the selected backend or an error if none has been configured
+func getStore() (DeliveryStore, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if store == nil {
+		return nil, errors.New("no delivery store has been configured")
+	}
+	return store, nil
+}
+
+// Create initializes a new delivery that is due immediately. The ID is assigned up front so it can
+// be embedded in the payload.
+func Create(transactionID primitive.ObjectID, event, url, key string, payload []byte) Delivery {
+	now := time.Now()
+	return Delivery{
+		ID:            primitive.NewObjectID(),
+		TransactionID: transactionID,
+		Event:         event,
+		URL:           url,
+		Key:           key,
+		Payload:       payload,
+		Status:        StatusQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// Insert stores a delivery in the selected store and returns its object id
+func Insert(ctx context.Context, delivery *Delivery) (*primitive.ObjectID, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.Insert(ctx, delivery)
+}
+
+// GetByID retrieves a delivery from the selected store using the ID
+func GetByID(ctx context.Context, id primitive.ObjectID) (*Delivery, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(ctx, id)
+}
+
+// Update replaces a delivery in the selected store
+func Update(ctx context.Context, delivery *Delivery) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Update(ctx, delivery)
+}
+
+// ListDue returns the queued deliveries that are due for another attempt
+func ListDue(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListDue(ctx, now, limit)
+}
+
+// ListByTransaction returns the deliveries made for a transaction
+func ListByTransaction(ctx context.Context, transactionID primitive.ObjectID) ([]Delivery, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListByTransaction(ctx, transactionID)
+}
+
+// ListByTransactions returns the deliveries made for the transactions, grouped by transaction
+func ListByTransactions(ctx context.Context, transactionIDs []primitive.ObjectID) (map[primitive.ObjectID][]Delivery, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListByTransactions(ctx, transactionIDs)
+}
+
+// Requeue makes a delivery due again right away, also when it was delivered or dead-lettered
+func (d *Delivery) Requeue(at time.Time) {
+	d.Status = StatusQueued
+	d.NextAttemptAt = at
+	d.UpdatedAt = at
+}
+
+// LastAttempt returns the most recent attempt of the delivery, or nil when it was not attempted yet
+func (d *Delivery) LastAttempt() *Attempt {
+	if len(d.Attempts) == 0 {
+		return nil
+	}
+	return &d.Attempts[len(d.Attempts)-1]
+}
+
+// groupByTransaction groups deliveries by their transaction, keeping their order. Every requested
+// transaction gets an entry, also when no deliveries were made for it.
+func groupByTransaction(transactionIDs []primitive.ObjectID, all []Delivery) map[primitive.ObjectID][]Delivery {
+	found := make(map[primitive.ObjectID][]Delivery, len(transactionIDs))
+	for _, id := range transactionIDs {
+		found[id] = []Delivery{}
+	}
+	for _, delivery := range all {
+		found[delivery.TransactionID] = append(found[delivery.TransactionID], delivery)
+	}
+	return found
+}
+
+// due reports whether the delivery should be attempted at the given time
+func (d *Delivery) due(now time.Time) bool {
+	return d.Status == StatusQueued && !d.NextAttemptAt.After(now)
+}
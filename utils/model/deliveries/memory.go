@@ -0,0 +1,136 @@
+package deliveries
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps the webhook queue in process memory, queued deliveries are lost on restart
+type MemoryStore struct {
+	mu         sync.RWMutex
+	deliveries map[primitive.ObjectID]Delivery
+}
+
+// NewMemoryStore creates an empty in-memory delivery store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		deliveries: make(map[primitive.ObjectID]Delivery),
+	}
+}
+
+// Insert stores a copy of the delivery and returns its object id
+func (s *MemoryStore) Insert(ctx context.Context, delivery *Delivery) (*primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Generate an ID the same way MongoDB would when none is set
+	stored := *delivery
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+	if _, exists := s.deliveries[stored.ID]; exists {
+		return nil, fmt.Errorf("a delivery with ID %s already exists", stored.ID.Hex())
+	}
+	stored.Attempts = append([]Attempt(nil), delivery.Attempts...)
+	s.deliveries[stored.ID] = stored
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID returns a copy of the stored delivery
+func (s *MemoryStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delivery.Attempts = append([]Attempt(nil), delivery.Attempts...)
+	return &delivery, nil
+}
+
+// Update replaces the stored delivery
+func (s *MemoryStore) Update(ctx context.Context, delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		return ErrNotFound
+	}
+	stored := *delivery
+	stored.Attempts = append([]Attempt(nil), delivery.Attempts...)
+	s.deliveries[delivery.ID] = stored
+	return nil
+}
+
+// ListDue returns copies of the queued deliveries that are due, oldest first
+func (s *MemoryStore) ListDue(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var due []Delivery
+	for _, delivery := range s.deliveries {
+		if delivery.due(now) {
+			delivery.Attempts = append([]Attempt(nil), delivery.Attempts...)
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// ListByTransaction returns copies of the deliveries made for a transaction, oldest first
+func (s *MemoryStore) ListByTransaction(ctx context.Context, transactionID primitive.ObjectID) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := []Delivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.TransactionID == transactionID {
+			delivery.Attempts = append([]Attempt(nil), delivery.Attempts...)
+			found = append(found, delivery)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].ID.Hex() < found[j].ID.Hex()
+	})
+	return found, nil
+}
+
+// ListByTransactions returns copies of the deliveries made for the transactions, grouped by
+// transaction and oldest first
+func (s *MemoryStore) ListByTransactions(ctx context.Context, transactionIDs []primitive.ObjectID) (map[primitive.ObjectID][]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[primitive.ObjectID]bool, len(transactionIDs))
+	for _, id := range transactionIDs {
+		wanted[id] = true
+	}
+	all := []Delivery{}
+	for _, delivery := range s.deliveries {
+		if wanted[delivery.TransactionID] {
+			delivery.Attempts = append([]Attempt(nil), delivery.Attempts...)
+			all = append(all, delivery)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ID.Hex() < all[j].ID.Hex()
+	})
+	return groupByTransaction(transactionIDs, all), nil
+}
@@ -0,0 +1,129 @@
+package deliveries
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore persists the webhook queue in the "deliveries" collection of the connected MongoDB database
+type MongoStore struct{}
+
+// NewMongoStore creates a delivery store and the indexes used to find due deliveries and the
+// deliveries of a transaction
+func NewMongoStore(ctx context.Context) (*MongoStore, error) {
+	collection := database.GetCollection("deliveries")
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{}, nil
+}
+
+// Insert stores a delivery into the database and returns its object id
+func (s *MongoStore) Insert(ctx context.Context, delivery *Delivery) (*primitive.ObjectID, error) {
+	// Insert the delivery into the collection "deliveries"
+	collection := database.GetCollection("deliveries")
+	insertOneResult, err := collection.InsertOne(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assert the InsertedID as a primitive.ObjectID
+	id, ok := insertOneResult.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, errors.New("Failed to assert InsertedID as primitive.ObjectID")
+	}
+	return &id, nil
+}
+
+// GetByID retrieves a delivery from the database using the ID
+func (s *MongoStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Delivery, error) {
+	collection := database.GetCollection("deliveries")
+
+	var delivery Delivery
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Update replaces the stored delivery
+func (s *MongoStore) Update(ctx context.Context, delivery *Delivery) error {
+	collection := database.GetCollection("deliveries")
+
+	updateResult, err := collection.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		return err
+	}
+	if updateResult.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListDue returns the queued deliveries that are due, oldest first
+func (s *MongoStore) ListDue(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	collection := database.GetCollection("deliveries")
+	filter := bson.M{"status": StatusQueued, "next_attempt_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	due := []Delivery{}
+	if err := cursor.All(ctx, &due); err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// ListByTransaction returns the deliveries made for a transaction, oldest first
+func (s *MongoStore) ListByTransaction(ctx context.Context, transactionID primitive.ObjectID) ([]Delivery, error) {
+	collection := database.GetCollection("deliveries")
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := collection.Find(ctx, bson.M{"transaction_id": transactionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Delivery{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// ListByTransactions returns the deliveries made for the transactions with a single query, grouped
+// by transaction and oldest first
+func (s *MongoStore) ListByTransactions(ctx context.Context, transactionIDs []primitive.ObjectID) (map[primitive.ObjectID][]Delivery, error) {
+	collection := database.GetCollection("deliveries")
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := collection.Find(ctx, bson.M{"transaction_id": bson.M{"$in": transactionIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	all := []Delivery{}
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, err
+	}
+	return groupByTransaction(transactionIDs, all), nil
+}
@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// boltBucket is the name of the bucket holding the idempotency records
+var boltBucket = []byte("idempotency_keys")
+
+// BoltStore persists idempotency records in the embedded database opened by database.OpenBolt
+type BoltStore struct{}
+
+// NewBoltStore creates an idempotency store and makes sure its bucket exists
+func NewBoltStore() (*BoltStore, error) {
+	err := database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the idempotency bucket: %v", err)
+	}
+	return &BoltStore{}, nil
+}
+
+// get decodes the live record of a key within a transaction
+func (s *BoltStore) get(tx *bbolt.Tx, key string) (*Record, error) {
+	data := tx.Bucket(boltBucket).Get([]byte(key))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var record Record
+	if err := bson.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if !record.live(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &record, nil
+}
+
+// put encodes and writes a record within a transaction
+func (s *BoltStore) put(tx *bbolt.Tx, record *Record) error {
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltBucket).Put([]byte(record.Key), data)
+}
+
+// Reserve stores the record unless its key is held by another record
+func (s *BoltStore) Reserve(ctx context.Context, record *Record) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		if existing, err := s.get(tx, record.Key); err == nil && existing.held(time.Now()) {
+			return ErrExists
+		}
+		return s.put(tx, record)
+	})
+}
+
+// Get retrieves the live record of a key
+func (s *BoltStore) Get(ctx context.Context, key string) (*Record, error) {
+	var record *Record
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		var err error
+		record, err = s.get(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Complete stores the response for a key
+func (s *BoltStore) Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		record, err := s.get(tx, key)
+		if err != nil {
+			return err
+		}
+		record.Completed = true
+		record.StatusCode = statusCode
+		record.ContentType = contentType
+		record.Body = body
+		return s.put(tx, record)
+	})
+}
+
+// Release removes the record of a key
+func (s *BoltStore) Release(ctx context.Context, key string) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by a RecordStore when no live record exists for a key
+	ErrNotFound = errors.New("idempotency record not found")
+
+	// ErrExists is returned by a RecordStore when a live record already exists for a key
+	ErrExists = errors.New("idempotency record already exists")
+)
+
+// Record stores the outcome of the first request made with an idempotency key. A record that is
+// not completed when its lease ends belongs to a request that was abandoned, for instance because
+// the process crashed, and may be reserved again.
+type Record struct {
+	Key            string    `bson:"_id"`
+	Fingerprint    string    `bson:"fingerprint"`
+	Completed      bool      `bson:"completed"`
+	StatusCode     int       `bson:"status_code,omitempty"`
+	ContentType    string    `bson:"content_type,omitempty"`
+	Body           []byte    `bson:"body,omitempty"`
+	CreatedAt      time.Time `bson:"created_at"`
+	LeaseExpiresAt time.Time `bson:"lease_expires_at"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+}
+
+// RecordStore is implemented by every backend that is able to persist idempotency records.
+// Records past their expiry time are treated as if they do not exist.
+type RecordStore interface {
+	// Reserve stores a new record, returning ErrExists when a live record exists for its key that
+	// is completed or still within its lease
+	Reserve(ctx context.Context, record *Record) error
+
+	// Get retrieves the live record of a key
+	Get(ctx context.Context, key string) (*Record, error)
+
+	// Complete stores the response of the request that reserved the key
+	Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error
+
+	// Release removes a record so the key can be used again
+	Release(ctx context.Context, key string) error
+}
+
+var (
+	store     RecordStore
+	storeLock sync.RWMutex
+)
+
+// SetStore selects the backend used by the package level idempotency operations
+func SetStore(s RecordStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	store = s
+}
+
+// getStore returns the selected backend or an error if none has been configured
+func getStore() (RecordStore, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if store == nil {
+		return nil, errors.New("no idempotency store has been configured")
+	}
+	return store, nil
+}
+
+// Create initializes a new record for a request that is still being processed. The request holds
+// the key for the lease, the response is remembered for the ttl.
+func Create(key, fingerprint string, ttl, lease time.Duration) Record {
+	now := time.Now()
+	return Record{
+		Key:            key,
+		Fingerprint:    fingerprint,
+		CreatedAt:      now,
+		LeaseExpiresAt: now.Add(lease),
+		ExpiresAt:      now.Add(ttl),
+	}
+}
+
+// Reserve stores a new record in the selected store
+func Reserve(ctx context.Context, record *Record) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Reserve(ctx, record)
+}
+
+// Get retrieves the live record of a key from the selected store
+func Get(ctx context.Context, key string) (*Record, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, key)
+}
+
+// Complete stores the response for a key in the selected store
+func Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Complete(ctx, key, statusCode, contentType, body)
+}
+
+// Release removes the record of a key from the selected store
+func Release(ctx context.Context, key string) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Release(ctx, key)
+}
+
+// live reports whether the record has not expired at the given time
+func (r *Record) live(now time.Time) bool {
+	return now.Before(r.ExpiresAt)
+}
+
+// held reports whether the key of the record can not be reserved at the given time, because the
+// response is stored or the request that reserved it may still be running
+func (r *Record) held(now time.Time) bool {
+	return r.live(now) && (r.Completed || now.Before(r.LeaseExpiresAt))
+}
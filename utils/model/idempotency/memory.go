@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps idempotency records in process memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore creates an empty in-memory idempotency store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]Record),
+	}
+}
+
+// Reserve stores a copy of the record unless its key is held by another record
+func (s *MemoryStore) Reserve(ctx context.Context, record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[record.Key]; ok && existing.held(time.Now()) {
+		return ErrExists
+	}
+	s.records[record.Key] = *record
+	return nil
+}
+
+// Get returns a copy of the live record of a key
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok || !record.live(time.Now()) {
+		delete(s.records, key)
+		return nil, ErrNotFound
+	}
+	return &record, nil
+}
+
+// Complete stores the response for a key
+func (s *MemoryStore) Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return ErrNotFound
+	}
+	record.Completed = true
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = append([]byte(nil), body...)
+	s.records[key] = record
+	return nil
+}
+
+// Release removes the record of a key
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore persists idempotency records in the "idempotency_keys" collection of the connected MongoDB database
+type MongoStore struct{}
+
+// NewMongoStore creates an idempotency store and the TTL index that removes expired records
+func NewMongoStore(ctx context.Context) (*MongoStore, error) {
+	collection := database.GetCollection("idempotency_keys")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{}, nil
+}
+
+// Reserve stores the record unless its key is held by another record
+func (s *MongoStore) Reserve(ctx context.Context, record *Record) error {
+	collection := database.GetCollection("idempotency_keys")
+
+	// Insert the record, which fails when the key is taken
+	_, err := collection.InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	// Take over an expired record the TTL monitor did not remove yet, or an abandoned one whose
+	// lease ended before it was completed
+	now := time.Now()
+	filter := bson.M{"_id": record.Key, "$or": bson.A{
+		bson.M{"expires_at": bson.M{"$lte": now}},
+		bson.M{"completed": false, "lease_expires_at": bson.M{"$lte": now}},
+	}}
+	updateResult, err := collection.ReplaceOne(ctx, filter, record)
+	if err != nil {
+		return err
+	}
+	if updateResult.MatchedCount == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+// Get retrieves the live record of a key
+func (s *MongoStore) Get(ctx context.Context, key string) (*Record, error) {
+	collection := database.GetCollection("idempotency_keys")
+	filter := bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}
+
+	var record Record
+	err := collection.FindOne(ctx, filter).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Complete stores the response for a key
+func (s *MongoStore) Complete(ctx context.Context, key string, statusCode int, contentType string, body []byte) error {
+	collection := database.GetCollection("idempotency_keys")
+	update := bson.M{"$set": bson.M{
+		"completed":    true,
+		"status_code":  statusCode,
+		"content_type": contentType,
+		"body":         body,
+	}}
+
+	updateResult, err := collection.UpdateByID(ctx, key, update)
+	if err != nil {
+		return err
+	}
+	if updateResult.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Release removes the record of a key
+func (s *MongoStore) Release(ctx context.Context, key string) error {
+	collection := database.GetCollection("idempotency_keys")
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
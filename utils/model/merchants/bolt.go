@@ -0,0 +1,197 @@
+package merchants
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// boltBucket is the name of the bucket holding the merchants
+	boltBucket = []byte("merchants")
+
+	// boltKeyBucket is the name of the bucket indexing merchants by the hashes of their API keys
+	boltKeyBucket = []byte("merchants_by_key")
+)
+
+// BoltStore persists merchants in the embedded database opened by database.OpenBolt
+type BoltStore struct{}
+
+// NewBoltStore creates a merchant store and makes sure its buckets exist
+func NewBoltStore() (*BoltStore, error) {
+	err := database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltKeyBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the merchants bucket: %v", err)
+	}
+	return &BoltStore{}, nil
+}
+
+// get decodes a merchant within a transaction
+func (s *BoltStore) get(tx *bbolt.Tx, id []byte) (*Merchant, error) {
+	data := tx.Bucket(boltBucket).Get(id)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var merchant Merchant
+	if err := bson.Unmarshal(data, &merchant); err != nil {
+		return nil, err
+	}
+	return &merchant, nil
+}
+
+// put encodes and writes a merchant and the index of its keys within a transaction
+func (s *BoltStore) put(tx *bbolt.Tx, merchant *Merchant) error {
+	data, err := bson.Marshal(merchant)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltBucket).Put(merchant.ID[:], data); err != nil {
+		return err
+	}
+
+	index := tx.Bucket(boltKeyBucket)
+	for _, key := range merchant.Keys {
+		if err := index.Put([]byte(key.Hash), merchant.ID[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert stores a merchant into the embedded database and returns its object id
+func (s *BoltStore) Insert(ctx context.Context, merchant *Merchant) (*primitive.ObjectID, error) {
+	// Generate an ID the same way MongoDB would when none is set
+	stored := *merchant
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+
+	// Write the merchant unless the ID is already taken
+	err := database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(boltBucket).Get(stored.ID[:]) != nil {
+			return fmt.Errorf("a merchant with ID %s already exists", stored.ID.Hex())
+		}
+		return s.put(tx, &stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID retrieves a merchant from the embedded database using the ID
+func (s *BoltStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Merchant, error) {
+	var merchant *Merchant
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		var err error
+		merchant, err = s.get(tx, id[:])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merchant, nil
+}
+
+// GetByKeyHash retrieves the merchant owning the API key with the given hash
+func (s *BoltStore) GetByKeyHash(ctx context.Context, hash string) (*Merchant, error) {
+	var merchant *Merchant
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(boltKeyBucket).Get([]byte(hash))
+		if id == nil {
+			return ErrNotFound
+		}
+		var err error
+		merchant, err = s.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merchant, nil
+}
+
+// Update replaces the stored merchant and re-indexes its keys if its version did not change since
+// it was read
+func (s *BoltStore) Update(ctx context.Context, merchant *Merchant) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		stored, err := s.get(tx, merchant.ID[:])
+		if err != nil {
+			return err
+		}
+		if stored.Version != merchant.Version {
+			return ErrConflict
+		}
+
+		// Drop the index entries of the keys that were removed
+		index := tx.Bucket(boltKeyBucket)
+		for _, key := range stored.Keys {
+			if merchant.FindKey(key.Hash) == nil {
+				if err := index.Delete([]byte(key.Hash)); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Write the new version, only handing it back once it is stored
+		updated := *merchant
+		updated.Version++
+		if err := s.put(tx, &updated); err != nil {
+			return err
+		}
+		merchant.Version = updated.Version
+		return nil
+	})
+}
+
+// TouchKey records when the API key with the given hash was last used
+func (s *BoltStore) TouchKey(ctx context.Context, hash string, at time.Time) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(boltKeyBucket).Get([]byte(hash))
+		if id == nil {
+			return ErrNotFound
+		}
+		merchant, err := s.get(tx, id)
+		if err != nil {
+			return err
+		}
+		key := merchant.FindKey(hash)
+		if key == nil {
+			return ErrNotFound
+		}
+		key.LastUsedAt = &at
+		return s.put(tx, merchant)
+	})
+}
+
+// List returns every merchant, oldest first
+func (s *BoltStore) List(ctx context.Context) ([]Merchant, error) {
+	found := []Merchant{}
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(key, data []byte) error {
+			var merchant Merchant
+			if err := bson.Unmarshal(data, &merchant); err != nil {
+				return err
+			}
+			found = append(found, merchant)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
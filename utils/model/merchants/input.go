@@ -0,0 +1,149 @@
+package merchants
+
+import (
+	"dev-payment-gate/utils/validation"
+	"strings"
+	"time"
+)
+
+const (
+	// maxNameLength limits the length of a merchant name
+	maxNameLength = 255
+
+	// DefaultOverlap is how long a rotated key keeps working when no overlap is given
+	DefaultOverlap = 24 * time.Hour
+)
+
+// KeyInput represents the JSON data received to create an API key. Mode defaults to test and a
+// key without ExpiresAt does not expire.
+type KeyInput struct {
+	Mode      Mode       `json:"mode"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// MerchantInput represents the JSON data received to create a merchant with its first API key
+type MerchantInput struct {
+	Name            string `json:"name"`
+	WebhookInsecure bool   `json:"webhook_insecure"`
+	KeyInput
+}
+
+// SettingsInput represents the JSON data received to change a merchant, fields that are left out
+// keep their value
+type SettingsInput struct {
+	Name            *string `json:"name"`
+	WebhookInsecure *bool   `json:"webhook_insecure"`
+}
+
+// ExpireInput represents the JSON data received to set the expiry of an API key
+type ExpireInput struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RotateInput represents the JSON data received to rotate an API key. Overlap is a duration such
+// as "1h30m" during which the old key keeps working, it defaults to DefaultOverlap.
+type RotateInput struct {
+	Overlap string `json:"overlap"`
+}
+
+// Validate checks the input before a key is created from it. It returns validation.Errors listing
+// every rejected field, or nil when the input is valid.
+func (input KeyInput) Validate(now time.Time) error {
+	var errs validation.Errors
+	input.validate(&errs, now)
+	return errs.Err()
+}
+
+// validate adds the errors of the key input to errs
+func (input KeyInput) validate(errs *validation.Errors, now time.Time) {
+	if input.Mode != "" && !input.Mode.Valid() {
+		errs.Add("mode", validation.CodeInvalid, "mode must be %s or %s", ModeLive, ModeTest)
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		errs.Add("expires_at", validation.CodeOutOfRange, "expires_at must be in the future")
+	}
+}
+
+// KeyMode returns the requested mode, defaulting to test
+func (input KeyInput) KeyMode() Mode {
+	if input.Mode == "" {
+		return ModeTest
+	}
+	return input.Mode
+}
+
+// Validate checks the input before a merchant is created from it. It returns validation.Errors
+// listing every rejected field, or nil when the input is valid.
+func (input MerchantInput) Validate(now time.Time) error {
+	var errs validation.Errors
+
+	if strings.TrimSpace(input.Name) == "" {
+		errs.Add("name", validation.CodeRequired, "name is required")
+	}
+	errs.MaxLength("name", input.Name, maxNameLength)
+	input.KeyInput.validate(&errs, now)
+
+	return errs.Err()
+}
+
+// Validate checks the input before a merchant is changed. It returns validation.Errors listing
+// every rejected field, or nil when the input is valid.
+func (input SettingsInput) Validate() error {
+	var errs validation.Errors
+
+	if input.Name != nil {
+		if strings.TrimSpace(*input.Name) == "" {
+			errs.Add("name", validation.CodeRequired, "name may not be empty")
+		}
+		errs.MaxLength("name", *input.Name, maxNameLength)
+	}
+
+	return errs.Err()
+}
+
+// Apply changes the merchant to the given settings
+func (input SettingsInput) Apply(merchant *Merchant) {
+	if input.Name != nil {
+		merchant.Name = *input.Name
+	}
+	if input.WebhookInsecure != nil {
+		merchant.WebhookInsecure = *input.WebhookInsecure
+	}
+}
+
+// Validate checks the input before the expiry of a key is changed. Times in the past expire the
+// key right away.
+func (input ExpireInput) Validate() error {
+	var errs validation.Errors
+
+	if input.ExpiresAt == nil {
+		errs.Add("expires_at", validation.CodeRequired, "expires_at is required")
+	}
+
+	return errs.Err()
+}
+
+// Validate checks the input before a key is rotated. It returns validation.Errors listing every
+// rejected field, or nil when the input is valid.
+func (input RotateInput) Validate() error {
+	var errs validation.Errors
+
+	if input.Overlap != "" {
+		if overlap, err := time.ParseDuration(input.Overlap); err != nil {
+			errs.Add("overlap", validation.CodeInvalid, "overlap must be a duration such as \"24h\"")
+		} else if overlap < 0 {
+			errs.Add("overlap", validation.CodeOutOfRange, "overlap may not be negative")
+		}
+	}
+
+	return errs.Err()
+}
+
+// OverlapDuration returns the requested overlap, defaulting to DefaultOverlap
+func (input RotateInput) OverlapDuration() time.Duration {
+	overlap, err := time.ParseDuration(input.Overlap)
+	if err != nil {
+		return DefaultOverlap
+	}
+	return overlap
+}
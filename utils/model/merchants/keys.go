@@ -0,0 +1,209 @@
+package merchants
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mode tells whether an API key is used for live or for test payments
+type Mode string
+
+const (
+	ModeLive Mode = "live"
+	ModeTest Mode = "test"
+)
+
+// keyBytes is the number of random bytes in a generated API key or webhook secret
+const keyBytes = 24
+
+// WebhookSecretPrefix starts every generated webhook secret, which Stripe libraries also expect
+const WebhookSecretPrefix = "whsec_"
+
+// KeyStatus describes whether an API key is accepted
+type KeyStatus string
+
+const (
+	KeyActive  KeyStatus = "active"
+	KeyExpired KeyStatus = "expired"
+	KeyRevoked KeyStatus = "revoked"
+)
+
+// APIKey is a credential of a merchant. Only the SHA-256 of the key is stored, the hint shows
+// enough of the key to recognise it. Keys stop working at ExpiresAt or once they are revoked.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Mode       Mode               `bson:"mode"`
+	Hint       string             `bson:"hint"`
+	Hash       string             `bson:"hash"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+}
+
+// Valid reports whether the mode is live or test
+func (m Mode) Valid() bool {
+	return m == ModeLive || m == ModeTest
+}
+
+// Prefix returns the prefix of the API keys of the mode, e.g. "sk_test_"
+func (m Mode) Prefix() string {
+	return fmt.Sprintf("sk_%s_", m)
+}
+
+// ModeOf returns the mode of an API key from its prefix. Keys without a live prefix are test keys.
+func ModeOf(key string) Mode {
+	if strings.HasPrefix(key, ModeLive.Prefix()) {
+		return ModeLive
+	}
+	return ModeTest
+}
+
+// GenerateKey returns a new random API key for the mode
+func GenerateKey(mode Mode) (string, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return mode.Prefix() + hex.EncodeToString(secret), nil
+}
+
+// GenerateWebhookSecret returns a new random secret for signing the webhooks of a merchant
+func GenerateWebhookSecret() (string, error) {
+	secret := make([]byte, keyBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return WebhookSecretPrefix + hex.EncodeToString(secret), nil
+}
+
+// NewWebhookSecret generates the secret the webhooks of the merchant are signed with
+func (m *Merchant) NewWebhookSecret() error {
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	m.WebhookSecret = secret
+	return nil
+}
+
+// HashKey returns the hex encoded SHA-256 of an API key, which is what is stored and looked up
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// hint returns the prefix and the last characters of a key
+func hint(key string) string {
+	if len(key) <= 12 {
+		return strings.Repeat("*", len(key))
+	}
+	prefix := ""
+	if mode := ModeOf(key); strings.HasPrefix(key, mode.Prefix()) {
+		prefix = mode.Prefix()
+	}
+	return prefix + "..." + key[len(key)-4:]
+}
+
+// NewKey generates an API key for the merchant and returns the plain key, which is only available
+// at this point
+func (m *Merchant) NewKey(mode Mode, at time.Time) (string, *APIKey, error) {
+	if !mode.Valid() {
+		return "", nil, fmt.Errorf("unknown mode %q, expected %q or %q", mode, ModeLive, ModeTest)
+	}
+	key, err := GenerateKey(mode)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, m.AddExistingKey(key, at), nil
+}
+
+// AddExistingKey adds a key that was generated elsewhere to the merchant
+func (m *Merchant) AddExistingKey(key string, at time.Time) *APIKey {
+	m.Keys = append(m.Keys, APIKey{
+		ID:        primitive.NewObjectID(),
+		Mode:      ModeOf(key),
+		Hint:      hint(key),
+		Hash:      HashKey(key),
+		CreatedAt: at,
+	})
+	m.UpdatedAt = at
+	return &m.Keys[len(m.Keys)-1]
+}
+
+// FindKey returns the key of the merchant with the given hash, or nil if there is none
+func (m *Merchant) FindKey(hash string) *APIKey {
+	var found *APIKey
+	for i := range m.Keys {
+		// Compare every key in constant time so the lookup does not leak how much of a hash matched
+		if subtle.ConstantTimeCompare([]byte(m.Keys[i].Hash), []byte(hash)) == 1 {
+			found = &m.Keys[i]
+		}
+	}
+	return found
+}
+
+// FindKeyByID returns the key of the merchant with the given ID, or nil if there is none
+func (m *Merchant) FindKeyByID(id primitive.ObjectID) *APIKey {
+	for i := range m.Keys {
+		if m.Keys[i].ID == id {
+			return &m.Keys[i]
+		}
+	}
+	return nil
+}
+
+// Status returns whether the key is active, expired or revoked at the given time
+func (k *APIKey) Status(now time.Time) KeyStatus {
+	switch {
+	case k.RevokedAt != nil:
+		return KeyRevoked
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return KeyExpired
+	default:
+		return KeyActive
+	}
+}
+
+// Expire makes an active key stop working at the given time, which may be in the past
+func (k *APIKey) Expire(expiresAt, now time.Time) error {
+	if status := k.Status(now); status != KeyActive {
+		return fmt.Errorf("%w: key is %s", ErrKeyInactive, status)
+	}
+	k.ExpiresAt = &expiresAt
+	return nil
+}
+
+// Revoke makes a key stop working right away
+func (k *APIKey) Revoke(now time.Time) error {
+	if k.RevokedAt != nil {
+		return fmt.Errorf("%w: key is %s", ErrKeyInactive, KeyRevoked)
+	}
+	k.RevokedAt = &now
+	return nil
+}
+
+// Rotate replaces an active key by a new key of the same mode. The old key keeps working for the
+// overlap, unless it was set to expire before that.
+func (m *Merchant) Rotate(id primitive.ObjectID, overlap time.Duration, now time.Time) (string, *APIKey, error) {
+	old := m.FindKeyByID(id)
+	if old == nil {
+		return "", nil, ErrKeyNotFound
+	}
+	if status := old.Status(now); status != KeyActive {
+		return "", nil, fmt.Errorf("%w: key is %s", ErrKeyInactive, status)
+	}
+
+	// Shorten the lifetime of the old key to the overlap
+	if expiresAt := now.Add(overlap); old.ExpiresAt == nil || expiresAt.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &expiresAt
+	}
+	return m.NewKey(old.Mode, now)
+}
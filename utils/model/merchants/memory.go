@@ -0,0 +1,126 @@
+package merchants
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps merchants in process memory, nothing survives a restart
+type MemoryStore struct {
+	mu        sync.RWMutex
+	merchants map[primitive.ObjectID]Merchant
+}
+
+// NewMemoryStore creates an empty in-memory merchant store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		merchants: make(map[primitive.ObjectID]Merchant),
+	}
+}
+
+// clone copies a merchant so callers can not modify the stored keys
+func clone(merchant Merchant) Merchant {
+	merchant.Keys = append([]APIKey(nil), merchant.Keys...)
+	return merchant
+}
+
+// Insert stores a copy of the merchant and returns its object id
+func (s *MemoryStore) Insert(ctx context.Context, merchant *Merchant) (*primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Generate an ID the same way MongoDB would when none is set
+	stored := clone(*merchant)
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+	if _, exists := s.merchants[stored.ID]; exists {
+		return nil, fmt.Errorf("a merchant with ID %s already exists", stored.ID.Hex())
+	}
+	s.merchants[stored.ID] = stored
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID returns a copy of the stored merchant
+func (s *MemoryStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Merchant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	merchant, ok := s.merchants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	merchant = clone(merchant)
+	return &merchant, nil
+}
+
+// GetByKeyHash returns a copy of the merchant owning the API key with the given hash
+func (s *MemoryStore) GetByKeyHash(ctx context.Context, hash string) (*Merchant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, merchant := range s.merchants {
+		if merchant.FindKey(hash) != nil {
+			merchant = clone(merchant)
+			return &merchant, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Update replaces the stored merchant if its version did not change since it was read
+func (s *MemoryStore) Update(ctx context.Context, merchant *Merchant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.merchants[merchant.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != merchant.Version {
+		return ErrConflict
+	}
+
+	merchant.Version++
+	s.merchants[merchant.ID] = clone(*merchant)
+	return nil
+}
+
+// TouchKey records when the API key with the given hash was last used
+func (s *MemoryStore) TouchKey(ctx context.Context, hash string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, merchant := range s.merchants {
+		if key := merchant.FindKey(hash); key != nil {
+			merchant = clone(merchant)
+			merchant.FindKey(hash).LastUsedAt = &at
+			s.merchants[id] = merchant
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// List returns copies of every merchant, oldest first
+func (s *MemoryStore) List(ctx context.Context) ([]Merchant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make([]Merchant, 0, len(s.merchants))
+	for _, merchant := range s.merchants {
+		found = append(found, clone(merchant))
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return bytes.Compare(found[i].ID[:], found[j].ID[:]) < 0
+	})
+	return found, nil
+}
@@ -0,0 +1,334 @@
+package merchants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is returned by a MerchantStore when the requested merchant does not exist
+	ErrNotFound = errors.New("merchant not found")
+
+	// ErrInvalidKey is returned when an API key does not belong to any merchant
+	ErrInvalidKey = errors.New("invalid API key")
+
+	// ErrKeyExpired is returned when authenticating with an API key after its expiry
+	ErrKeyExpired = fmt.Errorf("%w: the key has expired", ErrInvalidKey)
+
+	// ErrKeyRevoked is returned when authenticating with a revoked API key
+	ErrKeyRevoked = fmt.Errorf("%w: the key has been revoked", ErrInvalidKey)
+
+	// ErrKeyNotFound is returned when a merchant has no API key with the requested ID
+	ErrKeyNotFound = errors.New("API key not found")
+
+	// ErrKeyInactive is returned when changing an API key that is already expired or revoked
+	ErrKeyInactive = errors.New("API key is no longer active")
+
+	// ErrConflict is returned by a MerchantStore when a merchant was modified since it was read
+	ErrConflict = errors.New("merchant was modified concurrently")
+)
+
+// maxUpdateAttempts limits how often Modify retries after a concurrent modification
+const maxUpdateAttempts = 5
+
+// lastUsedResolution is how often the last use of an API key is written at most
+const lastUsedResolution = time.Minute
+
+// Merchant represents the BSON data stored in the merchants collection. The webhooks of the
+// merchant are signed with WebhookSecret, WebhookInsecure opts the merchant out of verifying the
+// certificates of its webhooks.
+type Merchant struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	Name            string             `bson:"name"`
+	Keys            []APIKey           `bson:"keys"`
+	WebhookSecret   string             `bson:"webhook_secret,omitempty"`
+	WebhookInsecure bool               `bson:"webhook_insecure,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	Version   int64     `bson:"version"`
+}
+
+// MerchantStore is implemented by every backend that is able to persist merchants
+type MerchantStore interface {
+	// Insert stores a merchant and returns its object id
+	Insert(ctx context.Context, merchant *Merchant) (*primitive.ObjectID, error)
+
+	// GetByID retrieves a merchant using its ID
+	GetByID(ctx context.Context, id primitive.ObjectID) (*Merchant, error)
+
+	// GetByKeyHash retrieves the merchant owning the API key with the given hash
+	GetByKeyHash(ctx context.Context, hash string) (*Merchant, error)
+
+	// Update replaces a stored merchant and increments its version. It returns ErrConflict when
+	// the stored version no longer matches the version of the given merchant.
+	Update(ctx context.Context, merchant *Merchant) error
+
+	// TouchKey records when the API key with the given hash was last used
+	TouchKey(ctx context.Context, hash string, at time.Time) error
+
+	// List returns every merchant, oldest first
+	List(ctx context.Context) ([]Merchant, error)
+}
+
+var (
+	store     MerchantStore
+	storeLock sync.RWMutex
+)
+
+// SetStore selects the backend used by the package level merchant operations
+func SetStore(s MerchantStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	store = s
+}
+
+// getStore returns the selected backend or an error if none has been configured
+func getStore() (MerchantStore, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if store == nil {
+		return nil, errors.New("no merchant store has been configured")
+	}
+	return store, nil
+}
+
+// Create initializes a new merchant object without API keys
+func Create(name string) Merchant {
+	now := time.Now()
+	return Merchant{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Keys:      []APIKey{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Insert stores a merchant in the selected store and returns its object id
+func Insert(ctx context.Context, merchant *Merchant) (*primitive.ObjectID, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.Insert(ctx, merchant)
+}
+
+// GetByID retrieves a merchant from the selected store using the ID
+func GetByID(ctx context.Context, id primitive.ObjectID) (*Merchant, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(ctx, id)
+}
+
+// Update replaces a merchant in the selected store if it was not modified since it was read
+func Update(ctx context.Context, merchant *Merchant) error {
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	return s.Update(ctx, merchant)
+}
+
+// List returns every merchant in the selected store
+func List(ctx context.Context) ([]Merchant, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.List(ctx)
+}
+
+// Authenticate returns the merchant owning the given API key and the matching key. Expired and
+// revoked keys are rejected, accepted keys have their last use recorded.
+func Authenticate(ctx context.Context, key string) (*Merchant, *APIKey, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Look the merchant up by the hash of the key, the key itself is never stored
+	hash := HashKey(key)
+	merchant, err := s.GetByKeyHash(ctx, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	apiKey := merchant.FindKey(hash)
+	if apiKey == nil {
+		return nil, nil, ErrInvalidKey
+	}
+
+	// Only accept keys that are still active
+	now := time.Now()
+	switch apiKey.Status(now) {
+	case KeyExpired:
+		return nil, nil, ErrKeyExpired
+	case KeyRevoked:
+		return nil, nil, ErrKeyRevoked
+	}
+
+	// Record the use, at most once per lastUsedResolution to keep writes down
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		if err := s.TouchKey(ctx, hash, now); err != nil {
+			return nil, nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+	return merchant, apiKey, nil
+}
+
+// Modify reads a merchant, applies change to it and writes it back to the selected store. The
+// read-modify-write cycle is retried when the merchant is modified concurrently, so a revoked key
+// is never restored by a change that read the merchant before the revocation.
+func Modify(ctx context.Context, id primitive.ObjectID, change func(*Merchant) error) (*Merchant, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		// Read the latest version of the merchant
+		merchant, err := s.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		// Apply the change in memory
+		if err := change(merchant); err != nil {
+			return nil, err
+		}
+		merchant.UpdatedAt = time.Now()
+
+		// Write it back, starting over when somebody else was faster
+		err = s.Update(ctx, merchant)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return merchant, nil
+	}
+
+	return nil, ErrConflict
+}
+
+// AddKey generates a new API key for a stored merchant and returns the plain key, which is only
+// available at this point. A nil expiresAt creates a key that does not expire.
+func AddKey(ctx context.Context, id primitive.ObjectID, mode Mode, expiresAt *time.Time) (string, *APIKey, error) {
+	var key string
+	var apiKey APIKey
+	_, err := Modify(ctx, id, func(merchant *Merchant) error {
+		created, added, err := merchant.NewKey(mode, time.Now())
+		if err != nil {
+			return err
+		}
+		added.ExpiresAt = expiresAt
+		key, apiKey = created, *added
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return key, &apiKey, nil
+}
+
+// modifyKey applies a change to an API key of a stored merchant and returns the changed key
+func modifyKey(ctx context.Context, id, keyID primitive.ObjectID, change func(*APIKey, time.Time) error) (*APIKey, error) {
+	var apiKey APIKey
+	_, err := Modify(ctx, id, func(merchant *Merchant) error {
+		found := merchant.FindKeyByID(keyID)
+		if found == nil {
+			return ErrKeyNotFound
+		}
+		if err := change(found, time.Now()); err != nil {
+			return err
+		}
+		apiKey = *found
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// ExpireKey makes an API key of a stored merchant stop working at the given time
+func ExpireKey(ctx context.Context, id, keyID primitive.ObjectID, expiresAt time.Time) (*APIKey, error) {
+	return modifyKey(ctx, id, keyID, func(apiKey *APIKey, now time.Time) error {
+		return apiKey.Expire(expiresAt, now)
+	})
+}
+
+// RevokeKey makes an API key of a stored merchant stop working right away
+func RevokeKey(ctx context.Context, id, keyID primitive.ObjectID) (*APIKey, error) {
+	return modifyKey(ctx, id, keyID, func(apiKey *APIKey, now time.Time) error {
+		return apiKey.Revoke(now)
+	})
+}
+
+// RotateKey replaces an API key of a stored merchant by a new key, keeping the old key working for
+// the overlap. It returns the plain new key, which is only available at this point.
+func RotateKey(ctx context.Context, id, keyID primitive.ObjectID, overlap time.Duration) (string, *APIKey, error) {
+	var key string
+	var apiKey APIKey
+	_, err := Modify(ctx, id, func(merchant *Merchant) error {
+		created, added, err := merchant.Rotate(keyID, overlap, time.Now())
+		if err != nil {
+			return err
+		}
+		key, apiKey = created, *added
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return key, &apiKey, nil
+}
+
+// Bootstrap makes sure a merchant with the given name owns the given API key, creating the merchant
+// when no merchant owns the key yet. It lets a single configured key keep working without setting
+// up merchants first. A created merchant signs its webhooks with webhookSecret, or with a generated
+// secret when it is empty.
+func Bootstrap(ctx context.Context, name, key, webhookSecret string) (*Merchant, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing to do when the key is already known
+	merchant, err := s.GetByKeyHash(ctx, HashKey(key))
+	if err == nil {
+		return merchant, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	// Create the merchant with the configured key
+	created := Create(name)
+	created.AddExistingKey(key, created.CreatedAt)
+	created.WebhookSecret = webhookSecret
+	if webhookSecret == "" {
+		if err := created.NewWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.Insert(ctx, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
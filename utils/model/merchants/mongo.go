@@ -0,0 +1,132 @@
+package merchants
+
+import (
+	"context"
+	"dev-payment-gate/utils/database"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore persists merchants in the "merchants" collection of the connected MongoDB database
+type MongoStore struct{}
+
+// NewMongoStore creates a merchant store and the unique index used to look up API keys
+func NewMongoStore(ctx context.Context) (*MongoStore, error) {
+	collection := database.GetCollection("merchants")
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "keys.hash", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"keys.hash": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{}, nil
+}
+
+// Insert stores a merchant into the database and returns its object id
+func (s *MongoStore) Insert(ctx context.Context, merchant *Merchant) (*primitive.ObjectID, error) {
+	collection := database.GetCollection("merchants")
+
+	insertOneResult, err := collection.InsertOne(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := insertOneResult.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, errors.New("Failed to assert InsertedID as primitive.ObjectID")
+	}
+	return &id, nil
+}
+
+// findOne decodes the single merchant matching the filter
+func (s *MongoStore) findOne(ctx context.Context, filter bson.M) (*Merchant, error) {
+	collection := database.GetCollection("merchants")
+
+	var merchant Merchant
+	err := collection.FindOne(ctx, filter).Decode(&merchant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &merchant, nil
+}
+
+// GetByID retrieves a merchant from the database using the ID
+func (s *MongoStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Merchant, error) {
+	return s.findOne(ctx, bson.M{"_id": id})
+}
+
+// GetByKeyHash retrieves the merchant owning the API key with the given hash
+func (s *MongoStore) GetByKeyHash(ctx context.Context, hash string) (*Merchant, error) {
+	return s.findOne(ctx, bson.M{"keys.hash": hash})
+}
+
+// Update replaces the stored merchant if its version did not change since it was read
+func (s *MongoStore) Update(ctx context.Context, merchant *Merchant) error {
+	// Setup the database request, only matching the version that was read
+	collection := database.GetCollection("merchants")
+	filter := bson.M{"_id": merchant.ID, "version": merchant.Version}
+	if merchant.Version == 0 {
+		// Documents written before versioning existed do not have the field at all
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	// Replace the document with its next version
+	updated := *merchant
+	updated.Version++
+	updateResult, err := collection.ReplaceOne(ctx, filter, updated)
+	if err != nil {
+		return err
+	}
+
+	// Find out why nothing matched when the replacement did not happen
+	if updateResult.MatchedCount == 0 {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": merchant.ID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+
+	merchant.Version = updated.Version
+	return nil
+}
+
+// TouchKey records when the API key with the given hash was last used
+func (s *MongoStore) TouchKey(ctx context.Context, hash string, at time.Time) error {
+	collection := database.GetCollection("merchants")
+
+	updateResult, err := collection.UpdateOne(ctx, bson.M{"keys.hash": hash}, bson.M{"$set": bson.M{"keys.$.last_used_at": at}})
+	if err != nil {
+		return err
+	}
+	if updateResult.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns every merchant, oldest first
+func (s *MongoStore) List(ctx context.Context) ([]Merchant, error) {
+	collection := database.GetCollection("merchants")
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	found := []Merchant{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
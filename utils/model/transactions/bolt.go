@@ -0,0 +1,223 @@
+package transactions
+
+import (
+	"bytes"
+	"context"
+	"dev-payment-gate/utils/database"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// boltBucket is the name of the bucket holding the transactions
+	boltBucket = []byte("transactions")
+
+	// boltReferenceBucket is the name of the bucket indexing transactions by merchant reference
+	boltReferenceBucket = []byte("transactions_by_reference")
+)
+
+// BoltStore persists transactions in the embedded database opened by database.OpenBolt.
+// Transactions are encoded as BSON and keyed by their object id, so they sort by creation time.
+type BoltStore struct{}
+
+// NewBoltStore creates a transaction store and makes sure its buckets exist
+func NewBoltStore() (*BoltStore, error) {
+	err := database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltReferenceBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the transactions bucket: %v", err)
+	}
+	return &BoltStore{}, nil
+}
+
+// referenceKey returns the key of a transaction in the reference index
+func referenceKey(reference string, id primitive.ObjectID) []byte {
+	return append(referencePrefix(reference), id[:]...)
+}
+
+// referencePrefix returns the prefix shared by all index keys of a reference
+func referencePrefix(reference string) []byte {
+	return append([]byte(reference), 0)
+}
+
+// Insert stores a transaction into the embedded database and returns its object id
+func (s *BoltStore) Insert(ctx context.Context, transaction *Transaction) (*primitive.ObjectID, error) {
+	// Generate an ID the same way MongoDB would when none is set
+	stored := *transaction
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+
+	// Encode the transaction
+	data, err := bson.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the transaction unless the ID is already taken
+	err = database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket.Get(stored.ID[:]) != nil {
+			return fmt.Errorf("a transaction with ID %s already exists", stored.ID.Hex())
+		}
+		if err := bucket.Put(stored.ID[:], data); err != nil {
+			return err
+		}
+
+		// Index the merchant reference
+		if stored.Reference == "" {
+			return nil
+		}
+		return tx.Bucket(boltReferenceBucket).Put(referenceKey(stored.Reference, stored.ID), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID retrieves a transaction from the embedded database using the ID
+func (s *BoltStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
+	var transaction Transaction
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get(id[:])
+		if data == nil {
+			return ErrNotFound
+		}
+		return bson.Unmarshal(data, &transaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &transaction, nil
+}
+
+// Update replaces the stored transaction if its version did not change since it was read
+func (s *BoltStore) Update(ctx context.Context, transaction *Transaction) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+
+		// Compare the stored version with the version that was read
+		data := bucket.Get(transaction.ID[:])
+		if data == nil {
+			return ErrNotFound
+		}
+		var stored Transaction
+		if err := bson.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != transaction.Version {
+			return ErrConflict
+		}
+
+		// Write the new version
+		updated := *transaction
+		updated.Version++
+		data, err := bson.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(transaction.ID[:], data); err != nil {
+			return err
+		}
+
+		// Move the reference index entry when the reference changed
+		if stored.Reference != updated.Reference {
+			index := tx.Bucket(boltReferenceBucket)
+			if stored.Reference != "" {
+				if err := index.Delete(referenceKey(stored.Reference, stored.ID)); err != nil {
+					return err
+				}
+			}
+			if updated.Reference != "" {
+				if err := index.Put(referenceKey(updated.Reference, updated.ID), nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		transaction.Version = updated.Version
+		return nil
+	})
+}
+
+// Delete removes a transaction from the embedded database
+func (s *BoltStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return database.GetBolt().Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		data := bucket.Get(id[:])
+		if data == nil {
+			return ErrNotFound
+		}
+
+		// Remove the reference index entry
+		var stored Transaction
+		if err := bson.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Reference != "" {
+			if err := tx.Bucket(boltReferenceBucket).Delete(referenceKey(stored.Reference, id)); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Delete(id[:])
+	})
+}
+
+// List returns the transactions matching the filter, newest first. Filtering on a reference walks
+// the reference index, every other filter walks the transactions from the cursor backwards.
+func (s *BoltStore) List(ctx context.Context, filter Filter, before primitive.ObjectID, limit int) ([]Transaction, error) {
+	found := []Transaction{}
+	err := database.GetBolt().View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+
+		// Pick the keys to walk
+		cursor, prefix := bucket.Cursor(), []byte{}
+		if filter.Reference != "" {
+			cursor, prefix = tx.Bucket(boltReferenceBucket).Cursor(), referencePrefix(filter.Reference)
+		}
+
+		// Position the cursor on the newest key before the requested ID
+		upper := before[:]
+		if before.IsZero() {
+			upper = bytes.Repeat([]byte{0xff}, len(before)+1)
+		}
+		key, _ := cursor.Seek(append(append([]byte{}, prefix...), upper...))
+		if key == nil {
+			key, _ = cursor.Last()
+		} else {
+			key, _ = cursor.Prev()
+		}
+
+		// Walk backwards until the page is full
+		for ; key != nil && bytes.HasPrefix(key, prefix) && len(found) < limit; key, _ = cursor.Prev() {
+			data := bucket.Get(key[len(prefix):])
+			if data == nil {
+				continue
+			}
+			var transaction Transaction
+			if err := bson.Unmarshal(data, &transaction); err != nil {
+				return err
+			}
+			if filter.Matches(&transaction) {
+				found = append(found, transaction)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
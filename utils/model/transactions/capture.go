@@ -0,0 +1,110 @@
+package transactions
+
+import (
+	"context"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrCaptureExceeded is returned when a capture is larger than the authorized amount
+var ErrCaptureExceeded = errors.New("capture exceeds the authorized amount")
+
+// CaptureMethod describes whether a successful payment is captured right away or only authorized
+type CaptureMethod string
+
+const (
+	// CaptureAutomatic payments are paid as soon as the payment succeeds
+	CaptureAutomatic CaptureMethod = "automatic"
+
+	// CaptureManual payments are authorized first and paid when they are captured
+	CaptureManual CaptureMethod = "manual"
+)
+
+// CaptureInput represents the JSON data received to capture an authorized transaction. Without an
+// amount the full authorized amount is captured.
+type CaptureInput struct {
+	Amount *money.Money `json:"amount"`
+}
+
+// Validate checks the input before capturing. It returns validation.Errors listing every rejected
+// field, or nil when the input is valid.
+func (input CaptureInput) Validate() error {
+	var errs validation.Errors
+
+	if input.Amount != nil && input.Amount.Value <= 0 {
+		errs.Add("amount.value", validation.CodeOutOfRange, "amount must be positive")
+	}
+
+	return errs.Err()
+}
+
+// PaidStatus returns the status a successful payment moves the transaction to
+func (t *Transaction) PaidStatus() Status {
+	if t.CaptureMethod == CaptureManual {
+		return StatusAuthorized
+	}
+	return StatusPaid
+}
+
+// PaidAmount returns the amount that was actually paid, which is the captured amount for manual
+// capture and the full amount otherwise
+func (t *Transaction) PaidAmount() money.Money {
+	if t.Status != StatusPaid {
+		return money.New(0, t.Amount.Currency)
+	}
+	if t.CaptureMethod == CaptureManual {
+		return t.Captured
+	}
+	return t.Amount
+}
+
+// Capture pays an authorized transaction. A nil amount captures the full authorized amount.
+func (t *Transaction) Capture(amount *money.Money, at time.Time) error {
+	if t.Status != StatusAuthorized {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, t.Status, StatusPaid)
+	}
+
+	// Default to the authorized amount and never capture more
+	if amount == nil {
+		amount = &t.Amount
+	}
+	if amount.Currency != t.Amount.Currency {
+		return fmt.Errorf("%w: expected %s, got %s", ErrCurrencyMismatch, t.Amount.Currency, amount.Currency)
+	}
+	if amount.Value <= 0 || amount.Value > t.Amount.Value {
+		return fmt.Errorf("%w of %s", ErrCaptureExceeded, t.Amount)
+	}
+
+	if err := t.Transition(StatusPaid, at); err != nil {
+		return err
+	}
+	t.Captured = *amount
+	return nil
+}
+
+// Void releases an authorized transaction without capturing it
+func (t *Transaction) Void(at time.Time) error {
+	if t.Status != StatusAuthorized {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, t.Status, StatusCanceled)
+	}
+	return t.Transition(StatusCanceled, at)
+}
+
+// Capture pays a stored authorized transaction
+func Capture(ctx context.Context, id primitive.ObjectID, amount *money.Money) (*Transaction, error) {
+	return Modify(ctx, id, func(transaction *Transaction) error {
+		return transaction.Capture(amount, time.Now())
+	})
+}
+
+// Void releases a stored authorized transaction
+func Void(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
+	return Modify(ctx, id, func(transaction *Transaction) error {
+		return transaction.Void(time.Now())
+	})
+}
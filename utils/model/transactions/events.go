@@ -0,0 +1,76 @@
+package transactions
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// subscribers holds the channels that receive the status changes of a transaction. Changes are
+// only published within this process, subscribers that share a store with other processes have to
+// read the store now and then as well.
+var (
+	subscribers     = map[primitive.ObjectID]map[chan Transaction]struct{}{}
+	subscribersLock sync.Mutex
+)
+
+// Subscribe returns a channel that receives the transaction every time its status changes and a
+// function to stop receiving. A slow subscriber only misses intermediate changes, the latest
+// change is always kept for it. The channel is closed by CloseSubscriptions.
+func Subscribe(id primitive.ObjectID) (<-chan Transaction, func()) {
+	events := make(chan Transaction, 1)
+
+	// Lock the mutex to safely add the subscriber
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	if subscribers[id] == nil {
+		subscribers[id] = map[chan Transaction]struct{}{}
+	}
+	subscribers[id][events] = struct{}{}
+
+	unsubscribe := func() {
+		// Lock the mutex to safely remove the subscriber
+		subscribersLock.Lock()
+		defer subscribersLock.Unlock()
+		delete(subscribers[id], events)
+		if len(subscribers[id]) == 0 {
+			delete(subscribers, id)
+		}
+	}
+	return events, unsubscribe
+}
+
+// publish sends the transaction to everyone subscribed to it
+func publish(transaction *Transaction) {
+	// Lock the mutex to safely read the subscribers, it also keeps publishers from interleaving
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	for events := range subscribers[transaction.ID] {
+		// Replace a change the subscriber has not received yet with the latest one
+		select {
+		case events <- *transaction:
+		default:
+			select {
+			case <-events:
+			default:
+			}
+			events <- *transaction
+		}
+	}
+}
+
+// CloseSubscriptions closes the channels of everyone subscribed, which tells long-lived subscribers
+// such as event streams to stop. It is called when the server shuts down.
+func CloseSubscriptions() {
+	// Lock the mutex to safely remove the subscribers
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	for id, channels := range subscribers {
+		for events := range channels {
+			close(events)
+		}
+		delete(subscribers, id)
+	}
+}
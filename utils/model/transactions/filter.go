@@ -0,0 +1,134 @@
+package transactions
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter narrows down the transactions returned by List, zero values do not filter
+type Filter struct {
+	// MerchantID only keeps transactions owned by the given merchant
+	MerchantID primitive.ObjectID
+
+	// Statuses only keeps transactions in one of the given statuses
+	Statuses []Status
+
+	// Currency only keeps transactions in the given ISO 4217 currency
+	Currency string
+
+	// MinAmount and MaxAmount bound the amount in minor units, both inclusive
+	MinAmount *int64
+	MaxAmount *int64
+
+	// CreatedFrom (inclusive) and CreatedTo (exclusive) bound the creation time
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+
+	// Reference only keeps transactions with the given merchant reference
+	Reference string
+
+	// ExpiresBefore only keeps transactions with an expiry time before the given time
+	ExpiresBefore *time.Time
+
+	// PendingRefunds only keeps transactions with a refund that has not settled yet
+	PendingRefunds bool
+}
+
+// Matches reports whether the transaction passes the filter
+func (f Filter) Matches(transaction *Transaction) bool {
+	if !f.MerchantID.IsZero() && transaction.MerchantID != f.MerchantID {
+		return false
+	}
+	if len(f.Statuses) > 0 {
+		matched := false
+		for _, status := range f.Statuses {
+			if transaction.Status == status {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Currency != "" && transaction.Amount.Currency != f.Currency {
+		return false
+	}
+	if f.MinAmount != nil && transaction.Amount.Value < *f.MinAmount {
+		return false
+	}
+	if f.MaxAmount != nil && transaction.Amount.Value > *f.MaxAmount {
+		return false
+	}
+	if f.CreatedFrom != nil && transaction.Timestamp.Before(*f.CreatedFrom) {
+		return false
+	}
+	if f.CreatedTo != nil && !transaction.Timestamp.Before(*f.CreatedTo) {
+		return false
+	}
+	if f.Reference != "" && transaction.Reference != f.Reference {
+		return false
+	}
+	if f.ExpiresBefore != nil && (transaction.ExpiresAt.IsZero() || !transaction.ExpiresAt.Before(*f.ExpiresBefore)) {
+		return false
+	}
+	if f.PendingRefunds {
+		pending := false
+		for _, refund := range transaction.Refunds {
+			pending = pending || refund.Status == RefundPending
+		}
+		if !pending {
+			return false
+		}
+	}
+	return true
+}
+
+// query converts the filter and cursor into a MongoDB query
+func (f Filter) query(before primitive.ObjectID) bson.M {
+	query := bson.M{}
+	if !f.MerchantID.IsZero() {
+		query["merchant_id"] = f.MerchantID
+	}
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+	if f.Currency != "" {
+		query["amount.currency"] = f.Currency
+	}
+	if f.MinAmount != nil || f.MaxAmount != nil {
+		amount := bson.M{}
+		if f.MinAmount != nil {
+			amount["$gte"] = *f.MinAmount
+		}
+		if f.MaxAmount != nil {
+			amount["$lte"] = *f.MaxAmount
+		}
+		query["amount.value"] = amount
+	}
+	if f.CreatedFrom != nil || f.CreatedTo != nil {
+		timestamp := bson.M{}
+		if f.CreatedFrom != nil {
+			timestamp["$gte"] = *f.CreatedFrom
+		}
+		if f.CreatedTo != nil {
+			timestamp["$lt"] = *f.CreatedTo
+		}
+		query["timestamp"] = timestamp
+	}
+	if f.Reference != "" {
+		query["reference"] = f.Reference
+	}
+	if f.ExpiresBefore != nil {
+		query["expires_at"] = bson.M{"$gt": time.Time{}, "$lt": *f.ExpiresBefore}
+	}
+	if f.PendingRefunds {
+		query["refunds.status"] = RefundPending
+	}
+	if !before.IsZero() {
+		query["_id"] = bson.M{"$lt": before}
+	}
+	return query
+}
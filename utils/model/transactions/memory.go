@@ -0,0 +1,118 @@
+package transactions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps transactions in process memory, which makes it suitable for development and CI
+type MemoryStore struct {
+	mu           sync.RWMutex
+	transactions map[primitive.ObjectID]Transaction
+}
+
+// NewMemoryStore creates an empty in-memory transaction store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		transactions: make(map[primitive.ObjectID]Transaction),
+	}
+}
+
+// clone copies a transaction so callers can not modify the stored history and refunds
+func clone(transaction Transaction) Transaction {
+	transaction.History = append([]StatusChange(nil), transaction.History...)
+	transaction.Refunds = append([]Refund(nil), transaction.Refunds...)
+	return transaction
+}
+
+// Insert stores a copy of the transaction and returns its object id
+func (s *MemoryStore) Insert(ctx context.Context, transaction *Transaction) (*primitive.ObjectID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Generate an ID the same way MongoDB would when none is set
+	stored := clone(*transaction)
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+	if _, exists := s.transactions[stored.ID]; exists {
+		return nil, fmt.Errorf("a transaction with ID %s already exists", stored.ID.Hex())
+	}
+	s.transactions[stored.ID] = stored
+
+	id := stored.ID
+	return &id, nil
+}
+
+// GetByID returns a copy of the stored transaction
+func (s *MemoryStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transaction, ok := s.transactions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	transaction = clone(transaction)
+	return &transaction, nil
+}
+
+// Update replaces the stored transaction if its version did not change since it was read
+func (s *MemoryStore) Update(ctx context.Context, transaction *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.transactions[transaction.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != transaction.Version {
+		return ErrConflict
+	}
+
+	transaction.Version++
+	s.transactions[transaction.ID] = clone(*transaction)
+	return nil
+}
+
+// Delete removes a transaction from memory
+func (s *MemoryStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.transactions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.transactions, id)
+	return nil
+}
+
+// List returns copies of the transactions matching the filter, newest first
+func (s *MemoryStore) List(ctx context.Context, filter Filter, before primitive.ObjectID, limit int) ([]Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := []Transaction{}
+	for id, transaction := range s.transactions {
+		if !before.IsZero() && bytes.Compare(id[:], before[:]) >= 0 {
+			continue
+		}
+		if filter.Matches(&transaction) {
+			found = append(found, clone(transaction))
+		}
+	}
+
+	// Object ids start with their creation time, so sorting them sorts by age
+	sort.Slice(found, func(i, j int) bool {
+		return bytes.Compare(found[i].ID[:], found[j].ID[:]) > 0
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
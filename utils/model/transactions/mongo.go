@@ -0,0 +1,149 @@
+package transactions
+
+import (
+	"context"
+	"errors"
+	"dev-payment-gate/utils/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore persists transactions in the "transactions" collection of the connected MongoDB database
+type MongoStore struct{}
+
+// NewMongoStore creates a transaction store on top of the connection made by database.Connect
+// and the indexes used to list and filter transactions
+func NewMongoStore(ctx context.Context) (*MongoStore, error) {
+	collection := database.GetCollection("transactions")
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "merchant_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "refunds.status", Value: 1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "amount.currency", Value: 1}, {Key: "amount.value", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{}, nil
+}
+
+// Insert stores a transaction into the database and returns its object id
+func (s *MongoStore) Insert(ctx context.Context, transaction *Transaction) (*primitive.ObjectID, error) {
+	// Setup the database request
+	collection := database.GetCollection("transactions")
+
+	// Insert the transaction into the collection "transactions"
+	insertOneResult, err := collection.InsertOne(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assert the InsertedID as a primitive.ObjectID
+	id, ok := insertOneResult.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return nil, errors.New("Failed to assert InsertedID as primitive.ObjectID")
+	}
+
+	// If the assertion succeeds, id contains the ObjectID value
+	return &id, nil
+}
+
+// GetByID retrieves a transaction from the database using the ID
+func (s *MongoStore) GetByID(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
+	// Setup the database request
+	collection := database.GetCollection("transactions")
+	filter := bson.M{"_id": id}
+
+	// Get the transaction from the collection "transactions"
+	var transaction Transaction
+	err := collection.FindOne(ctx, filter).Decode(&transaction)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// If no error was received, return the transaction
+	return &transaction, nil
+}
+
+// Update replaces the stored transaction if its version did not change since it was read
+func (s *MongoStore) Update(ctx context.Context, transaction *Transaction) error {
+	// Setup the database request, only matching the version that was read
+	collection := database.GetCollection("transactions")
+	filter := bson.M{"_id": transaction.ID, "version": transaction.Version}
+	if transaction.Version == 0 {
+		// Documents written before versioning existed do not have the field at all
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	// Replace the document with its next version
+	updated := *transaction
+	updated.Version++
+	updateResult, err := collection.ReplaceOne(ctx, filter, updated)
+	if err != nil {
+		return err
+	}
+
+	// Find out why nothing matched when the replacement did not happen
+	if updateResult.MatchedCount == 0 {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": transaction.ID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+
+	transaction.Version = updated.Version
+	return nil
+}
+
+// Delete removes a transaction from the database
+func (s *MongoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	// Setup the database request
+	collection := database.GetCollection("transactions")
+	filter := bson.M{"_id": id}
+
+	// Delete the transaction from the database
+	deleteResult, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	// Check if a transaction was deleted from the database
+	if deleteResult.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	// If an entry was deleted, return without an error
+	return nil
+}
+
+// List returns the transactions matching the filter, newest first
+func (s *MongoStore) List(ctx context.Context, filter Filter, before primitive.ObjectID, limit int) ([]Transaction, error) {
+	// Setup the database request
+	collection := database.GetCollection("transactions")
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+
+	// Find the transactions in the collection "transactions"
+	cursor, err := collection.Find(ctx, filter.query(before), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Transaction{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
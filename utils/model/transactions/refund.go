@@ -0,0 +1,189 @@
+package transactions
+
+import (
+	"context"
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotRefundable is returned when refunding a transaction that has not been paid
+	ErrNotRefundable = errors.New("transaction can not be refunded")
+
+	// ErrRefundExceeded is returned when a refund is larger than the amount that is left to refund
+	ErrRefundExceeded = errors.New("refund exceeds the refundable amount")
+
+	// ErrCurrencyMismatch is returned when a refund is not in the currency of its transaction
+	ErrCurrencyMismatch = errors.New("refund currency does not match the transaction")
+
+	// ErrRefundNotFound is returned when a transaction has no refund with the requested ID
+	ErrRefundNotFound = errors.New("refund not found")
+)
+
+// maxReasonLength limits the length of a refund reason
+const maxReasonLength = 255
+
+// RefundStatus describes where a refund is in its lifecycle
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Refund represents a full or partial refund stored inside its transaction. Outcome is the status a
+// pending refund settles to.
+type Refund struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Amount    money.Money        `bson:"amount"`
+	Reason    string             `bson:"reason,omitempty"`
+	Status    RefundStatus       `bson:"status"`
+	Outcome   RefundStatus       `bson:"outcome,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// RefundInput represents the JSON data received to refund a transaction. Without an amount the
+// remaining refundable amount is refunded. Outcome chooses how the refund settles, it defaults to
+// succeeded.
+type RefundInput struct {
+	Amount  *money.Money `json:"amount"`
+	Reason  string       `json:"reason"`
+	Outcome RefundStatus `json:"outcome"`
+}
+
+// Validate checks the parts of the input that do not depend on the transaction. It returns
+// validation.Errors listing every rejected field, or nil when the input is valid.
+func (input RefundInput) Validate() error {
+	var errs validation.Errors
+
+	if input.Amount != nil && input.Amount.Value <= 0 {
+		errs.Add("amount.value", validation.CodeOutOfRange, "amount must be positive")
+	}
+	if input.Outcome != "" && input.Outcome != RefundSucceeded && input.Outcome != RefundFailed {
+		errs.Add("outcome", validation.CodeInvalid, "outcome must be succeeded or failed")
+	}
+	errs.MaxLength("reason", input.Reason, maxReasonLength)
+
+	return errs.Err()
+}
+
+// RefundedAmount returns the sum of the refunds that are pending or succeeded
+func (t *Transaction) RefundedAmount() money.Money {
+	refunded := money.New(0, t.Amount.Currency)
+	for _, refund := range t.Refunds {
+		if refund.Status != RefundFailed {
+			refunded = refunded.Add(refund.Amount)
+		}
+	}
+	return refunded
+}
+
+// RefundableAmount returns the amount that is left to refund, which is zero for unpaid transactions
+func (t *Transaction) RefundableAmount() money.Money {
+	return t.PaidAmount().Sub(t.RefundedAmount())
+}
+
+// AddRefund adds a pending refund to a paid transaction. A nil amount refunds everything that
+// is left to refund.
+func (t *Transaction) AddRefund(amount *money.Money, reason string, at time.Time) (*Refund, error) {
+	if t.Status != StatusPaid {
+		return nil, fmt.Errorf("%w with status %s", ErrNotRefundable, t.Status)
+	}
+
+	// Default to the remaining amount and keep the total within the paid or captured amount
+	refundable := t.RefundableAmount()
+	if amount == nil {
+		amount = &refundable
+	}
+	if amount.Currency != t.Amount.Currency {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrCurrencyMismatch, t.Amount.Currency, amount.Currency)
+	}
+	if amount.Value <= 0 || amount.Value > refundable.Value {
+		return nil, fmt.Errorf("%w of %s", ErrRefundExceeded, refundable)
+	}
+
+	t.Refunds = append(t.Refunds, Refund{
+		ID:        primitive.NewObjectID(),
+		Amount:    *amount,
+		Reason:    reason,
+		Status:    RefundPending,
+		CreatedAt: at,
+		UpdatedAt: at,
+	})
+	t.UpdatedAt = at
+	return &t.Refunds[len(t.Refunds)-1], nil
+}
+
+// FindRefund returns the refund of the transaction with the given ID, or nil if there is none
+func (t *Transaction) FindRefund(id primitive.ObjectID) *Refund {
+	for i := range t.Refunds {
+		if t.Refunds[i].ID == id {
+			return &t.Refunds[i]
+		}
+	}
+	return nil
+}
+
+// SettlesTo returns the status the refund settles to, refunds stored without an outcome succeed
+func (r *Refund) SettlesTo() RefundStatus {
+	if r.Outcome == "" {
+		return RefundSucceeded
+	}
+	return r.Outcome
+}
+
+// Settle moves a pending refund to succeeded or failed
+func (r *Refund) Settle(to RefundStatus, at time.Time) error {
+	if r.Status != RefundPending || (to != RefundSucceeded && to != RefundFailed) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, r.Status, to)
+	}
+
+	r.Status = to
+	r.UpdatedAt = at
+	return nil
+}
+
+// CreateRefund adds a pending refund to a stored transaction, which settles to the given outcome,
+// and returns both
+func CreateRefund(ctx context.Context, id primitive.ObjectID, amount *money.Money, reason string, outcome RefundStatus) (*Transaction, *Refund, error) {
+	var refund *Refund
+	transaction, err := Modify(ctx, id, func(transaction *Transaction) error {
+		var err error
+		if refund, err = transaction.AddRefund(amount, reason, time.Now()); err != nil {
+			return err
+		}
+		refund.Outcome = outcome
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return transaction, refund, nil
+}
+
+// SettleRefund moves a pending refund of a stored transaction to its outcome
+func SettleRefund(ctx context.Context, id, refundID primitive.ObjectID) (*Transaction, *Refund, error) {
+	var refund *Refund
+	transaction, err := Modify(ctx, id, func(transaction *Transaction) error {
+		now := time.Now()
+		if refund = transaction.FindRefund(refundID); refund == nil {
+			return ErrRefundNotFound
+		}
+		if err := refund.Settle(refund.SettlesTo(), now); err != nil {
+			return err
+		}
+		transaction.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return transaction, refund, nil
+}
@@ -0,0 +1,105 @@
+package transactions
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIllegalTransition is returned when a status change is not allowed by the transaction lifecycle
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// Status describes where a transaction is in its lifecycle
+type Status string
+
+const (
+	StatusOpen       Status = "open"
+	StatusPending    Status = "pending"
+	StatusAuthorized Status = "authorized"
+	StatusPaid       Status = "paid"
+	StatusFailed     Status = "failed"
+	StatusCanceled   Status = "canceled"
+	StatusExpired    Status = "expired"
+)
+
+// Statuses lists every status of the lifecycle
+var Statuses = []Status{StatusOpen, StatusPending, StatusAuthorized, StatusPaid, StatusFailed, StatusCanceled, StatusExpired}
+
+// transitions lists the statuses a transaction is allowed to move to from a given status.
+// Statuses without an entry are final.
+var transitions = map[Status][]Status{
+	StatusOpen:       {StatusPending, StatusAuthorized, StatusPaid, StatusFailed, StatusCanceled, StatusExpired},
+	StatusPending:    {StatusAuthorized, StatusPaid, StatusFailed, StatusCanceled, StatusExpired},
+	StatusAuthorized: {StatusPaid, StatusCanceled, StatusExpired},
+}
+
+// StatusChange records a single transition in the history of a transaction. Forced changes were
+// made by an admin and did not have to follow the lifecycle.
+type StatusChange struct {
+	From   Status    `bson:"from,omitempty"`
+	To     Status    `bson:"to"`
+	At     time.Time `bson:"at"`
+	Forced bool      `bson:"forced,omitempty"`
+}
+
+// Valid reports whether the status is part of the lifecycle
+func (s Status) Valid() bool {
+	switch s {
+	case StatusOpen, StatusPending, StatusAuthorized, StatusPaid, StatusFailed, StatusCanceled, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// Final reports whether no further transitions are possible from the status
+func (s Status) Final() bool {
+	return len(transitions[s]) == 0
+}
+
+// CanTransitionTo reports whether the lifecycle allows moving from s to the given status
+func (s Status) CanTransitionTo(to Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the transaction to a new status and records the change in its history
+func (t *Transaction) Transition(to Status, at time.Time) error {
+	if !t.Status.CanTransitionTo(to) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, t.Status, to)
+	}
+
+	t.History = append(t.History, StatusChange{From: t.Status, To: to, At: at})
+	t.Status = to
+	t.UpdatedAt = at
+	return nil
+}
+
+// Force moves the transaction to any other status regardless of the lifecycle and records the change
+// as forced. Forcing a transaction with manual capture to paid captures the full amount.
+func (t *Transaction) Force(to Status, at time.Time) error {
+	if !to.Valid() || to == t.Status {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, t.Status, to)
+	}
+
+	t.History = append(t.History, StatusChange{From: t.Status, To: to, At: at, Forced: true})
+	t.Status = to
+	t.UpdatedAt = at
+	if to == StatusPaid && t.CaptureMethod == CaptureManual && t.Captured.Value == 0 {
+		t.Captured = t.Amount
+	}
+	return nil
+}
+
+// ChangedAt returns when the transaction entered the given status, or the zero time if it never did
+func (t *Transaction) ChangedAt(status Status) time.Time {
+	for i := len(t.History) - 1; i >= 0; i-- {
+		if t.History[i].To == status {
+			return t.History[i].At
+		}
+	}
+	return time.Time{}
+}
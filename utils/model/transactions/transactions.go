@@ -2,98 +2,234 @@ package transactions
 
 import (
 	"context"
+	"dev-payment-gate/utils/money"
 	"errors"
-	"dev-payment-gate/utils/database"
+	"sync"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Transaction represents the BSON data stored in the transaction collection
+var (
+	// ErrNotFound is returned by a TransactionStore when the requested transaction does not exist
+	ErrNotFound = errors.New("transaction not found")
+
+	// ErrConflict is returned by a TransactionStore when a transaction was modified since it was read
+	ErrConflict = errors.New("transaction was modified concurrently")
+)
+
+// maxUpdateAttempts limits how often Modify retries after a concurrent modification
+const maxUpdateAttempts = 5
+
+// API names the API a transaction was created with. Transactions of an emulated payment service
+// provider API redirect and notify their webhook the way that provider does.
+type API string
+
+const (
+	APINative         API = ""
+	APIMollie         API = "mollie"
+	APIStripe         API = "stripe"
+	APIStripeCheckout API = "stripe_checkout"
+)
+
+// Transaction represents the BSON data stored in the transaction collection. Metadata holds the
+// JSON metadata given through an emulated API.
 type Transaction struct {
 	ID			primitive.ObjectID `bson:"_id,omitempty"`
-	Amount		float64			   `bson:"amount"`
+	MerchantID	primitive.ObjectID `bson:"merchant_id,omitempty"`
+	Mode		string			   `bson:"mode,omitempty"`
+	Amount		money.Money		   `bson:"amount"`
 	WebhookURL	string			   `bson:"webhook_url"`
 	WebhookKey	string			   `bson:"webhook_key"`
 	RedirectURL	string			   `bson:"redirect_url"`
+	CancelURL	string			   `bson:"cancel_url,omitempty"`
+	CaptureMethod	CaptureMethod	   `bson:"capture_method"`
+	Captured	money.Money		   `bson:"captured"`
+	Reference	string			   `bson:"reference,omitempty"`
+	Metadata	string			   `bson:"metadata,omitempty"`
+	API		API			   `bson:"api,omitempty"`
 	Timestamp	time.Time		   `bson:"timestamp"`
+	ExpiresAt	time.Time		   `bson:"expires_at"`
+	Status		Status			   `bson:"status"`
+	History		[]StatusChange	   `bson:"history"`
+	Refunds		[]Refund		   `bson:"refunds"`
+	UpdatedAt	time.Time		   `bson:"updated_at"`
+	Version		int64			   `bson:"version"`
 }
 
 // TransactionInput represents the JSON data received to initialize a transaction
 type TransactionInput struct {
-	Amount		float64	`json:"amount"`
+	Amount		money.Money	`json:"amount"`
 	WebhookURL	string	`json:"webhook_url"`
 	WebhookKey	string	`json:"webhook_key"`
 	RedirectURL	string	`json:"redirect_url"`
+	Reference	string	`json:"reference"`
+	CaptureMethod	CaptureMethod	`json:"capture_method"`
+	ExpiresAt	*time.Time `json:"expires_at"`
+}
+
+// TransactionStore is implemented by every backend that is able to persist transactions
+type TransactionStore interface {
+	// Insert stores a transaction and returns its object id
+	Insert(ctx context.Context, transaction *Transaction) (*primitive.ObjectID, error)
+
+	// GetByID retrieves a transaction using its ID
+	GetByID(ctx context.Context, id primitive.ObjectID) (*Transaction, error)
+
+	// Update replaces a stored transaction and increments its version. It returns ErrConflict
+	// when the stored version no longer matches the version of the given transaction.
+	Update(ctx context.Context, transaction *Transaction) error
+
+	// Delete removes a transaction using its ID
+	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	// List returns up to limit transactions matching the filter, newest first. When before is not
+	// zero, only transactions with an ID lower than before are returned.
+	List(ctx context.Context, filter Filter, before primitive.ObjectID, limit int) ([]Transaction, error)
+}
+
+var (
+	store     TransactionStore
+	storeLock sync.RWMutex
+)
+
+// SetStore selects the backend used by the package level transaction operations
+func SetStore(s TransactionStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	store = s
 }
 
-// Create initializes a new transaction object
+// getStore returns the selected backend or an error if none has been configured
+func getStore() (TransactionStore, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	if store == nil {
+		return nil, errors.New("no transaction store has been configured")
+	}
+	return store, nil
+}
+
+// Create initializes a new transaction object. ExpiresAt stays zero when the input does not set it.
 func Create(input TransactionInput) Transaction {
+	now := time.Now()
+	var expiresAt time.Time
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+	captureMethod := input.CaptureMethod
+	if captureMethod == "" {
+		captureMethod = CaptureAutomatic
+	}
 	return Transaction{
-		Amount:      input.Amount,
-		WebhookURL:  input.WebhookURL,
-		WebhookKey:  input.WebhookKey,
-		RedirectURL: input.RedirectURL,
-		Timestamp:   time.Now(),
+		Amount:        input.Amount,
+		WebhookURL:    input.WebhookURL,
+		WebhookKey:    input.WebhookKey,
+		RedirectURL:   input.RedirectURL,
+		CaptureMethod: captureMethod,
+		Reference:     input.Reference,
+		Timestamp:     now,
+		ExpiresAt:     expiresAt,
+		Status:        StatusOpen,
+		History:       []StatusChange{{To: StatusOpen, At: now}},
+		UpdatedAt:     now,
 	}
 }
 
-// Insert stores a transaction into the database and returns its object id
+// Insert stores a transaction in the selected store and returns its object id
 func Insert(ctx context.Context, transaction *Transaction) (*primitive.ObjectID, error) {
-	// Setup the database request
-	collection := database.GetCollection("transactions")
-
-	// Insert the transaction into the collection "transactions"
-	insertOneResult , err := collection.InsertOne(ctx, transaction)
+	s, err := getStore()
 	if err != nil {
 		return nil, err
 	}
-
-	// Assert the InsertedID as a primitive.ObjectID
-	id, ok := insertOneResult.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, errors.New("Failed to assert InsertedID as primitive.ObjectID")
-	}
-
-	// If the assertion succeeds, id contains the ObjectID value
-	return &id, nil
+	return s.Insert(ctx, transaction)
 }
 
-// GetByID retrieves a transaction from the database using the ID
+// GetByID retrieves a transaction from the selected store using the ID
 func GetByID(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
-	// Setup the database request
-	collection := database.GetCollection("transactions")
-	filter := bson.M{"_id": id}
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByID(ctx, id)
+}
 
-	// Get the transaction from the collection "transactions"
-	var transaction Transaction
-	err := collection.FindOne(ctx, filter).Decode(&transaction)
+// Modify reads a transaction, applies change to it and writes it back to the selected store.
+// The read-modify-write cycle is retried when the transaction is modified concurrently. A change
+// of status is published to the subscribers of the transaction.
+func Modify(ctx context.Context, id primitive.ObjectID, change func(*Transaction) error) (*Transaction, error) {
+	s, err := getStore()
 	if err != nil {
 		return nil, err
 	}
-	
-	// If no error was received, return the transaction
-	return &transaction, nil
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		// Read the latest version of the transaction
+		transaction, err := s.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		status := transaction.Status
+
+		// Apply the change in memory
+		if err := change(transaction); err != nil {
+			return nil, err
+		}
+
+		// Write it back, starting over when somebody else was faster
+		err = s.Update(ctx, transaction)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Tell the subscribers about a new status
+		if transaction.Status != status {
+			publish(transaction)
+		}
+		return transaction, nil
+	}
+
+	return nil, ErrConflict
+}
+
+// UpdateStatus moves a stored transaction to a new status if the lifecycle allows it
+func UpdateStatus(ctx context.Context, id primitive.ObjectID, to Status) (*Transaction, error) {
+	return Modify(ctx, id, func(transaction *Transaction) error {
+		return transaction.Transition(to, time.Now())
+	})
 }
 
-// Delete removes a transaction from the database
-func Delete(ctx context.Context, id primitive.ObjectID) (error) {
-	// Setup the database request
-	collection := database.GetCollection("transactions")
-	filter := bson.M{"_id": id}
+// ForceStatus moves a stored transaction to any other status regardless of the lifecycle
+func ForceStatus(ctx context.Context, id primitive.ObjectID, to Status) (*Transaction, error) {
+	return Modify(ctx, id, func(transaction *Transaction) error {
+		return transaction.Force(to, time.Now())
+	})
+}
 
-	// Delete the transaction from the database
-	deleteResult, err := collection.DeleteOne(ctx, filter)
+// Expired reports whether the transaction is still awaiting payment or capture after its expiry time
+func (t *Transaction) Expired(now time.Time) bool {
+	return (t.Status == StatusOpen || t.Status == StatusPending || t.Status == StatusAuthorized) && !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// Delete removes a transaction from the selected store
+func Delete(ctx context.Context, id primitive.ObjectID) error {
+	s, err := getStore()
 	if err != nil {
 		return err
 	}
+	return s.Delete(ctx, id)
+}
 
-	// Check if a transaction was deleted from the database
-	if deleteResult.DeletedCount == 0 {
-		return errors.New("There was not a transaction to be deleted")
+// List returns a page of transactions matching the filter from the selected store
+func List(ctx context.Context, filter Filter, before primitive.ObjectID, limit int) ([]Transaction, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
 	}
-
-	// If an entry was deleted, return without an error
-	return nil
+	return s.List(ctx, filter, before, limit)
 }
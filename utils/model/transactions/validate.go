@@ -0,0 +1,67 @@
+package transactions
+
+import (
+	"dev-payment-gate/utils/money"
+	"dev-payment-gate/utils/validation"
+	"time"
+)
+
+const (
+	// maxAmountDigits limits amounts to less than 10^maxAmountDigits major units
+	maxAmountDigits = 8
+
+	// maxReferenceLength limits the length of a merchant reference
+	maxReferenceLength = 255
+
+	// maxWebhookKeyLength limits the length of a webhook key
+	maxWebhookKeyLength = 255
+)
+
+// maxAmount returns the largest amount in minor units accepted for a currency
+func maxAmount(currency string) int64 {
+	exponent, _ := money.Exponent(currency)
+	max := int64(1)
+	for i := 0; i < maxAmountDigits+exponent; i++ {
+		max *= 10
+	}
+	return max - 1
+}
+
+// ValidateAmount adds an error to errs unless the amount of a transaction is given in a known
+// currency, positive and within bounds. Errors about the value are reported for field + ".value".
+func ValidateAmount(errs *validation.Errors, field string, amount money.Money) {
+	switch {
+	case amount.Currency == "":
+		errs.Add(field, validation.CodeRequired, "%s is required", field)
+	case amount.Value <= 0:
+		errs.Add(field+".value", validation.CodeOutOfRange, "amount must be positive")
+	case amount.Value > maxAmount(amount.Currency):
+		errs.Add(field+".value", validation.CodeOutOfRange, "amount may not exceed %s", money.New(maxAmount(amount.Currency), amount.Currency))
+	}
+}
+
+// Validate checks the input before a transaction is created from it. It returns validation.Errors
+// listing every rejected field, or nil when the input is valid.
+func (input TransactionInput) Validate(now time.Time) error {
+	var errs validation.Errors
+
+	ValidateAmount(&errs, "amount", input.Amount)
+
+	// Both URLs are called or visited later on, so they have to be absolute
+	errs.URL("webhook_url", input.WebhookURL, true)
+	errs.URL("redirect_url", input.RedirectURL, true)
+
+	errs.MaxLength("webhook_key", input.WebhookKey, maxWebhookKeyLength)
+	errs.MaxLength("reference", input.Reference, maxReferenceLength)
+
+	if input.CaptureMethod != "" && input.CaptureMethod != CaptureAutomatic && input.CaptureMethod != CaptureManual {
+		errs.Add("capture_method", validation.CodeInvalid, "capture_method must be automatic or manual")
+	}
+
+	// Expiry times have to be in the future
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		errs.Add("expires_at", validation.CodeOutOfRange, "expires_at must be in the future")
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,233 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for amounts that are given as a bare number
+const DefaultCurrency = "EUR"
+
+// maxIntegerDigits keeps amounts within the range of an int64 in minor units
+const maxIntegerDigits = 15
+
+var (
+	// ErrUnknownCurrency is returned for currency codes that are not supported
+	ErrUnknownCurrency = errors.New("unknown currency")
+
+	// ErrInvalidAmount is returned for amounts that are not a plain decimal number
+	ErrInvalidAmount = errors.New("invalid amount")
+
+	// ErrPrecision is returned for amounts with more decimals than their currency allows
+	ErrPrecision = errors.New("too many decimals for currency")
+)
+
+// currency describes how amounts in an ISO 4217 currency are written
+type currency struct {
+	// exponent is the number of decimals of the minor unit
+	exponent int
+
+	// symbol is written in front of formatted amounts
+	symbol string
+}
+
+// currencies lists the supported ISO 4217 currencies
+var currencies = map[string]currency{
+	"AUD": {2, "A$"},
+	"BGN": {2, "лв "},
+	"BHD": {3, "BD "},
+	"BRL": {2, "R$"},
+	"CAD": {2, "CA$"},
+	"CHF": {2, "CHF "},
+	"CNY": {2, "CN¥"},
+	"CZK": {2, "Kč "},
+	"DKK": {2, "kr "},
+	"EUR": {2, "€"},
+	"GBP": {2, "£"},
+	"HKD": {2, "HK$"},
+	"HUF": {2, "Ft "},
+	"INR": {2, "₹"},
+	"ISK": {0, "kr "},
+	"JPY": {0, "¥"},
+	"KRW": {0, "₩"},
+	"KWD": {3, "KD "},
+	"MXN": {2, "MX$"},
+	"NOK": {2, "kr "},
+	"NZD": {2, "NZ$"},
+	"PLN": {2, "zł "},
+	"RON": {2, "lei "},
+	"SEK": {2, "kr "},
+	"SGD": {2, "S$"},
+	"TRY": {2, "₺"},
+	"USD": {2, "$"},
+	"ZAR": {2, "R "},
+}
+
+// Money is an exact amount in the minor unit of an ISO 4217 currency, e.g. 495 EUR cents
+type Money struct {
+	Value    int64  `bson:"value"`
+	Currency string `bson:"currency"`
+}
+
+// New creates an amount from its value in minor units
+func New(value int64, currency string) Money {
+	return Money{Value: value, Currency: currency}
+}
+
+// Exponent returns the number of decimals of a currency and whether the currency is supported
+func Exponent(code string) (int, bool) {
+	c, ok := currencies[code]
+	return c.exponent, ok
+}
+
+// Parse converts a decimal string such as "4.95" in the given currency into an exact amount
+func Parse(value, code string) (Money, error) {
+	c, ok := currencies[code]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %q", ErrUnknownCurrency, code)
+	}
+
+	// Split the sign, integer part and fraction
+	digits := strings.TrimPrefix(value, "-")
+	negative := len(digits) != len(value)
+	integer, fraction, _ := strings.Cut(digits, ".")
+	if integer == "" || !onlyDigits(integer) || !onlyDigits(fraction) || strings.HasSuffix(digits, ".") {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, value)
+	}
+	if len(strings.TrimLeft(integer, "0")) > maxIntegerDigits {
+		return Money{}, fmt.Errorf("%w %q: too large", ErrInvalidAmount, value)
+	}
+
+	// Trailing zeros never lose precision
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > c.exponent {
+		return Money{}, fmt.Errorf("%w: %s allows %d decimals, got %q", ErrPrecision, code, c.exponent, value)
+	}
+	fraction += strings.Repeat("0", c.exponent-len(fraction))
+
+	// Combine the parts into minor units
+	minor, err := strconv.ParseInt(integer+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, value)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Value: minor, Currency: code}, nil
+}
+
+// onlyDigits reports whether s only contains the characters 0-9
+func onlyDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the amount as a decimal string with exactly the decimals of its currency, e.g. "4.95"
+func (m Money) String() string {
+	exponent, _ := Exponent(m.Currency)
+
+	value := m.Value
+	sign := ""
+	if value < 0 {
+		sign, value = "-", -value
+	}
+
+	digits := strconv.FormatInt(value, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// Format returns the amount with its currency symbol for display, e.g. "€4.95"
+func (m Money) Format() string {
+	c, ok := currencies[m.Currency]
+	if !ok {
+		return fmt.Sprintf("%s %s", m.String(), m.Currency)
+	}
+	return c.symbol + m.String()
+}
+
+// Add returns the sum of two amounts in the same currency
+func (m Money) Add(other Money) Money {
+	return Money{Value: m.Value + other.Value, Currency: m.Currency}
+}
+
+// Sub returns the difference of two amounts in the same currency
+func (m Money) Sub(other Money) Money {
+	return Money{Value: m.Value - other.Value, Currency: m.Currency}
+}
+
+// jsonMoney is the JSON representation of an amount
+type jsonMoney struct {
+	Currency string          `json:"currency"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the amount as {"currency": "EUR", "value": "4.95"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	value, err := json.Marshal(m.String())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMoney{Currency: m.Currency, Value: value})
+}
+
+// UnmarshalJSON decodes an amount from {"currency": "EUR", "value": "4.95"} or from a bare number in
+// the default currency. The decimal text is parsed directly, so no precision is lost to floats.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	// A bare number uses the default currency
+	if len(data) > 0 && data[0] != '{' {
+		parsed, err := parseJSONNumber(data, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	// An object carries its own currency
+	var object jsonMoney
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	parsed, err := parseJSONNumber(object.Value, object.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// parseJSONNumber parses a JSON number or string holding a decimal amount
+func parseJSONNumber(data []byte, code string) (Money, error) {
+	var text string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return Money{}, err
+		}
+	} else {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return Money{}, fmt.Errorf("%w %s", ErrInvalidAmount, data)
+		}
+		text = number.String()
+	}
+	return Parse(text, code)
+}
@@ -0,0 +1,81 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Codes describing why a field was rejected
+const (
+	CodeRequired     = "required"
+	CodeInvalid      = "invalid"
+	CodeInvalidType  = "invalid_type"
+	CodeInvalidURL   = "invalid_url"
+	CodeOutOfRange   = "out_of_range"
+	CodeTooLong      = "too_long"
+	CodeUnknownField = "unknown_field"
+)
+
+// maxURLLength limits the length of URLs accepted by URL
+const maxURLLength = 2048
+
+// FieldError describes why the value of a single field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors collects the field errors found while validating an input. It implements error so
+// validation functions can return it, a nil Errors means the input is valid.
+type Errors []FieldError
+
+// Error joins the messages of all field errors
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Field, err.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a field error
+func (errs *Errors) Add(field, code, format string, args ...interface{}) {
+	*errs = append(*errs, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the collected errors, or nil when there are none
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// URL checks that value is an absolute http or https URL. Empty values are only accepted when
+// the field is not required.
+func (errs *Errors) URL(field, value string, required bool) {
+	if value == "" {
+		if required {
+			errs.Add(field, CodeRequired, "%s is required", field)
+		}
+		return
+	}
+	if len(value) > maxURLLength {
+		errs.Add(field, CodeTooLong, "%s may not be longer than %d characters", field, maxURLLength)
+		return
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs.Add(field, CodeInvalidURL, "%s must be an absolute http or https URL", field)
+	}
+}
+
+// MaxLength checks that value is not longer than max characters
+func (errs *Errors) MaxLength(field, value string, max int) {
+	if len(value) > max {
+		errs.Add(field, CodeTooLong, "%s may not be longer than %d characters", field, max)
+	}
+}
@@ -0,0 +1,198 @@
+// Renders the OpenAPI document served at /openapi.json as a browsable page. Everything is built
+// with DOM methods so no text from the document is ever interpreted as HTML.
+
+// el creates an element with a class and optional children, strings become text nodes
+function el(tag, className, ...children) {
+    const node = document.createElement(tag);
+    if (className) {
+        node.className = className;
+    }
+    for (const child of children) {
+        node.append(child instanceof Node ? child : document.createTextNode(String(child)));
+    }
+    return node;
+}
+
+// refName returns the name a local $ref points to, such as "Money" for "#/components/schemas/Money"
+function refName(ref) {
+    return ref.split("/").pop();
+}
+
+// resolve follows a local $ref such as "#/components/responses/NotFound" within the document
+function resolve(spec, value) {
+    while (value && value.$ref) {
+        value = value.$ref.slice(2).split("/").reduce((node, key) => node && node[key], spec);
+    }
+    return value || {};
+}
+
+// schemaLink renders a schema as a link to its definition or as a short type description
+function schemaLink(schema) {
+    if (!schema) {
+        return el("span", "docs-type", "any");
+    }
+    if (schema.$ref) {
+        const link = el("a", "docs-type", refName(schema.$ref));
+        link.href = "#schema-" + refName(schema.$ref);
+        return link;
+    }
+    if (schema.oneOf) {
+        const span = el("span", "docs-type");
+        schema.oneOf.forEach((alternative, i) => {
+            if (i > 0) {
+                span.append(" | ");
+            }
+            span.append(schemaLink(alternative));
+        });
+        return span;
+    }
+    const type = [].concat(schema.type || "object").join(" | ");
+    if (type === "array") {
+        return el("span", "docs-type", "array of ", schemaLink(schema.items));
+    }
+    let text = type;
+    if (schema.format) {
+        text += " (" + schema.format + ")";
+    }
+    if (schema.enum) {
+        text += ": " + schema.enum.join(", ");
+    }
+    return el("span", "docs-type", text);
+}
+
+// propertiesTable renders the properties of an object schema with their types and descriptions
+function propertiesTable(schema) {
+    const table = el("table", "docs-table");
+    const required = new Set(schema.required || []);
+    for (const [name, property] of Object.entries(schema.properties || {})) {
+        table.append(el("tr", "",
+            el("td", "docs-name", name, required.has(name) ? el("span", "docs-required", " required") : ""),
+            el("td", "", schemaLink(property)),
+            el("td", "", property.description || "")));
+    }
+    return table;
+}
+
+// renderContent renders the media types and schemas of a request body or response
+function renderContent(content) {
+    const list = el("div", "docs-content");
+    for (const [mediaType, media] of Object.entries(content || {})) {
+        list.append(el("div", "", el("code", "", mediaType), " ", schemaLink(media.schema)));
+        if (media.example !== undefined) {
+            list.append(el("pre", "docs-example", JSON.stringify(media.example, null, 2)));
+        }
+    }
+    return list;
+}
+
+// renderOperation renders a single operation with its parameters, request body and responses
+function renderOperation(spec, path, method, operation) {
+    const id = operation.operationId || method + path;
+    const details = el("details", "docs-operation");
+    details.id = id;
+    details.append(el("summary", "",
+        el("span", "docs-method docs-method-" + method, method.toUpperCase()),
+        el("code", "", path), " ",
+        el("span", "", operation.summary || "")));
+
+    if (operation.description) {
+        details.append(el("p", "", operation.description));
+    }
+
+    // Parameters of the path item and of the operation itself
+    const parameters = (spec.paths[path].parameters || []).concat(operation.parameters || []).map((p) => resolve(spec, p));
+    if (parameters.length > 0) {
+        const table = el("table", "docs-table");
+        for (const parameter of parameters) {
+            table.append(el("tr", "",
+                el("td", "docs-name", parameter.name, parameter.required ? el("span", "docs-required", " required") : ""),
+                el("td", "", parameter.in),
+                el("td", "", schemaLink(parameter.schema)),
+                el("td", "", parameter.description || "")));
+        }
+        details.append(el("h4", "", "Parameters"), table);
+    }
+
+    if (operation.requestBody) {
+        const body = resolve(spec, operation.requestBody);
+        details.append(el("h4", "", "Request body"), renderContent(body.content));
+    }
+
+    const responses = el("table", "docs-table");
+    for (const [status, reference] of Object.entries(operation.responses || {})) {
+        const response = resolve(spec, reference);
+        responses.append(el("tr", "",
+            el("td", "docs-name", status),
+            el("td", "", response.description || ""),
+            el("td", "", renderContent(response.content))));
+    }
+    details.append(el("h4", "", "Responses"), responses);
+    return details;
+}
+
+// render fills the page from the OpenAPI document
+function render(spec) {
+    document.title = spec.info.title;
+    document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+    document.getElementById("description").textContent = spec.info.description || "";
+
+    // Operations grouped by their first tag
+    const groups = new Map();
+    for (const [path, item] of Object.entries(spec.paths)) {
+        for (const method of ["get", "post", "put", "patch", "delete"]) {
+            if (item[method]) {
+                const tag = (item[method].tags || ["default"])[0];
+                if (!groups.has(tag)) {
+                    groups.set(tag, []);
+                }
+                groups.get(tag).push(renderOperation(spec, path, method, item[method]));
+            }
+        }
+    }
+    const index = document.getElementById("index");
+    const operations = document.getElementById("operations");
+    for (const [tag, nodes] of groups) {
+        const link = el("a", "", tag);
+        link.href = "#tag-" + tag;
+        index.append(link);
+        const heading = el("h2", "", tag);
+        heading.id = "tag-" + tag;
+        operations.append(heading, ...nodes);
+    }
+
+    // Webhooks sent to merchants
+    const webhooks = document.getElementById("webhooks");
+    for (const [name, item] of Object.entries(spec.webhooks || {})) {
+        for (const [method, operation] of Object.entries(item)) {
+            webhooks.append(renderOperation({ ...spec, paths: { [name]: item } }, name, method, operation));
+        }
+    }
+
+    // Schemas of the components
+    const schemas = document.getElementById("schemas");
+    for (const [name, schema] of Object.entries(spec.components.schemas)) {
+        const section = el("div", "docs-schema", el("h3", "", name));
+        section.id = "schema-" + name;
+        if (schema.description) {
+            section.append(el("p", "", schema.description));
+        }
+        section.append(schema.properties ? propertiesTable(schema) : el("p", "", schemaLink(schema)));
+        schemas.append(section);
+    }
+
+    // Open the operation or schema the page was linked to
+    if (location.hash) {
+        const target = document.getElementById(location.hash.slice(1));
+        if (target) {
+            target.open = true;
+            target.scrollIntoView();
+        }
+    }
+}
+
+fetch("/openapi.json")
+    .then((response) => response.json())
+    .then(render)
+    .catch((error) => {
+        document.getElementById("description").textContent = "Failed to load the OpenAPI document: " + error;
+    });
@@ -1,29 +1,117 @@
 /**
- * Function to submit a payment
+ * Messages shown for the statuses of the transaction while following it
  */
-function pay() {
-    // Find the element with id 'fakePay-submit'
-    var fakePaySubmitButton = document.getElementById("fakePay-submit");
-    
-    // Remove the event listener to disable further clicks
-    fakePaySubmitButton.removeEventListener("click", pay);
+const statusMessages = {
+    pending: "Processing\u2026",
+    authorized: "Authorized",
+    paid: "Paid",
+    failed: "Failed",
+    canceled: "Canceled",
+    expired: "This transaction has expired"
+};
+
+/**
+ * Whether the status of the transaction is being followed, whether it was seen unfinished and
+ * whether the checkout is done
+ */
+var following = false;
+var waiting = false;
+var finished = false;
+
+/**
+ * Function to show the status of the transaction in place of the outcome buttons
+ */
+function showStatus(status) {
+    // Hide the outcome buttons, they are only shown for open transactions
+    var choice = document.querySelector(".fakePay-choice");
+    if (choice) {
+        choice.hidden = true;
+    }
+
+    // Show the message of the status
+    var element = document.getElementById("fakePay-status");
+    element.textContent = statusMessages[status] || status;
+    element.className = "";
+    if (status === "pending") {
+        element.classList.add("fakePay-processing");
+    } else if (status === "failed" || status === "canceled" || status === "expired") {
+        element.classList.add("fakePay-unsuccessful");
+    }
+    element.hidden = false;
+}
+
+/**
+ * Function to follow the status of the transaction and redirect once the checkout is done
+ */
+function follow() {
+    // Without Server-Sent Events the page keeps redirecting on the response of the outcome
+    if (!window.EventSource) {
+        return;
+    }
+
+    var source = new EventSource("/transaction/{{.ID}}/events");
+    source.addEventListener("open", () => following = true);
+    source.addEventListener("error", () => following = false);
+    source.addEventListener("status", event => {
+        var data = JSON.parse(event.data);
+
+        // Keep waiting while the transaction is unfinished
+        if (!data.done) {
+            waiting = true;
+            if (data.status !== "open") {
+                showStatus(data.status);
+            }
+            return;
+        }
+
+        // Stop following and redirect, unless the transaction was already finished when the page loaded
+        source.close();
+        following = false;
+        finished = true;
+        if (waiting) {
+            showStatus(data.status);
+            setTimeout(() => window.location.href = data.redirect_url, 1000);
+        }
+    });
+}
+
+/**
+ * Function to submit the outcome of a payment
+ */
+function pay(event) {
+    // Find all of the outcome buttons
+    var outcomeButtons = document.querySelectorAll(".fakePay-outcome");
+
+    // Remove the event listeners to disable further clicks
+    outcomeButtons.forEach(button => button.removeEventListener("click", pay));
 
     // Define the payment url
-    let url = `/transaction/{{.ID}}`;
+    let url = "/transaction/{{.ID}}";
 
-    // Perform the request
+    // Perform the request with the outcome of the clicked button
     fetch(url, {
         method: "POST",
         headers: {
             "Content-Type": "application/json"
-        }
+        },
+        body: JSON.stringify({ outcome: event.currentTarget.dataset.outcome })
     })
     .then(response => response.json()) // Parse the JSON response
     .then(data => {
         // Check if the response contains a 'url' field
         if (data && data.url) {
-            // Redirect the user to the received URL
-            window.location.href = data.url;
+            if (finished) {
+                // The status stream is already redirecting
+            } else if (following) {
+                // The status stream shows the progress and redirects once the payment is finished
+                showStatus("pending");
+            } else {
+                // Redirect the user to the received URL
+                window.location.href = data.url;
+            }
+        } else if (data && data.error) {
+            // Show why the outcome was rejected
+            console.error(`${data.error.code}: ${data.error.message}`);
         } else {
             console.error('Invalid response format');
         }
@@ -32,8 +120,8 @@ function pay() {
         console.error('Error:', error);
     })
     .finally(() => {
-        // Add back the event listener to re-enable clicks after the operation is completed
-        fakePaySubmitButton.addEventListener("click", pay);
+        // Add back the event listeners to re-enable clicks after the operation is completed
+        outcomeButtons.forEach(button => button.addEventListener("click", pay));
     });
 }
 
@@ -41,11 +129,14 @@ function pay() {
  * Event to add all of the javascript functionality after loading the DOM
  */
 document.addEventListener("DOMContentLoaded", function() {
-    // Find the element with id 'fakePay-submit'
-    var fakePaySubmitButton = document.getElementById("fakePay-submit");
+    // Find all of the outcome buttons, they are missing when the transaction is finished
+    var outcomeButtons = document.querySelectorAll(".fakePay-outcome");
+
+    // Add an onclick event listener to pay with the outcome of the button
+    outcomeButtons.forEach(button => button.addEventListener("click", pay));
 
-    // Add an onclick event listener to pay
-    fakePaySubmitButton.addEventListener("click", pay);
+    // Follow the status of the transaction
+    follow();
 
     // Show the fakepay element now that it has functionality
     document.querySelector(".fakePay").style.display = "block";